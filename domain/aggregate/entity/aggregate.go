@@ -0,0 +1,88 @@
+package entity
+
+import (
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/pkg/snowflake"
+
+	"strconv"
+)
+
+// Entity 可以被仓储保存的对象 mysql.BaseModel和AggregateRoot都实现了
+type Entity interface {
+	EntityId() uint64
+	SetEntityId(id uint64)
+}
+
+// Aggregate 聚合 仓储通过这些方法保存聚合和发布事件
+type Aggregate interface {
+	Entity
+	AggregateId() uint64
+	AggregateVersion() int64
+	SetAggregateVersion(version int64)
+	PendingEvents() []eventbus.Event
+	ClearEvents()
+}
+
+// AggregateRoot 聚合根 嵌入到具体的聚合中
+//
+//	type Order struct {
+//		entity.AggregateRoot
+//		Amount int64
+//	}
+type AggregateRoot struct {
+	Id      uint64           `gorm:"primary_key;column:id" json:"id"`
+	Version int64            `gorm:"column:version" json:"version"` //乐观锁版本 每次保存加1
+	events  []eventbus.Event //已经产生还没有发布的事件
+}
+
+// NewAggregateRoot 使用snowflake生成id
+func NewAggregateRoot() AggregateRoot {
+	return AggregateRoot{
+		Id: uint64(snowflake.BaseNumber()),
+	}
+}
+
+func (a *AggregateRoot) AggregateId() uint64 {
+	return a.Id
+}
+
+func (a *AggregateRoot) EntityId() uint64 {
+	return a.Id
+}
+
+func (a *AggregateRoot) SetEntityId(id uint64) {
+	a.Id = id
+}
+
+func (a *AggregateRoot) AggregateVersion() int64 {
+	return a.Version
+}
+
+// SetAggregateVersion 仓储保存成功后设置版本
+func (a *AggregateRoot) SetAggregateVersion(version int64) {
+	a.Version = version
+}
+
+// Record 记录聚合产生的事件 保存聚合后由仓储发布
+func (a *AggregateRoot) Record(events ...eventbus.Event) {
+	a.events = append(a.events, events...)
+}
+
+// PendingEvents 还没有发布的事件
+func (a *AggregateRoot) PendingEvents() []eventbus.Event {
+	return append([]eventbus.Event(nil), a.events...)
+}
+
+// ClearEvents 事件发布后清空
+func (a *AggregateRoot) ClearEvents() {
+	a.events = nil
+}
+
+// Source 事件来源 聚合实现了AggregateType时为type:id
+func Source(aggregate Aggregate) string {
+	id := strconv.FormatUint(aggregate.AggregateId(), 10)
+	if typed, ok := aggregate.(interface{ AggregateType() string }); ok {
+		return typed.AggregateType() + ":" + id
+	}
+	return id
+}
@@ -0,0 +1,66 @@
+package entity
+
+import (
+	"testing"
+)
+
+type orderPaid struct {
+	Amount int64 `json:"amount"`
+}
+
+func (orderPaid) EventType() string {
+	return "entity.order.paid"
+}
+
+func (orderPaid) EventVersion() int {
+	return 1
+}
+
+type order struct {
+	AggregateRoot
+}
+
+type typedOrder struct {
+	AggregateRoot
+}
+
+func (typedOrder) AggregateType() string {
+	return "order"
+}
+
+func TestAggregateRootRecordsEvents(t *testing.T) {
+	o := &order{AggregateRoot: NewAggregateRoot()}
+	if o.AggregateId() == 0 || o.EntityId() != o.AggregateId() {
+		t.Fatalf("expected a generated id, got %d", o.AggregateId())
+	}
+	o.Record(orderPaid{Amount: 1}, orderPaid{Amount: 2})
+	pending := o.PendingEvents()
+	if len(pending) != 2 || pending[1].(orderPaid).Amount != 2 {
+		t.Fatalf("unexpected pending events %v", pending)
+	}
+	//返回的是副本 修改不影响聚合
+	pending[0] = orderPaid{Amount: 100}
+	if o.PendingEvents()[0].(orderPaid).Amount != 1 {
+		t.Fatal("PendingEvents should return a copy")
+	}
+	o.ClearEvents()
+	if len(o.PendingEvents()) != 0 {
+		t.Fatal("ClearEvents should drop the pending events")
+	}
+	o.SetAggregateVersion(3)
+	if o.AggregateVersion() != 3 {
+		t.Fatalf("expected version 3, got %d", o.AggregateVersion())
+	}
+}
+
+func TestSource(t *testing.T) {
+	var _ Aggregate = new(order)
+	o := &order{AggregateRoot: AggregateRoot{Id: 42}}
+	if got := Source(o); got != "42" {
+		t.Fatalf("Source = %q, want 42", got)
+	}
+	typed := &typedOrder{AggregateRoot: AggregateRoot{Id: 42}}
+	if got := Source(typed); got != "order:42" {
+		t.Fatalf("Source = %q, want order:42", got)
+	}
+}
@@ -0,0 +1,26 @@
+package facade
+
+import (
+	"DDD/domain/aggregate/entity"
+
+	"errors"
+)
+
+var (
+	// ErrNotFound 没有找到
+	ErrNotFound = errors.New("记录不存在")
+	// ErrConcurrency 聚合的版本和保存时的版本不一致 说明聚合已经被其他请求修改
+	ErrConcurrency = errors.New("聚合已经被修改")
+)
+
+// Repository 仓储 T为实体的指针 例如*Order
+// T实现entity.Aggregate时保存会检查版本并发布聚合的事件
+type Repository[T entity.Entity] interface {
+	// FindByID 没有找到时返回ErrNotFound
+	FindByID(id uint64) (T, error)
+	// Save id为0时新增 否则更新
+	Save(item T) error
+	Delete(item T) error
+	// FindBy 按条件查询
+	FindBy(spec Specification[T]) ([]T, error)
+}
@@ -0,0 +1,85 @@
+package facade
+
+import (
+	"strings"
+)
+
+// Specification 查询条件 gorm仓储使用Query生成where 内存仓储使用IsSatisfiedBy过滤
+type Specification[T any] interface {
+	IsSatisfiedBy(item T) bool
+	Query() (string, []interface{})
+}
+
+type specification[T any] struct {
+	match func(item T) bool
+	query string
+	args  []interface{}
+}
+
+// Spec 创建查询条件 match和query需要表达同一个条件
+//
+//	facade.Spec(func(o *Order) bool { return o.Status == status }, "status = ?", status)
+func Spec[T any](match func(item T) bool, query string, args ...interface{}) Specification[T] {
+	return &specification[T]{
+		match: match,
+		query: query,
+		args:  args,
+	}
+}
+
+func (s *specification[T]) IsSatisfiedBy(item T) bool {
+	return s.match(item)
+}
+
+func (s *specification[T]) Query() (string, []interface{}) {
+	return s.query, s.args
+}
+
+// All 匹配全部
+func All[T any]() Specification[T] {
+	return Spec(func(item T) bool { return true }, "1 = 1")
+}
+
+// And 全部条件都满足
+func And[T any](specs ...Specification[T]) Specification[T] {
+	return combine("AND", specs, func(item T) bool {
+		for i := range specs[:] {
+			if !specs[i].IsSatisfiedBy(item) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Or 任意一个条件满足
+func Or[T any](specs ...Specification[T]) Specification[T] {
+	return combine("OR", specs, func(item T) bool {
+		for i := range specs[:] {
+			if specs[i].IsSatisfiedBy(item) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Not 条件不满足
+func Not[T any](spec Specification[T]) Specification[T] {
+	query, args := spec.Query()
+	return Spec(func(item T) bool { return !spec.IsSatisfiedBy(item) }, "NOT ("+query+")", args...)
+}
+
+func combine[T any](operator string, specs []Specification[T], match func(item T) bool) Specification[T] {
+	if len(specs) == 0 {
+		return All[T]()
+	}
+	queries := make([]string, 0, len(specs))
+	var args []interface{}
+	for i := range specs[:] {
+		query, a := specs[i].Query()
+		queries = append(queries, "("+query+")")
+		args = append(args, a...)
+	}
+	return Spec(match, strings.Join(queries, " "+operator+" "), args...)
+}
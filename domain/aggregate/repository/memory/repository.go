@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"DDD/domain/aggregate/entity"
+	"DDD/domain/aggregate/repository/facade"
+
+	"sort"
+	"sync"
+)
+
+// Publisher 保存聚合后发布事件 persistence.Dispatcher实现了
+type Publisher interface {
+	Publish(aggregates ...entity.Aggregate) error
+}
+
+// Repository 保存在内存中的仓储 用于不依赖mysql的单元测试
+// 保存和读取的都是副本 修改读取到的对象需要再次Save
+type Repository[T any, PT interface {
+	*T
+	entity.Entity
+}] struct {
+	items     map[uint64]T
+	nextId    uint64
+	publisher Publisher
+	sync.RWMutex
+}
+
+// NewRepository publisher为nil时不发布事件 outbox等需要事务的去向不会执行
+//
+//	repo := memory.NewRepository[Order](nil)
+func NewRepository[T any, PT interface {
+	*T
+	entity.Entity
+}](publisher Publisher) *Repository[T, PT] {
+	return &Repository[T, PT]{
+		items:     make(map[uint64]T),
+		publisher: publisher,
+	}
+}
+
+func (r *Repository[T, PT]) FindByID(id uint64) (PT, error) {
+	r.RLock()
+	defer r.RUnlock()
+	item, ok := r.items[id]
+	if !ok {
+		return nil, facade.ErrNotFound
+	}
+	return &item, nil
+}
+
+func (r *Repository[T, PT]) Save(item PT) error {
+	aggregate, isAggregate := any(item).(entity.Aggregate)
+	if err := r.save(item, aggregate, isAggregate); err != nil {
+		return err
+	}
+	if isAggregate && r.publisher != nil {
+		return r.publisher.Publish(aggregate)
+	}
+	return nil
+}
+
+func (r *Repository[T, PT]) save(item PT, aggregate entity.Aggregate, isAggregate bool) error {
+	r.Lock()
+	defer r.Unlock()
+	id := item.EntityId()
+	stored, exists := r.items[id]
+	if isAggregate {
+		var version int64
+		if exists {
+			version = any(&stored).(entity.Aggregate).AggregateVersion()
+		}
+		if version != aggregate.AggregateVersion() {
+			return facade.ErrConcurrency
+		}
+		aggregate.SetAggregateVersion(version + 1)
+	}
+	if id == 0 {
+		r.nextId++
+		id = r.nextId
+		item.SetEntityId(id)
+	}
+	if id > r.nextId {
+		r.nextId = id
+	}
+	copied := *item
+	if isAggregate {
+		//副本不保留待发布的事件
+		any(PT(&copied)).(entity.Aggregate).ClearEvents()
+	}
+	r.items[id] = copied
+	return nil
+}
+
+// Delete 聚合的版本和保存的不一致或已经被删除时返回ErrConcurrency 和gorm仓储一致
+func (r *Repository[T, PT]) Delete(item PT) error {
+	r.Lock()
+	defer r.Unlock()
+	id := item.EntityId()
+	if aggregate, ok := any(item).(entity.Aggregate); ok {
+		stored, exists := r.items[id]
+		if !exists || any(&stored).(entity.Aggregate).AggregateVersion() != aggregate.AggregateVersion() {
+			return facade.ErrConcurrency
+		}
+	}
+	delete(r.items, id)
+	return nil
+}
+
+// FindBy 按id排序返回
+func (r *Repository[T, PT]) FindBy(spec facade.Specification[PT]) ([]PT, error) {
+	r.RLock()
+	defer r.RUnlock()
+	var items []PT
+	for id := range r.items {
+		item := r.items[id]
+		if spec.IsSatisfiedBy(&item) {
+			items = append(items, &item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].EntityId() < items[j].EntityId()
+	})
+	return items, nil
+}
@@ -0,0 +1,210 @@
+package memory
+
+import (
+	"DDD/domain/aggregate/entity"
+	"DDD/domain/aggregate/repository/facade"
+
+	"errors"
+	"testing"
+)
+
+type orderPaid struct {
+	Amount int64 `json:"amount"`
+}
+
+func (orderPaid) EventType() string {
+	return "memory.order.paid"
+}
+
+func (orderPaid) EventVersion() int {
+	return 1
+}
+
+type order struct {
+	entity.AggregateRoot
+	Status string
+	Amount int64
+}
+
+type note struct {
+	Id   uint64
+	Text string
+}
+
+func (n *note) EntityId() uint64 {
+	return n.Id
+}
+
+func (n *note) SetEntityId(id uint64) {
+	n.Id = id
+}
+
+type recordingPublisher struct {
+	published []entity.Aggregate
+	events    int
+}
+
+func (p *recordingPublisher) Publish(aggregates ...entity.Aggregate) error {
+	for i := range aggregates {
+		p.published = append(p.published, aggregates[i])
+		p.events += len(aggregates[i].PendingEvents())
+	}
+	return nil
+}
+
+func TestRepositorySaveAssignsIdAndVersion(t *testing.T) {
+	publisher := new(recordingPublisher)
+	repo := NewRepository[order](publisher)
+	o := &order{Status: "created", Amount: 10}
+	o.Record(orderPaid{Amount: 10})
+	if err := repo.Save(o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Id == 0 || o.Version != 1 {
+		t.Fatalf("expected an id and version 1, got id %d version %d", o.Id, o.Version)
+	}
+	if len(publisher.published) != 1 || publisher.events != 1 {
+		t.Fatalf("expected 1 aggregate with 1 event published, got %d %d", len(publisher.published), publisher.events)
+	}
+
+	found, err := repo.FindByID(o.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Status != "created" || found.Version != 1 || len(found.PendingEvents()) != 0 {
+		t.Fatalf("unexpected stored copy %+v", found)
+	}
+	//读取到的是副本 修改后不Save不会影响仓储
+	found.Status = "paid"
+	if again, _ := repo.FindByID(o.Id); again.Status != "created" {
+		t.Fatalf("stored copy was changed to %s", again.Status)
+	}
+	if err := repo.Save(found); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := repo.FindByID(o.Id); again.Status != "paid" || again.Version != 2 {
+		t.Fatalf("expected paid at version 2, got %s at %d", again.Status, again.Version)
+	}
+	if _, err := repo.FindByID(o.Id + 1); !errors.Is(err, facade.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRepositorySaveConflict(t *testing.T) {
+	repo := NewRepository[order](nil)
+	o := &order{Status: "created"}
+	if err := repo.Save(o); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := repo.FindByID(o.Id)
+	second, _ := repo.FindByID(o.Id)
+	first.Status = "paid"
+	if err := repo.Save(first); err != nil {
+		t.Fatal(err)
+	}
+	second.Status = "cancelled"
+	if err := repo.Save(second); !errors.Is(err, facade.ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency, got %v", err)
+	}
+	if second.Version != 1 {
+		t.Fatalf("failed save changed the version to %d", second.Version)
+	}
+	if stored, _ := repo.FindByID(o.Id); stored.Status != "paid" {
+		t.Fatalf("conflicting save overwrote the order: %s", stored.Status)
+	}
+}
+
+func TestRepositoryDelete(t *testing.T) {
+	repo := NewRepository[order](nil)
+	o := &order{Status: "created"}
+	repo.Save(o)
+	stale, _ := repo.FindByID(o.Id)
+	o.Status = "paid"
+	if err := repo.Save(o); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Delete(stale); !errors.Is(err, facade.ErrConcurrency) {
+		t.Fatalf("deleting a stale version: expected ErrConcurrency, got %v", err)
+	}
+	if _, err := repo.FindByID(o.Id); err != nil {
+		t.Fatalf("stale delete removed the order: %v", err)
+	}
+	if err := repo.Delete(o); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindByID(o.Id); !errors.Is(err, facade.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := repo.Delete(o); !errors.Is(err, facade.ErrConcurrency) {
+		t.Fatalf("deleting twice: expected ErrConcurrency, got %v", err)
+	}
+}
+
+func TestRepositoryEntityWithoutVersion(t *testing.T) {
+	repo := NewRepository[note](nil)
+	n := &note{Text: "a"}
+	if err := repo.Save(n); err != nil {
+		t.Fatal(err)
+	}
+	stale := *n
+	n.Text = "b"
+	if err := repo.Save(n); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(&stale); err != nil {
+		t.Fatalf("entities without a version should not conflict: %v", err)
+	}
+	if err := repo.Delete(&stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindByID(n.Id); !errors.Is(err, facade.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRepositoryFindBySpecification(t *testing.T) {
+	repo := NewRepository[order](nil)
+	for _, o := range []*order{
+		{Status: "paid", Amount: 30},
+		{Status: "created", Amount: 50},
+		{Status: "paid", Amount: 5},
+		{Status: "cancelled", Amount: 70},
+	} {
+		if err := repo.Save(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := func(status string) facade.Specification[*order] {
+		return facade.Spec(func(o *order) bool { return o.Status == status }, "status = ?", status)
+	}
+	over := func(amount int64) facade.Specification[*order] {
+		return facade.Spec(func(o *order) bool { return o.Amount > amount }, "amount > ?", amount)
+	}
+	cases := []struct {
+		name string
+		spec facade.Specification[*order]
+		ids  []uint64
+	}{
+		{"all", facade.All[*order](), []uint64{1, 2, 3, 4}},
+		{"status", status("paid"), []uint64{1, 3}},
+		{"and", facade.And(status("paid"), over(10)), []uint64{1}},
+		{"or", facade.Or(status("created"), status("cancelled")), []uint64{2, 4}},
+		{"not", facade.Not(status("paid")), []uint64{2, 4}},
+		{"none", facade.And(status("created"), over(100)), nil},
+	}
+	for _, c := range cases {
+		items, err := repo.FindBy(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != len(c.ids) {
+			t.Fatalf("%s: expected %d orders, got %d", c.name, len(c.ids), len(items))
+		}
+		for i := range items {
+			if items[i].Id != c.ids[i] {
+				t.Fatalf("%s: order %d has id %d", c.name, i, items[i].Id)
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package persistence
+
+import (
+	"DDD/domain/aggregate/entity"
+	"DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/mysql"
+	"DDD/infrastructure/util/outbox"
+
+	"github.com/jinzhu/gorm"
+	"go.uber.org/zap"
+
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Target 聚合事件的去向
+type Target interface {
+	// Dispatch tx为保存聚合的事务 事务提交后调用时为nil
+	Dispatch(tx *gorm.DB, aggregate entity.Aggregate, events []eventbus.Event) error
+	// Transactional 为true时在事务提交前调用 否则在提交后调用
+	Transactional() bool
+}
+
+// BusTarget 发布到进程内的EventBus topic为事件的EventType
+type BusTarget struct {
+	Bus eventbus.Bus
+}
+
+func (t *BusTarget) Transactional() bool {
+	return false
+}
+
+func (t *BusTarget) Dispatch(tx *gorm.DB, aggregate entity.Aggregate, events []eventbus.Event) error {
+	for i := range events[:] {
+		if err := t.Bus.PublishErr(events[i].EventType(), events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OutboxTarget 和聚合在同一个事务中写入outbox 由outbox.Relay发送到mq
+type OutboxTarget struct {
+	EventType int8
+	Topic     string //为空时使用事件的EventType
+}
+
+func (t *OutboxTarget) Transactional() bool {
+	return true
+}
+
+func (t *OutboxTarget) Dispatch(tx *gorm.DB, aggregate entity.Aggregate, events []eventbus.Event) error {
+	if tx == nil {
+		return errors.New("outbox需要在保存聚合的事务中写入")
+	}
+	for i := range events[:] {
+		err := outbox.Add(tx, t.EventType, topicOf(t.Topic, events[i]), events[i], eventbus.WithSource(entity.Source(aggregate)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MqTarget 直接发送到MqBus
+type MqTarget struct {
+	Bus       eventbus.MqBusPublisher
+	EventType int8
+	Topic     string //为空时使用事件的EventType
+}
+
+func (t *MqTarget) Transactional() bool {
+	return false
+}
+
+func (t *MqTarget) Dispatch(tx *gorm.DB, aggregate entity.Aggregate, events []eventbus.Event) error {
+	for i := range events[:] {
+		err := t.Bus.PublishEvent(t.EventType, topicOf(t.Topic, events[i]), events[i], eventbus.WithSource(entity.Source(aggregate)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func topicOf(topic string, event eventbus.Event) string {
+	if topic != "" {
+		return topic
+	}
+	return event.EventType()
+}
+
+// Dispatcher 仓储保存聚合后把待发布的事件交给所有去向
+// outbox在保存聚合的事务中写入 EventBus和MqBus在事务提交后发布
+type Dispatcher struct {
+	targets []Target
+}
+
+func NewDispatcher(targets ...Target) *Dispatcher {
+	return &Dispatcher{
+		targets: targets,
+	}
+}
+
+// Save 在事务中执行save并写入outbox 提交后发布事件并清空聚合的事件
+// 事务回滚时聚合的事件保留
+func (d *Dispatcher) Save(db *gorm.DB, save func(tx *gorm.DB) error, aggregates ...entity.Aggregate) error {
+	err := transaction(db, func(tx *gorm.DB) error {
+		if err := save(tx); err != nil {
+			return err
+		}
+		return d.Stage(tx, aggregates...)
+	})
+	if err != nil {
+		return err
+	}
+	return d.Publish(aggregates...)
+}
+
+// Stage 把事件交给需要事务的去向 在事务提交前调用
+func (d *Dispatcher) Stage(tx *gorm.DB, aggregates ...entity.Aggregate) error {
+	return d.dispatch(tx, true, aggregates)
+}
+
+// Publish 把事件交给其他去向 在事务提交后调用
+// 出错时也会清空聚合的事件 避免再次保存时重复写入outbox
+func (d *Dispatcher) Publish(aggregates ...entity.Aggregate) error {
+	err := d.dispatch(nil, false, aggregates)
+	for _, aggregate := range aggregates {
+		aggregate.ClearEvents()
+	}
+	return err
+}
+
+// SaveContext ctx中有UnitOfWork的事务时在该事务中执行save并写入outbox 最外层事务提交后发布事件
+// 聚合的事件会马上清空 事务回滚时事件随之丢弃 ctx中没有事务时同Save
+func (d *Dispatcher) SaveContext(ctx context.Context, db *gorm.DB, save func(tx *gorm.DB) error, aggregates ...entity.Aggregate) error {
+	tx := mysql.DBFromContext(ctx, db)
+	if tx == db {
+		return d.Save(db, save, aggregates...)
+	}
+	if err := save(tx); err != nil {
+		return err
+	}
+	if err := d.Stage(tx, aggregates...); err != nil {
+		return err
+	}
+	pending := make([][]eventbus.Event, len(aggregates))
+	for i := range aggregates[:] {
+		pending[i] = aggregates[i].PendingEvents()
+		aggregates[i].ClearEvents()
+	}
+	mysql.AfterCommit(ctx, func(ctx context.Context) {
+		for i := range aggregates[:] {
+			if err := d.dispatchEvents(nil, false, aggregates[i], pending[i]); err != nil {
+				config.Logger.Error("print-srv:repository",
+					zap.String("source", entity.Source(aggregates[i])),
+					zap.Error(err),
+				)
+			}
+		}
+	})
+	return nil
+}
+
+func (d *Dispatcher) dispatch(tx *gorm.DB, transactional bool, aggregates []entity.Aggregate) error {
+	for _, aggregate := range aggregates {
+		if err := d.dispatchEvents(tx, transactional, aggregate, aggregate.PendingEvents()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) dispatchEvents(tx *gorm.DB, transactional bool, aggregate entity.Aggregate, events []eventbus.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	for i := range d.targets[:] {
+		if d.targets[i].Transactional() != transactional {
+			continue
+		}
+		if err := d.targets[i].Dispatch(tx, aggregate, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transaction db已经在事务中时直接使用
+func transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		return fn(db)
+	}
+	return db.Transaction(fn)
+}
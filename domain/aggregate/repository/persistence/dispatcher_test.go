@@ -0,0 +1,225 @@
+package persistence
+
+import (
+	"DDD/domain/aggregate/entity"
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+
+	"context"
+	"errors"
+	"testing"
+)
+
+const (
+	sqlSave         = "UPDATE orders SET status = 'paid'"
+	sqlOutboxInsert = "INSERT INTO `outbox` (`created_at`,`updated_at`,`event_id`,`event_type`,`topic`,`envelope`,`delay`,`status`,`attempts`,`next_retry_at`,`last_error`,`claim_token`,`sent_at`) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)"
+)
+
+type orderPaid struct {
+	Amount int64 `json:"amount"`
+}
+
+func (orderPaid) EventType() string {
+	return "persistence.order.paid"
+}
+
+func (orderPaid) EventVersion() int {
+	return 1
+}
+
+type order struct {
+	entity.AggregateRoot
+}
+
+func (order) AggregateType() string {
+	return "order"
+}
+
+// mockDB 使用sqlmock检查事务和outbox的写入
+func mockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("mysql", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+func save(tx *gorm.DB) error {
+	return tx.Exec(sqlSave).Error
+}
+
+// capturePublisher 记录直接发送到mq的事件
+type capturePublisher struct {
+	eventbus.MqBusPublisher
+	topics  []string
+	sources []string
+}
+
+func (p *capturePublisher) PublishEvent(eventType int8, topic string, event eventbus.Event, options ...eventbus.PublishOption) error {
+	message, err := eventbus.NewMessage(eventType, topic, event, options...)
+	if err != nil {
+		return err
+	}
+	p.topics = append(p.topics, topic)
+	p.sources = append(p.sources, message.Envelope.Source)
+	return nil
+}
+
+// newDispatcher 事件写入outbox 并在提交后发布到bus和mq
+func newDispatcher(t *testing.T, mock sqlmock.Sqlmock) (*Dispatcher, *[]int64, *capturePublisher) {
+	t.Helper()
+	bus := eventbus.New()
+	published := new([]int64)
+	if _, err := eventbus.Subscribe(bus, orderPaid{}.EventType(), func(event orderPaid) {
+		//提交后才发布 sqlmock会检查commit已经执行
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("published before the commit: %v", err)
+		}
+		*published = append(*published, event.Amount)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mq := new(capturePublisher)
+	return NewDispatcher(
+		&BusTarget{Bus: bus},
+		&OutboxTarget{EventType: eventbus.EventStreams, Topic: "order_stream"},
+		&MqTarget{Bus: mq, EventType: eventbus.EventStreams},
+	), published, mq
+}
+
+func TestDispatcherSaveWritesOutboxThenPublishesAfterCommit(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlSave).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlOutboxInsert).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), eventbus.EventStreams, "order_stream",
+			sqlmock.AnyArg(), 0, 0, 0, sqlmock.AnyArg(), "", "", nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	dispatcher, published, mq := newDispatcher(t, mock)
+	o := &order{AggregateRoot: entity.AggregateRoot{Id: 7}}
+	o.Record(orderPaid{Amount: 10})
+	if err := dispatcher.Save(db, save, o); err != nil {
+		t.Fatal(err)
+	}
+	if len(*published) != 1 || (*published)[0] != 10 {
+		t.Fatalf("expected the event on the bus, got %v", *published)
+	}
+	//topic为空时使用事件的EventType
+	if len(mq.topics) != 1 || mq.topics[0] != (orderPaid{}).EventType() || mq.sources[0] != "order:7" {
+		t.Fatalf("unexpected mq publish %v %v", mq.topics, mq.sources)
+	}
+	if len(o.PendingEvents()) != 0 {
+		t.Fatal("published events should be cleared")
+	}
+}
+
+func TestDispatcherSaveRollbackKeepsEvents(t *testing.T) {
+	db, mock := mockDB(t)
+	cause := errors.New("version conflict")
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlSave).WillReturnError(cause)
+	mock.ExpectRollback()
+
+	dispatcher, published, mq := newDispatcher(t, mock)
+	o := &order{AggregateRoot: entity.AggregateRoot{Id: 7}}
+	o.Record(orderPaid{Amount: 10})
+	if err := dispatcher.Save(db, save, o); !errors.Is(err, cause) {
+		t.Fatalf("expected the save error, got %v", err)
+	}
+	if len(*published) != 0 || len(mq.topics) != 0 {
+		t.Fatalf("nothing should be published on rollback, got %v %v", *published, mq.topics)
+	}
+	if len(o.PendingEvents()) != 1 {
+		t.Fatal("events should be kept for a retry after rollback")
+	}
+}
+
+func TestDispatcherSaveWithoutEventsSkipsTargets(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlSave).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	dispatcher, published, mq := newDispatcher(t, mock)
+	if err := dispatcher.Save(db, save, &order{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*published) != 0 || len(mq.topics) != 0 {
+		t.Fatalf("nothing should be published, got %v %v", *published, mq.topics)
+	}
+}
+
+func TestDispatcherSaveContextPublishesAfterOuterCommit(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlSave).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlOutboxInsert).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	dispatcher, published, _ := newDispatcher(t, mock)
+	o := &order{AggregateRoot: entity.AggregateRoot{Id: 7}}
+	o.Record(orderPaid{Amount: 10})
+	err := mysql.NewUnitOfWork(db, nil).Do(context.Background(), func(ctx context.Context) error {
+		if err := dispatcher.SaveContext(ctx, db, save, o); err != nil {
+			return err
+		}
+		//事件马上清空 提交后发布
+		if len(o.PendingEvents()) != 0 || len(*published) != 0 {
+			return errors.New("events should be cleared and published only after the commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*published) != 1 {
+		t.Fatalf("expected the event after the commit, got %v", *published)
+	}
+}
+
+func TestDispatcherSaveContextRollbackDropsEvents(t *testing.T) {
+	db, mock := mockDB(t)
+	cause := errors.New("later step failed")
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlSave).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlOutboxInsert).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	dispatcher, published, mq := newDispatcher(t, mock)
+	o := &order{AggregateRoot: entity.AggregateRoot{Id: 7}}
+	o.Record(orderPaid{Amount: 10})
+	err := mysql.NewUnitOfWork(db, nil).Do(context.Background(), func(ctx context.Context) error {
+		if err := dispatcher.SaveContext(ctx, db, save, o); err != nil {
+			return err
+		}
+		return cause
+	})
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected the outer error, got %v", err)
+	}
+	if len(*published) != 0 || len(mq.topics) != 0 {
+		t.Fatalf("rolled back events should not be published, got %v %v", *published, mq.topics)
+	}
+}
+
+func TestOutboxTargetRequiresTransaction(t *testing.T) {
+	target := &OutboxTarget{Topic: "order_stream"}
+	o := &order{}
+	if err := target.Dispatch(nil, o, []eventbus.Event{orderPaid{}}); err == nil {
+		t.Fatal("expected an error outside a transaction")
+	}
+}
@@ -0,0 +1,183 @@
+package persistence
+
+import (
+	"DDD/domain/aggregate/entity"
+	"DDD/domain/aggregate/repository/facade"
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/jinzhu/gorm"
+
+	"context"
+)
+
+// Repository gorm仓储 模型嵌入mysql.BaseModel或entity.AggregateRoot
+// 聚合按version做乐观锁 保存和outbox在同一个事务中 提交后发布事件
+type Repository[T any, PT interface {
+	*T
+	entity.Entity
+}] struct {
+	db         *gorm.DB
+	database   *mysql.Database
+	dispatcher *Dispatcher
+	ctx        context.Context
+}
+
+// NewRepository dispatcher为nil时不发布事件
+//
+//	repo := persistence.NewRepository[Order](mysql.DB.DDD, dispatcher)
+func NewRepository[T any, PT interface {
+	*T
+	entity.Entity
+}](db *gorm.DB, dispatcher *Dispatcher) *Repository[T, PT] {
+	return &Repository[T, PT]{
+		db:         db,
+		dispatcher: dispatcher,
+	}
+}
+
+// WithDB 使用指定的连接 例如事务
+func (r *Repository[T, PT]) WithDB(db *gorm.DB) *Repository[T, PT] {
+	return &Repository[T, PT]{
+		db:         db,
+		dispatcher: r.dispatcher,
+		ctx:        r.ctx,
+	}
+}
+
+// WithDatabase 写入使用主库 读取按mysql.Database.Reader选择从库
+func (r *Repository[T, PT]) WithDatabase(database *mysql.Database) *Repository[T, PT] {
+	return &Repository[T, PT]{
+		db:         database.DDD,
+		database:   database,
+		dispatcher: r.dispatcher,
+		ctx:        r.ctx,
+	}
+}
+
+// WithContext ctx中有mysql.UnitOfWork的事务时使用该事务 事件在事务提交后发布
+func (r *Repository[T, PT]) WithContext(ctx context.Context) *Repository[T, PT] {
+	return &Repository[T, PT]{
+		db:         r.db,
+		database:   r.database,
+		dispatcher: r.dispatcher,
+		ctx:        ctx,
+	}
+}
+
+// conn 写入使用的连接
+func (r *Repository[T, PT]) conn() *gorm.DB {
+	if r.ctx == nil {
+		return r.db
+	}
+	return mysql.DBFromContext(r.ctx, r.db)
+}
+
+// reader 读取使用的连接
+func (r *Repository[T, PT]) reader() *gorm.DB {
+	if r.database == nil {
+		return r.conn()
+	}
+	if r.ctx == nil {
+		return r.database.Reader(context.Background())
+	}
+	return r.database.Reader(r.ctx)
+}
+
+// written 记录会话写入过主库 之后的读取使用主库
+func (r *Repository[T, PT]) written(err error) error {
+	if err == nil && r.ctx != nil {
+		mysql.MarkWrite(r.ctx)
+	}
+	return err
+}
+
+func (r *Repository[T, PT]) FindByID(id uint64) (PT, error) {
+	item := PT(new(T))
+	err := r.reader().Where("id = ?", id).First(item).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, facade.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *Repository[T, PT]) Save(item PT) error {
+	aggregate, ok := any(item).(entity.Aggregate)
+	if !ok {
+		return r.written(r.conn().Save(item).Error)
+	}
+	version := aggregate.AggregateVersion()
+	save := func(tx *gorm.DB) error {
+		return saveAggregate(tx, item, aggregate)
+	}
+	var err error
+	switch {
+	case r.dispatcher != nil && r.ctx != nil:
+		err = r.dispatcher.SaveContext(r.ctx, r.db, save, aggregate)
+	case r.dispatcher != nil:
+		err = r.dispatcher.Save(r.db, save, aggregate)
+	default:
+		err = transaction(r.conn(), save)
+	}
+	if err != nil {
+		aggregate.SetAggregateVersion(version)
+	}
+	return r.written(err)
+}
+
+// saveAggregate 版本为0时新增 否则按id和版本更新全部字段 更新不到说明已经被修改
+func saveAggregate(tx *gorm.DB, item interface{}, aggregate entity.Aggregate) error {
+	version := aggregate.AggregateVersion()
+	aggregate.SetAggregateVersion(version + 1)
+	if version == 0 {
+		err := tx.Create(item).Error
+		if mysql.IsDuplicateEntry(err) {
+			return facade.ErrConcurrency
+		}
+		return err
+	}
+	columns := make(map[string]interface{})
+	for _, field := range tx.NewScope(item).Fields() {
+		if field.IsNormal && !field.IsPrimaryKey && !field.IsIgnored {
+			columns[field.DBName] = field.Field.Interface()
+		}
+	}
+	result := tx.Model(item).Where("version = ?", version).Updates(columns)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return facade.ErrConcurrency
+	}
+	return nil
+}
+
+func (r *Repository[T, PT]) Delete(item PT) error {
+	aggregate, ok := any(item).(entity.Aggregate)
+	if !ok {
+		return r.written(r.conn().Delete(item).Error)
+	}
+	result := r.conn().Where("version = ?", aggregate.AggregateVersion()).Delete(item)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return facade.ErrConcurrency
+	}
+	return r.written(nil)
+}
+
+func (r *Repository[T, PT]) FindBy(spec facade.Specification[PT]) ([]PT, error) {
+	var rows []T
+	query, args := spec.Query()
+	if err := r.reader().Where(query, args...).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make([]PT, 0, len(rows))
+	for i := range rows[:] {
+		items = append(items, &rows[i])
+	}
+	return items, nil
+}
@@ -14,14 +14,26 @@ type Config struct {
 	Name string
 }
 
-var Logger *zap.Logger
+// Logger Init之前输出到标准错误 Init之后按配置写入日志文件 测试不需要加载配置文件
+var Logger = bootstrapLogger()
 
-func init() {
+// bootstrapLogger 配置文件加载之前使用的日志
+func bootstrapLogger() *zap.Logger {
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(newEncoderConfig()),
+		zapcore.Lock(os.Stderr),
+		zap.InfoLevel,
+	)
+	return zap.New(core, zap.AddCaller())
+}
+
+// Init 加载配置文件并初始化日志 在main中最先调用
+func Init() error {
 	c := Config{}
 
 	// 初始化配置文件
 	if err := c.initConfig(); err != nil {
-		panic(err)
+		return err
 	}
 
 	// 初始化日志包
@@ -29,6 +41,7 @@ func init() {
 	// 监控配置文件变化并热加载程序
 	//c.watchConfig()
 
+	return nil
 }
 
 func (c *Config) initConfig() error {
@@ -70,7 +83,23 @@ func (c *Config) initLog() *zap.Logger {
 	default:
 		level = zap.InfoLevel
 	}
-	encoderConfig := zapcore.EncoderConfig{
+	encoderConfig := newEncoderConfig()
+	atomicLevel := zap.NewAtomicLevel()
+	atomicLevel.SetLevel(level)
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(encoderConfig),
+		zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout), zapcore.AddSync(&hook)),
+		//zapcore.NewMultiWriteSyncer(zapcore.AddSync(&hook)),
+		atomicLevel,
+	)
+	caller := zap.AddCaller()
+	logger := zap.New(core, caller)
+	logger.Info("DefaultLogger init success")
+	return logger
+}
+
+func newEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
 		TimeKey:        "time",
 		LevelKey:       "level",
 		NameKey:        "logger",
@@ -84,18 +113,6 @@ func (c *Config) initLog() *zap.Logger {
 		EncodeCaller:   zapcore.FullCallerEncoder,      // 全路径编码器
 		EncodeName:     zapcore.FullNameEncoder,
 	}
-	atomicLevel := zap.NewAtomicLevel()
-	atomicLevel.SetLevel(level)
-	core := zapcore.NewCore(
-		zapcore.NewJSONEncoder(encoderConfig),
-		zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout), zapcore.AddSync(&hook)),
-		//zapcore.NewMultiWriteSyncer(zapcore.AddSync(&hook)),
-		atomicLevel,
-	)
-	caller := zap.AddCaller()
-	logger := zap.New(core, caller)
-	logger.Info("DefaultLogger init success")
-	return logger
 }
 
 // 监控配置文件变化并热加载程序
@@ -0,0 +1,9 @@
+// Package migrations 数据库迁移
+//
+// sql迁移放在本目录 使用 DDD migrate create <name> 创建
+// Go函数迁移在本包的init中调用migrate.Register 例如
+//
+//	func init() {
+//		migrate.Register(20261017120000, "create_order", migrate.AutoMigrate(&Order{}), migrate.DropTables(&Order{}))
+//	}
+package migrations
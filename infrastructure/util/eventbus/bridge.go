@@ -0,0 +1,209 @@
+package eventbus
+
+import (
+	"DDD/infrastructure/config/config"
+
+	"go.uber.org/zap"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+const (
+	bridgeArgsHeader  = "bridge_args"  //Data为Publish参数组成的数组
+	bridgeTopicHeader = "bridge_topic" //进程内的topic
+)
+
+// bridgeRoute 进程内topic转发到mq的配置
+type bridgeRoute struct {
+	eventType int8
+	topic     string
+	options   []PublishOption
+}
+
+// Bridge 连接进程内的EventBus和MqBus
+// 通过Bridge.Publish发布的事件在本地处理后 配置了Forward的topic会同时推送到mq
+// Receive返回的处理函数把mq中的事件重新发布到本地EventBus 订阅者不需要关心事件来自哪里
+type Bridge struct {
+	Bus
+	mq     MqBusPublisher
+	routes sync.Map
+}
+
+func NewBridge(bus Bus, mq MqBusPublisher) *Bridge {
+	return &Bridge{
+		Bus: bus,
+		mq:  mq,
+	}
+}
+
+// Forward 把本地topic推送到mq eventType选择mq mqTopic为streams名称或rabbitmq的routing key
+func (b *Bridge) Forward(topic string, eventType int8, mqTopic string, options ...PublishOption) error {
+	if _, ok := MqBusMq[eventType]; !ok {
+		return errors.New("事件类型错误")
+	}
+	b.routes.Store(topic, &bridgeRoute{
+		eventType: eventType,
+		topic:     mqTopic,
+		options:   options,
+	})
+	return nil
+}
+
+// StopForward 停止转发topic
+func (b *Bridge) StopForward(topic string) {
+	b.routes.Delete(topic)
+}
+
+// Publish 本地发布 配置了转发时同时推送到mq 推送失败只记录日志
+func (b *Bridge) Publish(topic string, args ...interface{}) {
+	b.Bus.Publish(topic, args...)
+	if err := b.forward(topic, args...); err != nil {
+		config.Logger.Error("Error", zap.String("topic", topic), zap.Error(err))
+	}
+}
+
+// PublishErr 本地发布并推送到mq 本地订阅函数的错误优先返回
+func (b *Bridge) PublishErr(topic string, args ...interface{}) error {
+	return b.PublishWithPolicy(topic, ContinueOnError, args...)
+}
+
+// PublishCtx 本地发布并推送到mq ctx传给本地订阅函数
+func (b *Bridge) PublishCtx(ctx context.Context, topic string, args ...interface{}) error {
+	err := b.Bus.PublishCtx(ctx, topic, args...)
+	if e := b.forward(topic, args...); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// PublishWithPolicy 本地发布并推送到mq StopOnError时本地有错误不再推送
+func (b *Bridge) PublishWithPolicy(topic string, policy ErrorPolicy, args ...interface{}) error {
+	err := b.Bus.PublishWithPolicy(topic, policy, args...)
+	if err != nil && policy == StopOnError {
+		return err
+	}
+	if e := b.forward(topic, args...); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func (b *Bridge) forward(topic string, args ...interface{}) error {
+	value, ok := b.routes.Load(topic)
+	if !ok {
+		return nil
+	}
+	route := value.(*bridgeRoute)
+	options := append([]PublishOption{WithHeader(bridgeTopicHeader, topic)}, route.options...)
+	var message *Message
+	var err error
+	if event, ok := singleEvent(args); ok {
+		message, err = newMessage(route.eventType, route.topic, event.EventType(), event.EventVersion(), event, options...)
+	} else {
+		if args == nil {
+			args = []interface{}{}
+		}
+		options = append(options, WithHeader(bridgeArgsHeader, true))
+		message, err = newMessage(route.eventType, route.topic, topic, 1, args, options...)
+	}
+	if err != nil {
+		return err
+	}
+	return b.mq.PublishMessage(message)
+}
+
+func singleEvent(args []interface{}) (Event, bool) {
+	if len(args) != 1 {
+		return nil, false
+	}
+	event, ok := args[0].(Event)
+	return event, ok
+}
+
+// Receive 返回把mq事件发布到本地topic的处理函数 可以用于StreamsConsumer.HandleEnvelope和HandleDelivery
+// 事件需要用RegisterEvent注册 非Event参数按每个本地订阅函数的参数类型分别解析
+func (b *Bridge) Receive(topic string) EnvelopeHandler {
+	return func(envelope *Envelope, _ interface{}) error {
+		//直接发布到本地 不会再次转发到mq 订阅函数的错误返回给mq重试
+		if isArgs, _ := envelope.Headers[bridgeArgsHeader].(bool); isArgs {
+			return b.receiveArgs(topic, envelope)
+		}
+		event, err := envelope.decodeRegistered()
+		if err != nil {
+			return err
+		}
+		return b.Bus.PublishErr(topic, event)
+	}
+}
+
+// decodedArgs 同一种参数类型只解析一次
+type decodedArgs struct {
+	types []reflect.Type
+	args  []interface{}
+}
+
+// receiveArgs 同一个topic的订阅函数参数类型可以不同 解析失败的订阅函数返回错误 不影响其他订阅函数
+func (b *Bridge) receiveArgs(topic string, envelope *Envelope) error {
+	var raw []json.RawMessage
+	if err := envelope.DecodeTo(&raw); err != nil {
+		return err
+	}
+	if len(raw) == 0 {
+		return b.Bus.PublishErr(topic)
+	}
+	bus, ok := b.Bus.(*EventBus)
+	if !ok {
+		return fmt.Errorf("topic %s 无法确定参数类型", topic)
+	}
+	var decoded []decodedArgs
+	errs := bus.dispatch(context.Background(), topic, ContinueOnError, func(handler *eventHandler) ([]interface{}, error) {
+		types := handler.argTypes()
+		for i := range decoded[:] {
+			if sameTypes(decoded[i].types, types) {
+				return decoded[i].args, nil
+			}
+		}
+		args, err := decodeArgs(topic, raw, types)
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, decodedArgs{types: types, args: args})
+		return args, nil
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func decodeArgs(topic string, raw []json.RawMessage, types []reflect.Type) ([]interface{}, error) {
+	if len(types) < len(raw) {
+		return nil, fmt.Errorf("topic %s 的订阅函数需要%d个参数 收到%d个", topic, len(types), len(raw))
+	}
+	args := make([]interface{}, len(raw))
+	for i := range raw[:] {
+		v := reflect.New(types[i])
+		if err := json.Unmarshal(raw[i], v.Interface()); err != nil {
+			return nil, fmt.Errorf("topic %s 的第%d个参数无法解析为%s: %w", topic, i+1, types[i], err)
+		}
+		args[i] = v.Elem().Interface()
+	}
+	return args, nil
+}
+
+func sameTypes(a, b []reflect.Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a[:] {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type userRenamed struct {
+	Id   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+func (userRenamed) EventType() string {
+	return "bridge.user.renamed"
+}
+
+func (userRenamed) EventVersion() int {
+	return 1
+}
+
+func init() {
+	RegisterEvent(userRenamed{})
+}
+
+// capturePublisher 保存推送到mq的消息 经过json序列化模拟mq
+type capturePublisher struct {
+	envelopes []*Envelope
+}
+
+func (p *capturePublisher) Publish(eventType int8, topic, args string) error {
+	return nil
+}
+
+func (p *capturePublisher) PublishWithOptions(eventType int8, topic, args string, options ...PublishOption) error {
+	return nil
+}
+
+func (p *capturePublisher) PublishEvent(eventType int8, topic string, event Event, options ...PublishOption) error {
+	return nil
+}
+
+func (p *capturePublisher) PublishMessage(message *Message) error {
+	data, err := json.Marshal(message.Envelope)
+	if err != nil {
+		return err
+	}
+	envelope := new(Envelope)
+	if err := json.Unmarshal(data, envelope); err != nil {
+		return err
+	}
+	p.envelopes = append(p.envelopes, envelope)
+	return nil
+}
+
+var (
+	renamedMaps    []map[string]interface{}
+	renamedStructs []userRenamed
+	renamedNames   []string
+)
+
+type renamedMapObserver struct {
+	Fn string `subscribe:"Handle" topic:"user.renamed"`
+}
+
+func (o renamedMapObserver) Handle(payload map[string]interface{}, by string) {
+	renamedMaps = append(renamedMaps, payload)
+}
+
+type renamedStructObserver struct {
+	Fn string `subscribe:"Handle" topic:"user.*"`
+}
+
+func (o renamedStructObserver) Handle(payload userRenamed, by string) {
+	renamedStructs = append(renamedStructs, payload)
+	renamedNames = append(renamedNames, by)
+}
+
+func newBridgePair(t *testing.T, topic string) (*Bridge, *capturePublisher, *Bridge) {
+	t.Helper()
+	mq := new(capturePublisher)
+	sender := NewBridge(New(), mq)
+	if err := sender.Forward(topic, EventStreams, "user_stream"); err != nil {
+		t.Fatal(err)
+	}
+	return sender, mq, NewBridge(New(), nil)
+}
+
+func TestBridgeRoundTripsEvent(t *testing.T) {
+	sender, mq, receiver := newBridgePair(t, "user.renamed")
+	var local, remote []userRenamed
+	Subscribe(sender, "user.renamed", func(event userRenamed) {
+		local = append(local, event)
+	})
+	Subscribe(receiver, "user.renamed", func(event userRenamed) {
+		remote = append(remote, event)
+	})
+
+	sender.Publish("user.renamed", userRenamed{Id: 1, Name: "alice"})
+	sender.Publish("user.created", userRenamed{Id: 2, Name: "bob"})
+	if len(local) != 1 || len(mq.envelopes) != 1 {
+		t.Fatalf("expected 1 local event and 1 forwarded, got %d %d", len(local), len(mq.envelopes))
+	}
+	envelope := mq.envelopes[0]
+	if envelope.Type != "bridge.user.renamed" || envelope.Headers[bridgeTopicHeader] != "user.renamed" {
+		t.Fatalf("unexpected envelope %+v", envelope)
+	}
+	if err := receiver.Receive("user.renamed")(envelope, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(remote) != 1 || remote[0] != (userRenamed{Id: 1, Name: "alice"}) {
+		t.Fatalf("received %+v", remote)
+	}
+}
+
+func TestBridgeDecodesArgsPerHandler(t *testing.T) {
+	renamedMaps, renamedStructs, renamedNames = nil, nil, nil
+	sender, mq, receiver := newBridgePair(t, "user.renamed")
+	//同一个topic的订阅函数参数类型不同 需要分别解析
+	if err := receiver.Subscribe(renamedMapObserver{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Subscribe(renamedStructObserver{}); err != nil {
+		t.Fatal(err)
+	}
+
+	sender.Publish("user.renamed", map[string]interface{}{"id": 1, "name": "alice"}, "admin")
+	if len(mq.envelopes) != 1 {
+		t.Fatalf("expected 1 forwarded message, got %d", len(mq.envelopes))
+	}
+	envelope := mq.envelopes[0]
+	if isArgs, _ := envelope.Headers[bridgeArgsHeader].(bool); !isArgs {
+		t.Fatalf("expected the args header, got %v", envelope.Headers)
+	}
+	if err := receiver.Receive("user.renamed")(envelope, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(renamedMaps) != 1 || renamedMaps[0]["name"] != "alice" {
+		t.Fatalf("map handler received %+v", renamedMaps)
+	}
+	if len(renamedStructs) != 1 || renamedStructs[0] != (userRenamed{Id: 1, Name: "alice"}) || renamedNames[0] != "admin" {
+		t.Fatalf("struct handler received %+v %v", renamedStructs, renamedNames)
+	}
+}
+
+func TestBridgeReceiveReportsUndecodableHandler(t *testing.T) {
+	renamedMaps, renamedStructs, renamedNames = nil, nil, nil
+	sender, mq, receiver := newBridgePair(t, "user.renamed")
+	receiver.Subscribe(renamedMapObserver{})
+	receiver.Subscribe(renamedStructObserver{})
+
+	sender.Publish("user.renamed", map[string]interface{}{"id": "not a number"}, "admin")
+	err := receiver.Receive("user.renamed")(mq.envelopes[0], nil)
+	errs, ok := err.(HandlerErrors)
+	if !ok || len(errs) != 1 || errs[0].Handler != "eventbus.renamedStructObserver.Handle" {
+		t.Fatalf("expected only the struct handler to fail, got %v", err)
+	}
+	if len(renamedMaps) != 1 {
+		t.Fatalf("the map handler should still run, got %d", len(renamedMaps))
+	}
+
+	//本地没有订阅者时不解析
+	if err := NewBridge(New(), nil).Receive("user.renamed")(mq.envelopes[0], nil); err != nil {
+		t.Fatal(err)
+	}
+}
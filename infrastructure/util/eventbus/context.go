@@ -0,0 +1,53 @@
+package eventbus
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Default 进程内默认的事件总线 服务退出时在main中等待异步订阅函数完成
+var Default = New()
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// PublishCtx 推送并把ctx传给订阅函数 返回同步订阅函数的全部错误
+// ctx取消后不再执行后面的订阅函数 已经开始等待的异步订阅函数也不再执行
+// 反射订阅的函数第一个参数为context.Context时会传入ctx 例如 func (o Observer) Handle(ctx context.Context, event Event)
+func (bus *EventBus) PublishCtx(ctx context.Context, topic string, args ...interface{}) error {
+	if errs := bus.publish(ctx, topic, ContinueOnError, args...); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// WaitAsyncCtx 等待异步订阅函数完成 ctx取消时返回ctx.Err()
+func (bus *EventBus) WaitAsyncCtx(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		bus.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitAsyncTimeout 等待异步订阅函数完成 超时返回context.DeadlineExceeded
+func (bus *EventBus) WaitAsyncTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return bus.WaitAsyncCtx(ctx)
+}
+
+// acceptsContext 反射订阅的函数第一个参数是否为context.Context
+func (handler *eventHandler) acceptsContext() bool {
+	if handler.typed != nil {
+		return false
+	}
+	funcType := handler.callBack.Type()
+	return funcType.NumIn() > 1 && funcType.In(1) == contextType
+}
@@ -0,0 +1,149 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+var reflectedRequestIds []interface{}
+
+type ctxObserver struct {
+	Fn string `subscribe:"Handle" topic:"order.created"`
+}
+
+func (o ctxObserver) Handle(ctx context.Context, event orderCreated) {
+	reflectedRequestIds = append(reflectedRequestIds, ctx.Value(ctxKey{}))
+}
+
+func TestPublishCtxPassesContext(t *testing.T) {
+	reflectedRequestIds = nil
+	bus := New()
+	var typed interface{}
+	if _, err := SubscribeCtx(bus, "order.created", func(ctx context.Context, event orderCreated) error {
+		typed = ctx.Value(ctxKey{})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Subscribe(ctxObserver{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "r1")
+	if err := EmitCtx(ctx, bus, "order.created", orderCreated{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if typed != "r1" || len(reflectedRequestIds) != 1 || reflectedRequestIds[0] != "r1" {
+		t.Fatalf("handlers should receive ctx, got %v %v", typed, reflectedRequestIds)
+	}
+}
+
+func TestPublishCtxStopsAfterCancel(t *testing.T) {
+	bus := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ran []uint64
+	for i := uint64(1); i <= 3; i++ {
+		i := i
+		if _, err := SubscribeCtx(bus, "order.created", func(ctx context.Context, event orderCreated) error {
+			ran = append(ran, i)
+			if i == 1 {
+				cancel()
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := bus.PublishCtx(ctx, "order.created", orderCreated{Id: 1})
+	errs, ok := err.(HandlerErrors)
+	if !ok || len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Fatalf("expected one context.Canceled error, got %v", err)
+	}
+	if len(ran) != 1 {
+		t.Fatalf("handlers after the cancel should not run, ran %v", ran)
+	}
+	if err := bus.PublishCtx(ctx, "order.created", orderCreated{Id: 2}); !errors.Is(err.(HandlerErrors)[0], context.Canceled) || len(ran) != 1 {
+		t.Fatalf("a cancelled ctx should not run any handler, got %v %v", err, ran)
+	}
+}
+
+func TestAsyncHandlerSkippedWhenCancelledWhileQueued(t *testing.T) {
+	bus := New()
+	reported := make(chan *HandlerError, 1)
+	bus.SetErrorHandler(func(err *HandlerError) {
+		reported <- err
+	})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	ran := make(chan uint64, 2)
+	if _, err := SubscribeAsyncCtx(bus, "order.created", func(ctx context.Context, event orderCreated) error {
+		if event.Id == 1 {
+			close(started)
+			<-release
+		}
+		ran <- event.Id
+		return nil
+	}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	//transactional的订阅函数串行执行 第二个事件等待第一个完成
+	if err := bus.PublishCtx(context.Background(), "order.created", orderCreated{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := bus.PublishCtx(ctx, "order.created", orderCreated{Id: 2}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	close(release)
+	if err := bus.WaitAsyncTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	close(ran)
+	var handled []uint64
+	for id := range ran {
+		handled = append(handled, id)
+	}
+	if len(handled) != 1 || handled[0] != 1 {
+		t.Fatalf("the cancelled event should not be handled, handled %v", handled)
+	}
+	select {
+	case err := <-reported:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	default:
+		t.Fatal("the skipped event should be reported")
+	}
+}
+
+func TestWaitAsyncCtxAndTimeout(t *testing.T) {
+	bus := New()
+	release := make(chan struct{})
+	if _, err := SubscribeAsync(bus, "order.created", func(event orderCreated) {
+		<-release
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish("order.created", orderCreated{Id: 1})
+
+	if err := bus.WaitAsyncTimeout(20 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bus.WaitAsyncCtx(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	close(release)
+	if err := bus.WaitAsyncTimeout(time.Second); err != nil {
+		t.Fatalf("expected the handler to finish, got %v", err)
+	}
+}
@@ -1,46 +1,216 @@
 package eventbus
 
 import (
-	"DDD/infrastructure/config"
+	"DDD/infrastructure/config/config"
 	"DDD/infrastructure/util/mq/rabbitmq"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 )
 
 const (
-	delayedMaxTime int64 = 4147200
+	delayedMaxTime = 4147200 //延迟插件支持的最大延迟 秒
+
+	deliveryMaxAttempts  = 5                //处理失败的最大次数 超过后进入死信队列
+	deliveryRetryBackoff = time.Second      //第一次失败后重新投递的延迟 之后每次翻倍
+	deliveryRetryLater   = 5 * time.Second  //ErrRetryLater时重新投递的延迟
+	deliveryMaxBackoff   = 10 * time.Minute //重新投递延迟的上限
+	deliveryAttempts     = "x-attempts"     //已经失败的次数
 )
 
+// ErrRetryLater 处理函数暂时不能处理 例如其他消费者正在处理同一个事件 延迟后重新投递 不计入失败次数
+var ErrRetryLater = errors.New("稍后重试")
+
+// delayed 复用同一个rabbitmq连接 已声明的exchange记录在exchanges中
 type delayed struct {
+	rm        *rabbitmq.RabbitMQ
+	exchanges sync.Map
+	sync.Mutex
 }
 
+var rabbitMqDelayed = new(delayed)
+
 func (d *delayed) Publish(event *mqBusEvent) error {
 	exchange := fmt.Sprintf("%s:delay:exchange", event.source)
-	rm := &rabbitmq.RabbitMQ{
-		URL:      viper.GetString("rabbitMq.url"),
-		Exchange: exchange,
+	if event.delay > delayedMaxTime*time.Second {
+		return fmt.Errorf("延迟时间%v超过上限%ds", event.delay, delayedMaxTime)
 	}
-	var t int64
-	h, ok := event.headers["x-delay"]
-	if ok {
-		t = h.(int64)
-	}
-	if t > delayedMaxTime {
-		t = delayedMaxTime
+	rm, err := d.load()
+	if err != nil {
+		return err
 	}
 	config.Logger.Info("print-srv:event-bus",
 		zap.Any("source", event.source),
 		zap.Any("exchange", exchange),
-		zap.Any("end-time", t),
+		zap.Any("end-time", event.delay),
 	)
+	if _, ok := d.exchanges.Load(exchange); !ok {
+		if err := rm.ExchangeDeclare(exchange); err != nil {
+			return err
+		}
+		d.exchanges.Store(exchange, struct{}{})
+	}
+	headers := make(amqp.Table, len(event.envelope.Headers)+2)
+	for k, v := range event.envelope.Headers {
+		headers[k] = v
+	}
+	headers["type"] = event.envelope.Type
+	if event.envelope.Key != "" {
+		headers["key"] = event.envelope.Key
+	}
+	err = rm.PublishMessage(exchange, event.source, rabbitmq.Message{
+		Body:    event.data,
+		Headers: headers,
+		Delay:   event.delay,
+	})
+	if err != nil {
+		config.Logger.Error("Error", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// load 第一次发布时建立连接 之后复用
+func (d *delayed) load() (*rabbitmq.RabbitMQ, error) {
+	d.Lock()
+	defer d.Unlock()
+	if d.rm != nil {
+		return d.rm, nil
+	}
+	rm := rabbitmq.New(viper.GetString("rabbitMq.url"), "")
+	// 等待broker确认 没有队列绑定的消息返回错误 避免事件静默丢失
+	rm.Confirm = true
+	rm.Mandatory = true
+	rm.ConfirmTimeout = viper.GetDuration("rabbitMq.confirm_timeout")
 	if err := rm.Load(); err != nil {
+		rm.Shutdown()
+		return nil, err
+	}
+	d.rm = rm
+	return rm, nil
+}
+
+func (d *delayed) shutdown() {
+	d.Lock()
+	defer d.Unlock()
+	if d.rm != nil {
+		d.rm.Shutdown()
+	}
+}
+
+// ShutdownRabbitMQ 关闭延迟事件使用的rabbitmq连接 用于服务退出
+func ShutdownRabbitMQ() {
+	rabbitMqDelayed.shutdown()
+}
+
+// deliveryBroker 重新投递和写入死信使用的连接
+type deliveryBroker interface {
+	QueueDeclare(queueName string) error
+	PublishMessage(exchange string, routingKey string, msg rabbitmq.Message) error
+}
+
+var loadDeliveryBroker = func() (deliveryBroker, error) {
+	return rabbitMqDelayed.load()
+}
+
+// HandleDelivery 处理rabbitmq投递的事件 成功后ack
+// 失败时通过延迟exchange只投递回原队列 延迟逐次翻倍 失败deliveryMaxAttempts次后写入死信队列`<queue>:dlq`
+// 返回ErrRetryLater时延迟后重新投递 不计入失败次数
+func HandleDelivery(delivery amqp.Delivery, handler EnvelopeHandler) error {
+	envelope, err := DecodeEnvelope(delivery.Body)
+	if err != nil {
+		//无法解析的消息重新入队也不会成功
+		config.Logger.Error("Error", zap.Error(err))
+		delivery.Nack(false, false)
 		return err
 	}
-	defer rm.Destroy()
-	if err := rm.PublishWithDelay(event.source, []byte(event.data), t); err != nil {
+	err = handleEnvelope(envelope, handler)
+	if err == nil {
+		return delivery.Ack(false)
+	}
+	config.Logger.Error("Error", zap.String("id", envelope.Id), zap.Error(err))
+	if e := retryDelivery(delivery, err); e != nil {
+		//重新投递失败时退回broker 不能丢失消息
+		config.Logger.Error("Error", zap.String("id", envelope.Id), zap.Error(e))
+		delivery.Nack(false, true)
 		return err
 	}
-	return nil
+	delivery.Ack(false)
+	return err
+}
+
+// retryDelivery 延迟后重新投递或者写入死信队列
+func retryDelivery(delivery amqp.Delivery, cause error) error {
+	broker, err := loadDeliveryBroker()
+	if err != nil {
+		return err
+	}
+	queue := rabbitmq.QueueOf(delivery)
+	attempts := deliveryAttemptsOf(delivery.Headers)
+	delay := deliveryRetryLater
+	if !errors.Is(cause, ErrRetryLater) {
+		attempts++
+		delay = deliveryBackoff(attempts)
+	}
+	headers := make(amqp.Table, len(delivery.Headers)+2)
+	for k, v := range delivery.Headers {
+		headers[k] = v
+	}
+	delete(headers, "x-delay")
+	headers[deliveryAttempts] = attempts
+	message := rabbitmq.Message{
+		Body:        delivery.Body,
+		ContentType: delivery.ContentType,
+		Headers:     headers,
+	}
+	if attempts >= deliveryMaxAttempts {
+		dlq := queue + deadLetterSuffix
+		headers[deadLetterError] = cause.Error()
+		config.Logger.Warn("print-srv:event-bus",
+			zap.String("dlq", dlq),
+			zap.String("id", delivery.MessageId),
+			zap.Int64("attempts", attempts),
+			zap.Error(cause),
+		)
+		if err := broker.QueueDeclare(dlq); err != nil {
+			return err
+		}
+		return broker.PublishMessage("", dlq, message)
+	}
+	message.Delay = delay
+	return broker.PublishMessage(delivery.Exchange, queue, message)
+}
+
+// deliveryAttemptsOf 消息已经失败的次数
+func deliveryAttemptsOf(headers amqp.Table) int64 {
+	switch v := headers[deliveryAttempts].(type) {
+	case int64:
+		return v
+	case int32:
+		return int64(v)
+	case int:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// deliveryBackoff 第attempts次失败后重新投递的延迟
+func deliveryBackoff(attempts int64) time.Duration {
+	d := deliveryRetryBackoff
+	for i := int64(1); i < attempts; i++ {
+		d *= 2
+		if d >= deliveryMaxBackoff {
+			return deliveryMaxBackoff
+		}
+	}
+	return d
 }
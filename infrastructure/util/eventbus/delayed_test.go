@@ -0,0 +1,176 @@
+package eventbus
+
+import (
+	"DDD/infrastructure/util/mq/rabbitmq"
+
+	"github.com/streadway/amqp"
+
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeAcknowledger struct {
+	acks, nacks int
+	requeue     bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acks++
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacks++
+	a.requeue = requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+type fakePublish struct {
+	exchange, key string
+	msg           rabbitmq.Message
+}
+
+type fakeDeliveryBroker struct {
+	queues    []string
+	published []fakePublish
+	err       error
+}
+
+func (b *fakeDeliveryBroker) QueueDeclare(queueName string) error {
+	b.queues = append(b.queues, queueName)
+	return nil
+}
+
+func (b *fakeDeliveryBroker) PublishMessage(exchange string, routingKey string, msg rabbitmq.Message) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.published = append(b.published, fakePublish{exchange, routingKey, msg})
+	return nil
+}
+
+func useDeliveryBroker(t *testing.T) *fakeDeliveryBroker {
+	broker := new(fakeDeliveryBroker)
+	load := loadDeliveryBroker
+	loadDeliveryBroker = func() (deliveryBroker, error) {
+		return broker, nil
+	}
+	t.Cleanup(func() {
+		loadDeliveryBroker = load
+	})
+	return broker
+}
+
+func newDelivery(ack *fakeAcknowledger, attempts interface{}) amqp.Delivery {
+	headers := amqp.Table{"type": "order.created", "x-delay": int64(-1000)}
+	if attempts != nil {
+		headers[deliveryAttempts] = attempts
+	}
+	return amqp.Delivery{
+		Acknowledger: ack,
+		ConsumerTag:  "order_consumer",
+		Exchange:     "order:delay:exchange",
+		RoutingKey:   "order",
+		Headers:      headers,
+		Body:         []byte(`{"id":"1","type":"order.created","version":1,"data":{}}`),
+	}
+}
+
+func TestHandleDeliveryAcksOnSuccess(t *testing.T) {
+	broker := useDeliveryBroker(t)
+	ack := new(fakeAcknowledger)
+	err := HandleDelivery(newDelivery(ack, nil), func(envelope *Envelope, event interface{}) error {
+		return nil
+	})
+	if err != nil || ack.acks != 1 || ack.nacks != 0 || len(broker.published) != 0 {
+		t.Fatalf("err %v acks %d nacks %d published %d", err, ack.acks, ack.nacks, len(broker.published))
+	}
+}
+
+func TestHandleDeliveryRetriesToOwnQueueWithBackoff(t *testing.T) {
+	broker := useDeliveryBroker(t)
+	ack := new(fakeAcknowledger)
+	cause := errors.New("boom")
+	err := HandleDelivery(newDelivery(ack, int32(1)), func(envelope *Envelope, event interface{}) error {
+		return cause
+	})
+	if err != cause {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if ack.acks != 1 || ack.nacks != 0 {
+		t.Fatalf("original should be acked after republish, acks %d nacks %d", ack.acks, ack.nacks)
+	}
+	if len(broker.published) != 1 {
+		t.Fatalf("expected 1 republish, got %d", len(broker.published))
+	}
+	p := broker.published[0]
+	if p.exchange != "order:delay:exchange" || p.key != "order" {
+		t.Fatalf("republished to %s/%s", p.exchange, p.key)
+	}
+	if p.msg.Delay != 2*time.Second {
+		t.Fatalf("expected 2s backoff, got %v", p.msg.Delay)
+	}
+	if p.msg.Headers[deliveryAttempts] != int64(2) {
+		t.Fatalf("expected attempts 2, got %v", p.msg.Headers[deliveryAttempts])
+	}
+	if _, ok := p.msg.Headers["x-delay"]; ok {
+		t.Fatal("stale x-delay header should be dropped")
+	}
+}
+
+func TestHandleDeliveryRetryLaterDoesNotCountAttempt(t *testing.T) {
+	broker := useDeliveryBroker(t)
+	ack := new(fakeAcknowledger)
+	HandleDelivery(newDelivery(ack, int64(3)), func(envelope *Envelope, event interface{}) error {
+		return fmt.Errorf("busy: %w", ErrRetryLater)
+	})
+	if len(broker.published) != 1 {
+		t.Fatalf("expected 1 republish, got %d", len(broker.published))
+	}
+	p := broker.published[0]
+	if p.msg.Delay != deliveryRetryLater || p.msg.Headers[deliveryAttempts] != int64(3) {
+		t.Fatalf("delay %v attempts %v", p.msg.Delay, p.msg.Headers[deliveryAttempts])
+	}
+}
+
+func TestHandleDeliveryDeadLettersAfterMaxAttempts(t *testing.T) {
+	broker := useDeliveryBroker(t)
+	ack := new(fakeAcknowledger)
+	HandleDelivery(newDelivery(ack, int64(deliveryMaxAttempts-1)), func(envelope *Envelope, event interface{}) error {
+		return errors.New("poison")
+	})
+	if len(broker.queues) != 1 || broker.queues[0] != "order:dlq" {
+		t.Fatalf("expected dlq declared, got %v", broker.queues)
+	}
+	if len(broker.published) != 1 {
+		t.Fatalf("expected 1 publish, got %d", len(broker.published))
+	}
+	p := broker.published[0]
+	if p.exchange != "" || p.key != "order:dlq" || p.msg.Delay != 0 {
+		t.Fatalf("dead lettered to %q/%q delay %v", p.exchange, p.key, p.msg.Delay)
+	}
+	if p.msg.Headers[deadLetterError] != "poison" {
+		t.Fatalf("expected error header, got %v", p.msg.Headers[deadLetterError])
+	}
+	if ack.acks != 1 {
+		t.Fatalf("original should be acked, acks %d", ack.acks)
+	}
+}
+
+func TestHandleDeliveryRequeuesWhenRepublishFails(t *testing.T) {
+	broker := useDeliveryBroker(t)
+	broker.err = errors.New("broker down")
+	ack := new(fakeAcknowledger)
+	HandleDelivery(newDelivery(ack, nil), func(envelope *Envelope, event interface{}) error {
+		return errors.New("boom")
+	})
+	if ack.acks != 0 || ack.nacks != 1 || !ack.requeue {
+		t.Fatalf("acks %d nacks %d requeue %v", ack.acks, ack.nacks, ack.requeue)
+	}
+}
@@ -0,0 +1,136 @@
+package eventbus
+
+import (
+	"DDD/infrastructure/util/redis"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Event 通过MqBus发布的事件 EventType和EventVersion用于消费端找回注册的类型
+type Event interface {
+	EventType() string
+	EventVersion() int
+}
+
+// Envelope 事件信封 streams和rabbitmq发送的都是序列化后的信封
+type Envelope struct {
+	Id            string                 `json:"id"`
+	Type          string                 `json:"type"`
+	Version       int                    `json:"version"`
+	OccurredAt    time.Time              `json:"occurred_at"`
+	Source        string                 `json:"source,omitempty"`         //产生事件的聚合 例如order:1
+	CorrelationId string                 `json:"correlation_id,omitempty"` //关联id 例如请求id
+	Key           string                 `json:"key,omitempty"`
+	ContentType   string                 `json:"content_type,omitempty"` //Data的数据类型
+	Headers       map[string]interface{} `json:"headers,omitempty"`
+	Data          json.RawMessage        `json:"data"`
+}
+
+// EnvelopeHandler 处理解析后的事件 event为注册类型的指针 类型没有注册时为nil 可以用envelope.DecodeTo解析
+type EnvelopeHandler func(envelope *Envelope, event interface{}) error
+
+// ErrEventNotRegistered 信封中的事件类型和版本没有注册
+var ErrEventNotRegistered = errors.New("事件类型没有注册")
+
+type eventTypes struct {
+	types map[string]reflect.Type
+	sync.RWMutex
+}
+
+var registeredEvents = &eventTypes{
+	types: make(map[string]reflect.Type),
+}
+
+func eventTypeKey(name string, version int) string {
+	return fmt.Sprintf("%s@v%d", name, version)
+}
+
+// RegisterEvent 注册事件类型 同一个事件的不同版本需要分别注册
+func RegisterEvent(events ...Event) {
+	registeredEvents.Lock()
+	defer registeredEvents.Unlock()
+	for i := range events[:] {
+		registeredEvents.types[eventTypeKey(events[i].EventType(), events[i].EventVersion())] = reflect.TypeOf(events[i])
+	}
+}
+
+// DecodeEnvelope 解析信封
+func DecodeEnvelope(b []byte) (*Envelope, error) {
+	envelope := new(Envelope)
+	if err := json.Unmarshal(b, envelope); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// Decode 把Data解析成注册的类型 返回该类型的指针
+func (e *Envelope) Decode() (interface{}, error) {
+	v, err := e.decode()
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// decodeRegistered 解析成注册时的类型 注册的是值时返回值 注册的是指针时返回指针
+func (e *Envelope) decodeRegistered() (interface{}, error) {
+	v, err := e.decode()
+	if err != nil {
+		return nil, err
+	}
+	registeredEvents.RLock()
+	t := registeredEvents.types[eventTypeKey(e.Type, e.Version)]
+	registeredEvents.RUnlock()
+	if t.Kind() != reflect.Ptr {
+		v = v.Elem()
+	}
+	return v.Interface(), nil
+}
+
+func (e *Envelope) decode() (reflect.Value, error) {
+	registeredEvents.RLock()
+	t, ok := registeredEvents.types[eventTypeKey(e.Type, e.Version)]
+	registeredEvents.RUnlock()
+	if !ok {
+		return reflect.Value{}, ErrEventNotRegistered
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(e.Data, v.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return v, nil
+}
+
+// DecodeTo 把Data解析到v 不需要注册
+func (e *Envelope) DecodeTo(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// DecodeStreamsMessage 从streams消息中解析信封
+func DecodeStreamsMessage(message redis.StreamMessage) (*Envelope, error) {
+	data, ok := message.Values[streamsEnvelopeField]
+	if !ok {
+		return nil, fmt.Errorf("streams消息%s没有%s字段", message.Id, streamsEnvelopeField)
+	}
+	return DecodeEnvelope([]byte(data))
+}
+
+// handleEnvelope 解析信封和事件后调用handler
+func handleEnvelope(envelope *Envelope, handler EnvelopeHandler) error {
+	event, err := envelope.Decode()
+	if err == ErrEventNotRegistered {
+		return handler(envelope, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return handler(envelope, event)
+}
@@ -0,0 +1,86 @@
+package eventbus
+
+import (
+	"DDD/infrastructure/config/config"
+
+	"go.uber.org/zap"
+
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrorPolicy 同步订阅函数返回错误时的处理方式
+type ErrorPolicy int8
+
+const (
+	ContinueOnError ErrorPolicy = iota //继续执行后面的订阅函数 返回全部错误
+	StopOnError                        //第一个错误后停止执行后面的订阅函数
+)
+
+// ErrorHandler 处理Publish和异步订阅函数的错误
+type ErrorHandler func(err *HandlerError)
+
+// HandlerError 订阅函数返回的错误或panic
+type HandlerError struct {
+	Topic   string
+	Handler string
+	Err     error
+	Panic   interface{} //panic时的值 没有panic时为nil
+	Stack   []byte      //panic时的调用栈
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("topic %s handler %s: %v", e.Topic, e.Handler, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// HandlerErrors 一次推送中多个订阅函数的错误
+type HandlerErrors []*HandlerError
+
+func (e HandlerErrors) Error() string {
+	msg := make([]string, 0, len(e))
+	for i := range e[:] {
+		msg = append(msg, e[i].Error())
+	}
+	return strings.Join(msg, "; ")
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// returnedError 订阅函数最后一个返回值为error时返回该错误
+func returnedError(results []reflect.Value) error {
+	if len(results) == 0 {
+		return nil
+	}
+	last := results[len(results)-1]
+	if !last.Type().Implements(errorType) {
+		return nil
+	}
+	err, _ := last.Interface().(error)
+	return err
+}
+
+// SetErrorHandler 设置错误处理函数 为nil时只记录日志
+func (bus *EventBus) SetErrorHandler(handler ErrorHandler) {
+	bus.errorHandler.Store(handler)
+}
+
+func (bus *EventBus) reportError(err *HandlerError) {
+	if handler, _ := bus.errorHandler.Load().(ErrorHandler); handler != nil {
+		handler(err)
+		return
+	}
+	fields := []zap.Field{
+		zap.String("topic", err.Topic),
+		zap.String("handler", err.Handler),
+		zap.Error(err.Err),
+	}
+	if err.Panic != nil {
+		fields = append(fields, zap.ByteString("stack", err.Stack))
+	}
+	config.Logger.Error("print-srv:event-bus", fields...)
+}
@@ -0,0 +1,140 @@
+package eventbus
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type failingObserver struct {
+	Fn string `subscribe:"Handle" topic:"order.created"`
+}
+
+func (o failingObserver) Handle(event orderCreated) error {
+	return errReflected
+}
+
+var errReflected = errors.New("reflected handler failed")
+
+// subscribeSteps 依次订阅 失败的订阅函数返回错误 panic的订阅函数panic 记录执行顺序
+func subscribeSteps(t *testing.T, bus Bus) *[]string {
+	t.Helper()
+	ran := new([]string)
+	steps := []struct {
+		name string
+		fn   func(event orderCreated) error
+	}{
+		{"first", func(event orderCreated) error { return errors.New("first failed") }},
+		{"panic", func(event orderCreated) error { panic("boom") }},
+		{"last", func(event orderCreated) error { return nil }},
+	}
+	for _, step := range steps {
+		step := step
+		if _, err := SubscribeWithError(bus, "order.created", func(event orderCreated) error {
+			*ran = append(*ran, step.name)
+			return step.fn(event)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ran
+}
+
+func TestPublishErrCollectsErrorsAndRecoversPanics(t *testing.T) {
+	bus := New()
+	ran := subscribeSteps(t, bus)
+	if err := bus.Subscribe(failingObserver{}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := bus.PublishErr("order.created", orderCreated{Id: 1})
+	errs, ok := err.(HandlerErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expected 3 handler errors, got %v", err)
+	}
+	if got := strings.Join(*ran, ","); got != "first,panic,last" {
+		t.Fatalf("every handler should run, ran %s", got)
+	}
+	if errs[0].Err.Error() != "first failed" || errs[0].Topic != "order.created" || errs[0].Panic != nil {
+		t.Fatalf("unexpected first error %+v", errs[0])
+	}
+	if errs[1].Panic != "boom" || len(errs[1].Stack) == 0 || !strings.Contains(errs[1].Err.Error(), "boom") {
+		t.Fatalf("the panic should be recovered with its stack, got %+v", errs[1])
+	}
+	if !errors.Is(errs[2], errReflected) || errs[2].Handler != "eventbus.failingObserver.Handle" {
+		t.Fatalf("the reflected handler error should be returned, got %+v", errs[2])
+	}
+}
+
+func TestPublishWithPolicyStopOnError(t *testing.T) {
+	bus := New()
+	ran := subscribeSteps(t, bus)
+	err := bus.PublishWithPolicy("order.created", StopOnError, orderCreated{Id: 1})
+	errs, ok := err.(HandlerErrors)
+	if !ok || len(errs) != 1 || errs[0].Err.Error() != "first failed" {
+		t.Fatalf("expected only the first error, got %v", err)
+	}
+	if got := strings.Join(*ran, ","); got != "first" {
+		t.Fatalf("handlers after the error should not run, ran %s", got)
+	}
+	if err := bus.PublishWithPolicy("order.paid", StopOnError, orderCreated{Id: 1}); err != nil {
+		t.Fatalf("a topic without handlers should not fail, got %v", err)
+	}
+}
+
+func TestPublishReportsErrorsToErrorHandler(t *testing.T) {
+	bus := New()
+	var mu sync.Mutex
+	var reported []*HandlerError
+	bus.SetErrorHandler(func(err *HandlerError) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	})
+	subscribeSteps(t, bus)
+	if _, err := SubscribeAsyncWithError(bus, "order.created", func(event orderCreated) error {
+		panic("async boom")
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	//Publish没有返回值 同步和异步订阅函数的错误都交给ErrorHandler
+	bus.Publish("order.created", orderCreated{Id: 1})
+	bus.WaitAsync()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 3 {
+		t.Fatalf("expected 3 reported errors, got %d", len(reported))
+	}
+	panics := 0
+	for i := range reported {
+		if reported[i].Panic != nil {
+			panics++
+		}
+	}
+	if panics != 2 {
+		t.Fatalf("expected the sync and async panics to be reported, got %d", panics)
+	}
+}
+
+func TestReturnedError(t *testing.T) {
+	cases := []struct {
+		name string
+		fn   interface{}
+		want error
+	}{
+		{"no result", func() {}, nil},
+		{"nil error", func() error { return nil }, nil},
+		{"error", func() error { return errReflected }, errReflected},
+		{"last result", func() (int, error) { return 1, errReflected }, errReflected},
+		{"not an error", func() int { return 1 }, nil},
+	}
+	for _, c := range cases {
+		results := reflect.ValueOf(c.fn).Call(nil)
+		if got := returnedError(results); got != c.want {
+			t.Fatalf("%s: returnedError = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
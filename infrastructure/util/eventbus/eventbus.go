@@ -1,10 +1,14 @@
 package eventbus
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //BusSubscriber 订阅
@@ -19,12 +23,21 @@ type BusSubscriber interface {
 //BusPublisher 发布
 type BusPublisher interface {
 	Publish(topic string, args ...interface{})
+	PublishErr(topic string, args ...interface{}) error
+	PublishWithPolicy(topic string, policy ErrorPolicy, args ...interface{}) error
+	PublishCtx(ctx context.Context, topic string, args ...interface{}) error
 }
 
 //BusController 检查
 type BusController interface {
 	HasCallback(topic string) bool
+	SetErrorHandler(handler ErrorHandler)
 	WaitAsync()
+	WaitAsyncCtx(ctx context.Context) error
+	WaitAsyncTimeout(timeout time.Duration) error
+	SetDefaultPool(config PoolConfig) error
+	SetTopicPool(topic string, config PoolConfig) error
+	PoolStats() map[string]PoolStats
 	Stop()
 }
 
@@ -37,8 +50,12 @@ type Bus interface {
 
 // EventBus 事件总线
 type EventBus struct {
-	handlers *sync.Map
-	wg       *sync.WaitGroup
+	handlers     *sync.Map //topic => []*eventHandler 保存后的切片不再修改 修改时复制
+	patterns     *topicTrie
+	mu           sync.Mutex //串行执行订阅和取消订阅
+	wg           *sync.WaitGroup
+	errorHandler atomic.Value
+	pools        pools
 }
 
 type eventHandler struct {
@@ -47,26 +64,23 @@ type eventHandler struct {
 	flagOnce      bool
 	async         bool
 	transactional bool
-	*sync.Mutex
+	serial        *serialQueue //transactional的订阅函数按推送顺序串行执行
+	typed     func(ctx context.Context, args []interface{}) error //类型安全的订阅函数 不为nil时不使用反射调用
+	eventType reflect.Type                                        //typed订阅的事件类型
+	name      string                                              //订阅函数名称 用于错误信息
+	fired     int32                                               //flagOnce的订阅函数是否已经执行
 }
 
 // New new
 func New() Bus {
 	b := &EventBus{
-		new(sync.Map),
-		new(sync.WaitGroup),
+		handlers: new(sync.Map),
+		patterns: newTopicTrie(),
+		wg:       new(sync.WaitGroup),
 	}
 	return Bus(b)
 }
 
-// doSubscribe 处理订阅逻辑
-func (bus *EventBus) doSubscribe(topic string, handler *eventHandler) error {
-	handlerInterface, _ := bus.handlers.LoadOrStore(topic, make([]*eventHandler, 0))
-	handlers := handlerInterface.([]*eventHandler)
-	bus.handlers.Store(topic, append(handlers, handler))
-	return nil
-}
-
 func (bus *EventBus) checkObserver(observer interface{}) ([]string, reflect.Type, string, error) {
 	topic := make([]string, 0)
 	var t reflect.Type
@@ -102,9 +116,17 @@ func (bus *EventBus) Register(observer interface{}, flagOnce, async, transaction
 		if !ok {
 			continue
 		}
-		_ = bus.doSubscribe(topic[i], &eventHandler{
-			observer, function.Func, flagOnce, async, transactional, new(sync.Mutex),
-		})
+		handler := &eventHandler{
+			observer: observer, callBack: function.Func, flagOnce: flagOnce, async: async, transactional: transactional, serial: new(serialQueue),
+			name: t.String() + "." + fn,
+		}
+		if err := bus.doSubscribe(topic[i], handler); err != nil {
+			//已经订阅的topic一起取消
+			for j := 0; j < i; j++ {
+				bus.removeHandlerWhere(topic[j], sameCallback(function.Func))
+			}
+			return err
+		}
 	}
 	return nil
 }
@@ -130,14 +152,17 @@ func (bus *EventBus) SubscribeOnceAsync(observer interface{}) error {
 	return bus.Register(observer, true, true, false)
 }
 
-// HasCallback 查看事件订阅的函数
+// HasCallback 查看事件订阅的函数 topic为通配符时查看该通配符的订阅 否则包括能匹配topic的通配符订阅
 func (bus *EventBus) HasCallback(topic string) bool {
-	handlersInterface, ok := bus.handlers.Load(topic)
-	if ok {
-		handlers := handlersInterface.([]*eventHandler)
-		return len(handlers) > 0
+	if isPattern(topic) {
+		handlersInterface, ok := bus.handlers.Load(topic)
+		if ok {
+			handlers := handlersInterface.([]*eventHandler)
+			return len(handlers) > 0
+		}
+		return false
 	}
-	return false
+	return len(bus.matchHandlers(topic)) > 0
 }
 
 // Unsubscribe 删除订阅
@@ -151,87 +176,167 @@ func (bus *EventBus) Unsubscribe(observer interface{}) error {
 		if !ok {
 			continue
 		}
-		bus.removeHandler(topic[i], bus.findHandlerIdx(topic[i], function.Func))
+		bus.removeHandlerWhere(topic[i], sameCallback(function.Func))
 	}
 	return nil
 }
 
-func (bus *EventBus) removeHandler(topic string, idx int) {
-	handlerInterface, ok := bus.handlers.Load(topic)
-	if !ok {
-		return
+// Publish 推送 订阅函数的错误和panic交给ErrorHandler处理
+func (bus *EventBus) Publish(topic string, args ...interface{}) {
+	errs := bus.publish(context.Background(), topic, ContinueOnError, args...)
+	for i := range errs[:] {
+		bus.reportError(errs[i])
 	}
-	handlers := handlerInterface.([]*eventHandler)
-	l := len(handlers)
+}
 
-	if !(0 <= idx && idx < l) {
-		return
-	}
-	handlers = append(handlers[:idx], handlers[idx+1:]...)
-	if len(handlers) > 0 {
-		bus.handlers.Store(topic, handlers)
-	} else {
-		bus.handlers.Delete(topic)
+// PublishErr 推送 返回同步订阅函数的全部错误 异步订阅的错误交给ErrorHandler处理
+func (bus *EventBus) PublishErr(topic string, args ...interface{}) error {
+	return bus.PublishWithPolicy(topic, ContinueOnError, args...)
+}
+
+// PublishWithPolicy 推送 policy为StopOnError时第一个错误后不再执行后面的订阅函数
+func (bus *EventBus) PublishWithPolicy(topic string, policy ErrorPolicy, args ...interface{}) error {
+	if errs := bus.publish(context.Background(), topic, policy, args...); len(errs) > 0 {
+		return errs
 	}
+	return nil
 }
 
-// Publish 推送
-func (bus *EventBus) Publish(topic string, args ...interface{}) {
-	if handlerInterface, ok := bus.handlers.Load(topic); ok {
-		handlers := handlerInterface.([]*eventHandler)
-		if len(handlers) == 0 {
-			return
+func (bus *EventBus) publish(ctx context.Context, topic string, policy ErrorPolicy, args ...interface{}) HandlerErrors {
+	return bus.dispatch(ctx, topic, policy, func(handler *eventHandler) ([]interface{}, error) {
+		return args, nil
+	})
+}
+
+// dispatch 推送到匹配的订阅函数 argsFor返回每个订阅函数的参数 返回错误时不执行该订阅函数
+func (bus *EventBus) dispatch(ctx context.Context, topic string, policy ErrorPolicy, argsFor func(handler *eventHandler) ([]interface{}, error)) HandlerErrors {
+	var errs HandlerErrors
+	matched := bus.matchHandlers(topic)
+	for i := range matched[:] {
+		handler := matched[i].handler
+		if err := ctx.Err(); err != nil {
+			//取消后不再执行后面的订阅函数
+			errs = append(errs, &HandlerError{Topic: topic, Handler: handler.name, Err: err})
+			return errs
+		}
+		args, err := argsFor(handler)
+		if err != nil {
+			errs = append(errs, &HandlerError{Topic: topic, Handler: handler.name, Err: err})
+			if policy == StopOnError {
+				return errs
+			}
+			continue
 		}
-		for i, handler := range handlers {
-			if handler.flagOnce {
-				bus.removeHandler(topic, i)
+		if handler.flagOnce {
+			//并发推送时只有一个能执行
+			if !atomic.CompareAndSwapInt32(&handler.fired, 0, 1) {
+				continue
 			}
-			if !handler.async {
-				bus.doPublish(handler, args...)
-			} else {
-				bus.wg.Add(1)
-				if handler.transactional {
-					handler.Lock()
+			bus.removeHandlerPtr(matched[i].key, handler)
+		}
+		if !handler.async {
+			if err := bus.doPublish(ctx, topic, handler, args...); err != nil {
+				errs = append(errs, err)
+				if policy == StopOnError {
+					return errs
 				}
-				go bus.doPublishAsync(handlers[i], args...)
+			}
+		} else if err := bus.publishAsync(ctx, topic, handler, args...); err != nil {
+			errs = append(errs, err)
+			if policy == StopOnError {
+				return errs
 			}
 		}
 	}
+	return errs
 }
 
-func (bus *EventBus) doPublish(handler *eventHandler, args ...interface{}) {
-	passedArguments := bus.setUpPublish(handler, args...)
-	handler.callBack.Call(passedArguments)
+// doPublish 执行订阅函数 panic转换为错误
+func (bus *EventBus) doPublish(ctx context.Context, topic string, handler *eventHandler, args ...interface{}) (handlerErr *HandlerError) {
+	defer func() {
+		if r := recover(); r != nil {
+			handlerErr = &HandlerError{
+				Topic:   topic,
+				Handler: handler.name,
+				Err:     fmt.Errorf("panic: %v", r),
+				Panic:   r,
+				Stack:   debug.Stack(),
+			}
+		}
+	}()
+	var err error
+	if handler.typed != nil {
+		err = handler.typed(ctx, args)
+	} else {
+		passedArguments := bus.setUpPublish(ctx, handler, args...)
+		err = returnedError(handler.callBack.Call(passedArguments))
+	}
+	if err != nil {
+		return &HandlerError{
+			Topic:   topic,
+			Handler: handler.name,
+			Err:     err,
+		}
+	}
+	return nil
 }
 
-func (bus *EventBus) doPublishAsync(handler *eventHandler, args ...interface{}) {
-	defer bus.wg.Done()
-	if handler.transactional {
-		defer handler.Unlock()
+// publishAsync 配置了协程池时放入队列 否则启动一个协程执行
+// 协程池被替换时放入新的协程池 已经停止时返回ErrPoolClosed
+func (bus *EventBus) publishAsync(ctx context.Context, topic string, handler *eventHandler, args ...interface{}) *HandlerError {
+	p := bus.poolOf(topic, handler)
+	if p == nil {
+		bus.wg.Add(1)
+		if handler.transactional {
+			handler.serial.push(func() {
+				bus.doPublishAsync(ctx, topic, handler, args...)
+			})
+			return nil
+		}
+		go bus.doPublishAsync(ctx, topic, handler, args...)
+		return nil
+	}
+	for p != nil {
+		err := p.submit(&poolJob{ctx: ctx, topic: topic, handler: handler, args: args})
+		if err == nil {
+			return nil
+		}
+		if err != ErrPoolClosed {
+			return &HandlerError{Topic: topic, Handler: handler.name, Err: err}
+		}
+		next := bus.poolOf(topic, handler)
+		if next == p {
+			break
+		}
+		p = next
 	}
-	bus.doPublish(handler, args...)
+	return &HandlerError{Topic: topic, Handler: handler.name, Err: ErrPoolClosed}
 }
 
-func (bus *EventBus) findHandlerIdx(topic string, callback reflect.Value) int {
-	if handlerInterface, ok := bus.handlers.Load(topic); ok {
-		handlers := handlerInterface.([]*eventHandler)
-		for i := range handlers[:] {
-			if handlers[i].callBack.Type() == callback.Type() &&
-				handlers[i].callBack.Pointer() == callback.Pointer() {
-				return i
-			}
-		}
+func (bus *EventBus) doPublishAsync(ctx context.Context, topic string, handler *eventHandler, args ...interface{}) {
+	defer bus.wg.Done()
+	if err := ctx.Err(); err != nil {
+		//等待执行期间已经取消
+		bus.reportError(&HandlerError{Topic: topic, Handler: handler.name, Err: err})
+		return
+	}
+	if err := bus.doPublish(ctx, topic, handler, args...); err != nil {
+		bus.reportError(err)
 	}
-	return -1
 }
 
-func (bus *EventBus) setUpPublish(callback *eventHandler, args ...interface{}) []reflect.Value {
+func (bus *EventBus) setUpPublish(ctx context.Context, callback *eventHandler, args ...interface{}) []reflect.Value {
 	funcType := callback.callBack.Type()
-	passedArguments := make([]reflect.Value, 0, len(args)+1)
+	passedArguments := make([]reflect.Value, 0, len(args)+2)
 	passedArguments = append(passedArguments, reflect.ValueOf(callback.observer))
+	//订阅函数第一个参数为context.Context时传入ctx
+	if callback.acceptsContext() {
+		passedArguments = append(passedArguments, reflect.ValueOf(&ctx).Elem())
+	}
+	offset := len(passedArguments)
 	for i := range args[:] {
 		if args[i] == nil {
-			passedArguments = append(passedArguments, reflect.New(funcType.In(i)).Elem())
+			passedArguments = append(passedArguments, reflect.New(funcType.In(i+offset)).Elem())
 		} else {
 			passedArguments = append(passedArguments, reflect.ValueOf(args[i]))
 		}
@@ -250,6 +355,9 @@ func (bus *EventBus) Stop() {
 	bus.handlers.Range(func(topic, value interface{}) bool {
 		handlers := value.([]*eventHandler)
 		for i := range handlers[:] {
+			if handlers[i].typed != nil {
+				continue
+			}
 			t, fn, ok := bus.checkStop(handlers[i].observer)
 			if !ok {
 				return false
@@ -264,6 +372,7 @@ func (bus *EventBus) Stop() {
 
 		return true
 	})
+	bus.stopPools()
 }
 func (bus *EventBus) checkStop(observer interface{}) (reflect.Type, string, bool) {
 	var fn string
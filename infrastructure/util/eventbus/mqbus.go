@@ -2,6 +2,7 @@ package eventbus
 
 import (
 	"DDD/infrastructure/util/pkg/snowflake"
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -14,7 +15,7 @@ const (
 
 var MqBusMq = map[int8]Mq{
 	EventStreams:       Mq(new(streams)),
-	EventRabbitMqDelay: Mq(new(delayed)),
+	EventRabbitMqDelay: Mq(rabbitMqDelayed),
 }
 
 type Mq interface {
@@ -23,36 +24,162 @@ type Mq interface {
 
 type MqBusPublisher interface {
 	Publish(eventType int8, topic, args string) error
+	PublishWithOptions(eventType int8, topic, args string, options ...PublishOption) error
+	PublishEvent(eventType int8, topic string, event Event, options ...PublishOption) error
+	PublishMessage(message *Message) error
 }
 
 type MqBus interface {
 	MqBusPublisher
 }
 
+// mqBusEvent 发送到mq的事件 source为topic data为序列化后的Envelope
 type mqBusEvent struct {
 	id       string
-	datetime string
 	source   string
-	data     string
-	headers  map[string]interface{}
+	data     []byte
+	delay    time.Duration
+	envelope *Envelope
 }
+
+// Message 生成好但还没有发送的消息 用于先保存再发送的场景 例如outbox
+type Message struct {
+	EventType int8
+	Topic     string
+	Envelope  *Envelope
+	Delay     time.Duration
+}
+
+// PublishOption 发布选项
+type PublishOption func(event *mqBusEvent)
+
+// WithDelay 延迟投递
+func WithDelay(delay time.Duration) PublishOption {
+	return func(event *mqBusEvent) {
+		event.delay = delay
+	}
+}
+
+// WithSource 产生事件的聚合
+func WithSource(aggregate string) PublishOption {
+	return func(event *mqBusEvent) {
+		event.envelope.Source = aggregate
+	}
+}
+
+// WithCorrelationId 关联id
+func WithCorrelationId(correlationId string) PublishOption {
+	return func(event *mqBusEvent) {
+		event.envelope.CorrelationId = correlationId
+	}
+}
+
+// WithHeader 自定义header 可以多次使用
+func WithHeader(key string, value interface{}) PublishOption {
+	return func(event *mqBusEvent) {
+		if event.envelope.Headers == nil {
+			event.envelope.Headers = make(map[string]interface{})
+		}
+		event.envelope.Headers[key] = value
+	}
+}
+
+// WithContentType 信封中Data的数据类型
+func WithContentType(contentType string) PublishOption {
+	return func(event *mqBusEvent) {
+		event.envelope.ContentType = contentType
+	}
+}
+
+// WithKey 消息的业务key 例如订单号
+func WithKey(key string) PublishOption {
+	return func(event *mqBusEvent) {
+		event.envelope.Key = key
+	}
+}
+
 type MessageQueueBus struct {
 }
 
 func (bus *MessageQueueBus) Publish(eventType int8, topic, args string) error {
-	f, ok := MqBusMq[eventType]
+	return bus.PublishWithOptions(eventType, topic, args)
+}
+
+// PublishWithOptions 带选项推送 args作为字符串放入信封 事件类型为topic
+func (bus *MessageQueueBus) PublishWithOptions(eventType int8, topic, args string, options ...PublishOption) error {
+	return bus.publish(eventType, topic, topic, 1, args, options...)
+}
+
+// PublishEvent 推送事件 event序列化后放入信封
+func (bus *MessageQueueBus) PublishEvent(eventType int8, topic string, event Event, options ...PublishOption) error {
+	return bus.publish(eventType, topic, event.EventType(), event.EventVersion(), event, options...)
+}
+
+func (bus *MessageQueueBus) publish(eventType int8, topic, name string, version int, payload interface{}, options ...PublishOption) error {
+	message, err := newMessage(eventType, topic, name, version, payload, options...)
+	if err != nil {
+		return err
+	}
+	return bus.PublishMessage(message)
+}
+
+// PublishMessage 发送已经生成的消息
+func (bus *MessageQueueBus) PublishMessage(message *Message) error {
+	f, ok := MqBusMq[message.EventType]
 	if !ok {
 		return errors.New("事件类型错误")
 	}
-	event := &mqBusEvent{
-		id:       snowflake.BaseNumber(),
-		datetime: time.Now().Format("2006-01-02 15:04:05"),
-		source:   topic,
-		data:     args,
+	data, err := json.Marshal(message.Envelope)
+	if err != nil {
+		return err
 	}
+	return f.Publish(&mqBusEvent{
+		id:       message.Envelope.Id,
+		source:   message.Topic,
+		data:     data,
+		delay:    message.Delay,
+		envelope: message.Envelope,
+	})
+}
 
-	return f.Publish(event)
+// NewMessage 生成消息但不发送
+func NewMessage(eventType int8, topic string, event Event, options ...PublishOption) (*Message, error) {
+	return newMessage(eventType, topic, event.EventType(), event.EventVersion(), event, options...)
 }
+
+func newMessage(eventType int8, topic, name string, version int, payload interface{}, options ...PublishOption) (*Message, error) {
+	if _, ok := MqBusMq[eventType]; !ok {
+		return nil, errors.New("事件类型错误")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	event := &mqBusEvent{
+		id:     snowflake.BaseNumberString(),
+		source: topic,
+	}
+	event.envelope = &Envelope{
+		Id:         event.id,
+		Type:       name,
+		Version:    version,
+		OccurredAt: time.Now(),
+		Data:       data,
+	}
+	for i := range options[:] {
+		options[i](event)
+	}
+	if event.delay < 0 {
+		return nil, errors.New("延迟时间不能小于0")
+	}
+	return &Message{
+		EventType: eventType,
+		Topic:     topic,
+		Envelope:  event.envelope,
+		Delay:     event.delay,
+	}, nil
+}
+
 func NewMqBus() MqBus {
 	return MqBus(new(MessageQueueBus))
 }
@@ -0,0 +1,392 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy 队列满时的处理方式
+type OverflowPolicy int8
+
+const (
+	OverflowBlock OverflowPolicy = iota //等待队列有空位 PublishCtx的ctx取消时返回
+	OverflowDrop                        //丢弃 计入Dropped
+	OverflowError                       //返回ErrQueueFull
+)
+
+// ErrQueueFull 异步订阅函数的队列已满
+var ErrQueueFull = errors.New("异步事件队列已满")
+
+// ErrPoolClosed 协程池已经停止 总线已经Stop或订阅已经取消
+var ErrPoolClosed = errors.New("协程池已关闭")
+
+// PoolConfig 异步订阅函数的协程池配置
+type PoolConfig struct {
+	Workers   int            //并发数
+	QueueSize int            //等待执行的队列长度
+	Overflow  OverflowPolicy //队列满时的处理方式
+}
+
+// PoolStats 协程池的指标
+type PoolStats struct {
+	Workers     int           `json:"workers"`
+	QueueSize   int           `json:"queue_size"`
+	QueueDepth  int           `json:"queue_depth"` //等待worker的数量 不包括排队等待transactional订阅函数的任务
+	Running     int64         `json:"running"`     //正在执行的数量
+	Submitted   uint64        `json:"submitted"`
+	Completed   uint64        `json:"completed"`
+	Failed      uint64        `json:"failed"`
+	Dropped     uint64        `json:"dropped"`
+	Rejected    uint64        `json:"rejected"`
+	AvgLatency  time.Duration `json:"avg_latency"` //订阅函数平均执行时间
+	MaxLatency  time.Duration `json:"max_latency"`
+	LastLatency time.Duration `json:"last_latency"`
+}
+
+type poolJob struct {
+	ctx     context.Context
+	topic   string
+	handler *eventHandler
+	args    []interface{}
+}
+
+// pool 固定数量的worker执行异步订阅函数
+type pool struct {
+	config  PoolConfig
+	bus     *EventBus
+	jobs    chan *poolJob
+	quit    chan struct{}
+	closed  bool
+	mu      sync.RWMutex
+	senders sync.WaitGroup //OverflowBlock时等待队列空位的submit
+
+	running      int64
+	submitted    uint64
+	completed    uint64
+	failed       uint64
+	dropped      uint64
+	rejected     uint64
+	totalLatency int64
+	maxLatency   int64
+	lastLatency  int64
+}
+
+func newPool(bus *EventBus, config PoolConfig) (*pool, error) {
+	if config.Workers <= 0 {
+		return nil, errors.New("协程池的并发数需要大于0")
+	}
+	if config.QueueSize < 0 {
+		return nil, errors.New("协程池的队列长度不能小于0")
+	}
+	p := &pool{
+		config: config,
+		bus:    bus,
+		jobs:   make(chan *poolJob, config.QueueSize),
+		quit:   make(chan struct{}),
+	}
+	for i := 0; i < config.Workers; i++ {
+		go p.work()
+	}
+	return p, nil
+}
+
+// submit 放入队列 按Overflow处理队列满的情况
+func (p *pool) submit(job *poolJob) error {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrPoolClosed
+	}
+	p.bus.wg.Add(1)
+	select {
+	case p.jobs <- job:
+		p.mu.RUnlock()
+		atomic.AddUint64(&p.submitted, 1)
+		return nil
+	default:
+	}
+	switch p.config.Overflow {
+	case OverflowDrop:
+		p.mu.RUnlock()
+		p.bus.wg.Done()
+		atomic.AddUint64(&p.dropped, 1)
+		return nil
+	case OverflowError:
+		p.mu.RUnlock()
+		p.bus.wg.Done()
+		atomic.AddUint64(&p.rejected, 1)
+		return ErrQueueFull
+	}
+	//等待时不持有锁 stop不需要等队列有空位 worker退出前会等待这里放入的任务
+	p.senders.Add(1)
+	p.mu.RUnlock()
+	defer p.senders.Done()
+	select {
+	case p.jobs <- job:
+		atomic.AddUint64(&p.submitted, 1)
+		return nil
+	case <-p.quit:
+		p.bus.wg.Done()
+		return ErrPoolClosed
+	case <-job.ctx.Done():
+		p.bus.wg.Done()
+		atomic.AddUint64(&p.rejected, 1)
+		return job.ctx.Err()
+	}
+}
+
+func (p *pool) work() {
+	for {
+		select {
+		case <-p.quit:
+			//执行完队列中剩下的任务后退出
+			p.senders.Wait()
+			for {
+				select {
+				case job := <-p.jobs:
+					p.run(job)
+				default:
+					return
+				}
+			}
+		case job := <-p.jobs:
+			p.run(job)
+		}
+	}
+}
+
+func (p *pool) run(job *poolJob) {
+	if job.handler.transactional {
+		//transactional的订阅函数交给订阅函数自己的队列串行执行 等待期间不占用worker
+		job.handler.serial.push(func() {
+			p.execute(job)
+		})
+		return
+	}
+	p.execute(job)
+}
+
+func (p *pool) execute(job *poolJob) {
+	defer p.bus.wg.Done()
+	atomic.AddInt64(&p.running, 1)
+	defer atomic.AddInt64(&p.running, -1)
+	if err := job.ctx.Err(); err != nil {
+		atomic.AddUint64(&p.failed, 1)
+		p.bus.reportError(&HandlerError{Topic: job.topic, Handler: job.handler.name, Err: err})
+		return
+	}
+	start := time.Now()
+	err := p.bus.doPublish(job.ctx, job.topic, job.handler, job.args...)
+	p.observe(time.Since(start))
+	if err != nil {
+		atomic.AddUint64(&p.failed, 1)
+		p.bus.reportError(err)
+		return
+	}
+	atomic.AddUint64(&p.completed, 1)
+}
+
+func (p *pool) observe(latency time.Duration) {
+	atomic.AddInt64(&p.totalLatency, int64(latency))
+	atomic.StoreInt64(&p.lastLatency, int64(latency))
+	for {
+		max := atomic.LoadInt64(&p.maxLatency)
+		if int64(latency) <= max || atomic.CompareAndSwapInt64(&p.maxLatency, max, int64(latency)) {
+			return
+		}
+	}
+}
+
+// stop 不再接收新任务 worker执行完队列中的任务后退出
+func (p *pool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.quit)
+}
+
+func (p *pool) stats() PoolStats {
+	stats := PoolStats{
+		Workers:     p.config.Workers,
+		QueueSize:   p.config.QueueSize,
+		QueueDepth:  len(p.jobs),
+		Running:     atomic.LoadInt64(&p.running),
+		Submitted:   atomic.LoadUint64(&p.submitted),
+		Completed:   atomic.LoadUint64(&p.completed),
+		Failed:      atomic.LoadUint64(&p.failed),
+		Dropped:     atomic.LoadUint64(&p.dropped),
+		Rejected:    atomic.LoadUint64(&p.rejected),
+		MaxLatency:  time.Duration(atomic.LoadInt64(&p.maxLatency)),
+		LastLatency: time.Duration(atomic.LoadInt64(&p.lastLatency)),
+	}
+	if done := stats.Completed + stats.Failed; done > 0 {
+		stats.AvgLatency = time.Duration(atomic.LoadInt64(&p.totalLatency) / int64(done))
+	}
+	return stats
+}
+
+// serialQueue 按放入顺序逐个执行 同一时间最多一个协程 队列为空时协程退出
+type serialQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+func (q *serialQueue) push(fn func()) {
+	q.mu.Lock()
+	q.pending = append(q.pending, fn)
+	if q.running {
+		q.mu.Unlock()
+		return
+	}
+	q.running = true
+	q.mu.Unlock()
+	go q.drain()
+}
+
+func (q *serialQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		fn := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		fn()
+	}
+}
+
+// pools 按topic和订阅函数配置的协程池
+type pools struct {
+	defaultPool *pool
+	topics      map[string]*pool
+	handlers    map[*eventHandler]*pool
+	sync.RWMutex
+}
+
+// SetDefaultPool 没有单独配置的异步订阅函数使用的协程池 不设置时每次推送启动一个协程
+func (bus *EventBus) SetDefaultPool(config PoolConfig) error {
+	p, err := newPool(bus, config)
+	if err != nil {
+		return err
+	}
+	bus.pools.Lock()
+	old := bus.pools.defaultPool
+	bus.pools.defaultPool = p
+	bus.pools.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	return nil
+}
+
+// SetTopicPool topic的异步订阅函数共用的协程池
+func (bus *EventBus) SetTopicPool(topic string, config PoolConfig) error {
+	p, err := newPool(bus, config)
+	if err != nil {
+		return err
+	}
+	bus.pools.Lock()
+	if bus.pools.topics == nil {
+		bus.pools.topics = make(map[string]*pool)
+	}
+	old := bus.pools.topics[topic]
+	bus.pools.topics[topic] = p
+	bus.pools.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	return nil
+}
+
+// SetPool 订阅函数单独使用的协程池
+func (s *Subscription) SetPool(config PoolConfig) error {
+	p, err := newPool(s.bus, config)
+	if err != nil {
+		return err
+	}
+	s.bus.pools.Lock()
+	if s.bus.pools.handlers == nil {
+		s.bus.pools.handlers = make(map[*eventHandler]*pool)
+	}
+	old := s.bus.pools.handlers[s.handler]
+	s.bus.pools.handlers[s.handler] = p
+	s.bus.pools.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	return nil
+}
+
+// poolOf 订阅函数使用的协程池 没有配置时返回nil
+func (bus *EventBus) poolOf(topic string, handler *eventHandler) *pool {
+	bus.pools.RLock()
+	defer bus.pools.RUnlock()
+	if p, ok := bus.pools.handlers[handler]; ok {
+		return p
+	}
+	if p, ok := bus.pools.topics[topic]; ok {
+		return p
+	}
+	return bus.pools.defaultPool
+}
+
+// releasePool 取消订阅时停止订阅函数单独使用的协程池
+func (bus *EventBus) releasePool(handler *eventHandler) {
+	bus.pools.Lock()
+	p, ok := bus.pools.handlers[handler]
+	delete(bus.pools.handlers, handler)
+	bus.pools.Unlock()
+	if ok {
+		p.stop()
+	}
+}
+
+// stopPools 停止所有协程池 队列中的任务执行完后worker退出
+func (bus *EventBus) stopPools() {
+	bus.pools.Lock()
+	stopping := make([]*pool, 0, len(bus.pools.topics)+len(bus.pools.handlers)+1)
+	if bus.pools.defaultPool != nil {
+		stopping = append(stopping, bus.pools.defaultPool)
+	}
+	for _, p := range bus.pools.topics {
+		stopping = append(stopping, p)
+	}
+	for _, p := range bus.pools.handlers {
+		stopping = append(stopping, p)
+	}
+	bus.pools.defaultPool = nil
+	bus.pools.topics = nil
+	bus.pools.handlers = nil
+	bus.pools.Unlock()
+	for i := range stopping[:] {
+		stopping[i].stop()
+	}
+}
+
+// PoolStats 协程池指标 key为default、topic:<topic>或handler:<订阅函数名称>
+func (bus *EventBus) PoolStats() map[string]PoolStats {
+	bus.pools.RLock()
+	defer bus.pools.RUnlock()
+	stats := make(map[string]PoolStats, len(bus.pools.topics)+len(bus.pools.handlers)+1)
+	if bus.pools.defaultPool != nil {
+		stats["default"] = bus.pools.defaultPool.stats()
+	}
+	for topic, p := range bus.pools.topics {
+		stats["topic:"+topic] = p.stats()
+	}
+	for handler, p := range bus.pools.handlers {
+		stats["handler:"+handler.name] = p.stats()
+	}
+	return stats
+}
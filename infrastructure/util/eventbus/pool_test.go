@@ -0,0 +1,228 @@
+package eventbus
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingPool 订阅函数阻塞到release关闭 返回时worker正在执行第一个事件 队列已满
+func blockingPool(t *testing.T, bus Bus) (release chan struct{}, handled *int32) {
+	t.Helper()
+	release = make(chan struct{})
+	handled = new(int32)
+	if err := bus.SetDefaultPool(PoolConfig{Workers: 1, QueueSize: 1, Overflow: OverflowBlock}); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{}, 1)
+	if _, err := SubscribeAsync(bus, "order.paid", func(event orderCreated) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		atomic.AddInt32(handled, 1)
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish("order.paid", orderCreated{Id: 1})
+	<-started
+	bus.Publish("order.paid", orderCreated{Id: 2})
+	return release, handled
+}
+
+// publishBlocked 在协程中推送 确认推送阻塞在队列上
+func publishBlocked(t *testing.T, bus Bus) chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- bus.PublishErr("order.paid", orderCreated{Id: 3})
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("publish should block while the queue is full, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	return done
+}
+
+// firstError 推送返回的第一个订阅函数错误
+func firstError(err error) error {
+	if errs, ok := err.(HandlerErrors); ok && len(errs) > 0 {
+		return errs[0]
+	}
+	return err
+}
+
+func within(t *testing.T, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s is blocked", what)
+	}
+}
+
+func TestReplacingPoolDoesNotWaitForBlockedPublish(t *testing.T) {
+	bus := New()
+	release, handled := blockingPool(t, bus)
+	done := publishBlocked(t, bus)
+
+	within(t, "SetDefaultPool", func() {
+		if err := bus.SetDefaultPool(PoolConfig{Workers: 1, QueueSize: 1}); err != nil {
+			t.Error(err)
+		}
+	})
+	//阻塞的推送放入新的协程池
+	var err error
+	within(t, "blocked publish", func() { err = <-done })
+	if err != nil {
+		t.Fatalf("expected the event to move to the new pool, got %v", err)
+	}
+	close(release)
+	within(t, "WaitAsync", bus.WaitAsync)
+	if n := atomic.LoadInt32(handled); n != 3 {
+		t.Fatalf("expected 3 events handled, got %d", n)
+	}
+}
+
+func TestStoppedPoolReportsClosedInsteadOfSpawning(t *testing.T) {
+	bus := New()
+	release, handled := blockingPool(t, bus)
+	done := publishBlocked(t, bus)
+
+	within(t, "Stop", bus.Stop)
+	var err error
+	within(t, "blocked publish", func() { err = <-done })
+	if !errors.Is(firstError(err), ErrPoolClosed) {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+	close(release)
+	within(t, "WaitAsync", bus.WaitAsync)
+	//队列中已有的事件执行完 被拒绝的事件不再执行
+	if n := atomic.LoadInt32(handled); n != 2 {
+		t.Fatalf("expected 2 events handled, got %d", n)
+	}
+}
+
+func TestPoolOverflowPolicies(t *testing.T) {
+	for _, c := range []struct {
+		overflow OverflowPolicy
+		err      error
+		dropped  uint64
+		rejected uint64
+	}{
+		{OverflowDrop, nil, 1, 0},
+		{OverflowError, ErrQueueFull, 0, 1},
+	} {
+		bus := New()
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		bus.SetDefaultPool(PoolConfig{Workers: 1, QueueSize: 1, Overflow: c.overflow})
+		SubscribeAsync(bus, "order.paid", func(event orderCreated) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+		}, false)
+		bus.Publish("order.paid", orderCreated{Id: 1})
+		<-started
+		bus.Publish("order.paid", orderCreated{Id: 2})
+
+		err := bus.PublishErr("order.paid", orderCreated{Id: 3})
+		if !errors.Is(firstError(err), c.err) {
+			t.Fatalf("overflow %d: expected %v, got %v", c.overflow, c.err, err)
+		}
+		close(release)
+		bus.WaitAsync()
+		stats := bus.PoolStats()["default"]
+		if stats.Submitted != 2 || stats.Completed != 2 || stats.Dropped != c.dropped || stats.Rejected != c.rejected {
+			t.Fatalf("overflow %d: unexpected stats %+v", c.overflow, stats)
+		}
+	}
+}
+
+func TestSlowTransactionalHandlerDoesNotBlockOtherTopics(t *testing.T) {
+	bus := New()
+	if err := bus.SetDefaultPool(PoolConfig{Workers: 2, QueueSize: 10, Overflow: OverflowError}); err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	var running, maxRunning int32
+	var order []uint64
+	if _, err := SubscribeAsync(bus, "order.slow", func(event orderCreated) {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		started <- struct{}{}
+		<-release
+		order = append(order, event.Id)
+		atomic.AddInt32(&running, -1)
+	}, true); err != nil {
+		t.Fatal(err)
+	}
+	fast := make(chan struct{}, 1)
+	if _, err := SubscribeAsync(bus, "order.fast", func(event orderCreated) {
+		fast <- struct{}{}
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	//每个worker都取到一个等待中的transactional任务
+	for i := uint64(1); i <= 4; i++ {
+		if err := bus.PublishErr("order.slow", orderCreated{Id: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bus.PublishErr("order.fast", orderCreated{Id: 5}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fast:
+	case <-time.After(time.Second):
+		t.Fatal("the slow transactional handler blocked another topic")
+	}
+	<-started
+	if stats := bus.PoolStats()["default"]; stats.Running != 1 {
+		t.Fatalf("only the running transactional job should be counted, got %+v", stats)
+	}
+	close(release)
+	within(t, "WaitAsync", bus.WaitAsync)
+	if maxRunning != 1 || len(order) != 4 {
+		t.Fatalf("transactional handler should run one at a time, max %d order %v", maxRunning, order)
+	}
+	if stats := bus.PoolStats()["default"]; stats.Completed != 5 {
+		t.Fatalf("expected 5 completed jobs, got %+v", stats)
+	}
+}
+
+func TestTransactionalHandlerWithoutPoolKeepsOrder(t *testing.T) {
+	bus := New()
+	release := make(chan struct{})
+	var order []uint64
+	if _, err := SubscribeAsync(bus, "order.slow", func(event orderCreated) {
+		<-release
+		order = append(order, event.Id)
+	}, true); err != nil {
+		t.Fatal(err)
+	}
+	//推送不等待上一个事件执行完
+	within(t, "Publish", func() {
+		for i := uint64(1); i <= 3; i++ {
+			bus.Publish("order.slow", orderCreated{Id: i})
+		}
+	})
+	close(release)
+	within(t, "WaitAsync", bus.WaitAsync)
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Fatalf("events should run in publish order, got %v", order)
+	}
+}
@@ -0,0 +1,129 @@
+package eventbus
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+// doSubscribe 处理订阅逻辑 topic可以使用通配符 *匹配一段 #匹配零段或多段
+// 写入时复制切片 推送中读到的切片不会被修改
+func (bus *EventBus) doSubscribe(topic string, handler *eventHandler) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if err := bus.checkTopicTypes(topic, handler); err != nil {
+		return err
+	}
+	handlers := bus.loadHandlers(topic)
+	next := make([]*eventHandler, len(handlers), len(handlers)+1)
+	copy(next, handlers)
+	bus.handlers.Store(topic, append(next, handler))
+	if isPattern(topic) {
+		bus.patterns.insert(topic)
+	}
+	return nil
+}
+
+// removeHandlerWhere 删除topic中第一个匹配的订阅函数
+func (bus *EventBus) removeHandlerWhere(topic string, match func(handler *eventHandler) bool) bool {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	handlers := bus.loadHandlers(topic)
+	idx := -1
+	for i := range handlers[:] {
+		if match(handlers[i]) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+	if len(handlers) == 1 {
+		bus.handlers.Delete(topic)
+		if isPattern(topic) {
+			bus.patterns.remove(topic)
+		}
+		return true
+	}
+	next := make([]*eventHandler, 0, len(handlers)-1)
+	next = append(next, handlers[:idx]...)
+	next = append(next, handlers[idx+1:]...)
+	bus.handlers.Store(topic, next)
+	return true
+}
+
+func (bus *EventBus) removeHandlerPtr(topic string, handler *eventHandler) {
+	bus.removeHandlerWhere(topic, func(h *eventHandler) bool {
+		return h == handler
+	})
+}
+
+// sameCallback 匹配反射订阅的同一个方法
+func sameCallback(callback reflect.Value) func(handler *eventHandler) bool {
+	return func(handler *eventHandler) bool {
+		return handler.typed == nil &&
+			handler.callBack.Type() == callback.Type() &&
+			handler.callBack.Pointer() == callback.Pointer()
+	}
+}
+
+func (bus *EventBus) loadHandlers(topic string) []*eventHandler {
+	handlerInterface, ok := bus.handlers.Load(topic)
+	if !ok {
+		return nil
+	}
+	return handlerInterface.([]*eventHandler)
+}
+
+// topicHandler 推送时匹配到的订阅函数 key为订阅时使用的topic
+type topicHandler struct {
+	key     string
+	handler *eventHandler
+}
+
+// matchHandlers topic的订阅函数 先返回完全相同的topic 再按字符串顺序返回通配符订阅
+func (bus *EventBus) matchHandlers(topic string) []topicHandler {
+	var matched []topicHandler
+	keys := append([]string{topic}, bus.patterns.match(topic)...)
+	for i := range keys[:] {
+		handlers := bus.loadHandlers(keys[i])
+		for j := range handlers[:] {
+			if handlers[j].flagOnce && atomic.LoadInt32(&handlers[j].fired) != 0 {
+				continue
+			}
+			matched = append(matched, topicHandler{key: keys[i], handler: handlers[j]})
+		}
+	}
+	return matched
+}
+
+// checkTopicTypes 和所有能匹配到同一个topic的订阅比较类型 包括通配符订阅
+// 例如order.*的订阅和order.created的订阅会收到同一个事件
+func (bus *EventBus) checkTopicTypes(topic string, handler *eventHandler) error {
+	var err error
+	bus.handlers.Range(func(key, value interface{}) bool {
+		other := key.(string)
+		if !topicsOverlap(topic, other) {
+			return true
+		}
+		err = checkTopicType(other, value.([]*eventHandler), handler)
+		return err == nil
+	})
+	return err
+}
+
+// checkTopicType topic中已有类型安全的订阅时 新的订阅函数参数类型需要一致
+func checkTopicType(topic string, handlers []*eventHandler, handler *eventHandler) error {
+	for i := range handlers[:] {
+		if handler.typed == nil && handlers[i].typed == nil {
+			continue
+		}
+		existing, ok := handlers[i].argType()
+		t, ok2 := handler.argType()
+		if !ok || !ok2 || existing != t {
+			return fmt.Errorf("topic %s 的事件类型为%v 不能订阅%v", topic, existing, t)
+		}
+	}
+	return nil
+}
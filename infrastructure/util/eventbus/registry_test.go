@@ -0,0 +1,163 @@
+package eventbus
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var onceCalls int32
+
+type onceObserver struct {
+	Fn string `subscribe:"Handle" topic:"order.paid"`
+}
+
+func (o onceObserver) Handle(event orderCreated) {
+	atomic.AddInt32(&onceCalls, 1)
+}
+
+func TestSubscribeOnceFiresOnceUnderConcurrency(t *testing.T) {
+	for round := 0; round < 50; round++ {
+		bus := New()
+		atomic.StoreInt32(&onceCalls, 0)
+		if err := bus.SubscribeOnce(onceObserver{}); err != nil {
+			t.Fatal(err)
+		}
+		var typedCalls int32
+		if _, err := SubscribeOnce(bus, "order.paid", func(event orderCreated) {
+			atomic.AddInt32(&typedCalls, 1)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bus.Publish("order.paid", orderCreated{Id: 1})
+			}()
+		}
+		wg.Wait()
+		if n := atomic.LoadInt32(&onceCalls); n != 1 {
+			t.Fatalf("round %d: reflection once handler called %d times", round, n)
+		}
+		if n := atomic.LoadInt32(&typedCalls); n != 1 {
+			t.Fatalf("round %d: typed once handler called %d times", round, n)
+		}
+		if bus.HasCallback("order.paid") {
+			t.Fatalf("round %d: once handlers still registered", round)
+		}
+	}
+}
+
+func TestConcurrentSubscribeKeepsEveryHandler(t *testing.T) {
+	bus := New()
+	const subscribers = 64
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Subscribe(bus, "order.created", func(event orderCreated) {
+				atomic.AddInt32(&calls, 1)
+			}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	bus.Publish("order.created", orderCreated{})
+	if n := atomic.LoadInt32(&calls); n != subscribers {
+		t.Fatalf("calls = %d, want %d", n, subscribers)
+	}
+}
+
+func TestConcurrentSubscribePublishUnsubscribe(t *testing.T) {
+	bus := New()
+	var stable int32
+	if _, err := Subscribe(bus, "order.created", func(event orderCreated) {
+		atomic.AddInt32(&stable, 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	const publishers, publishes = 8, 200
+	stop := make(chan struct{})
+	var churn sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		churn.Add(1)
+		go func(i int) {
+			defer churn.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				sub, err := Subscribe(bus, "order.created", func(event orderCreated) {})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				pattern, err := Subscribe(bus, fmt.Sprintf("order.*.%d", i), func(event orderCreated) {})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				sub.Unsubscribe()
+				pattern.Unsubscribe()
+			}
+		}(i)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < publishes; j++ {
+				bus.Publish("order.created", orderCreated{})
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	churn.Wait()
+	if n := atomic.LoadInt32(&stable); n != publishers*publishes {
+		t.Fatalf("stable handler called %d times, want %d", n, publishers*publishes)
+	}
+}
+
+func TestConcurrentUnsubscribeRemovesOnlyItsHandler(t *testing.T) {
+	bus := New()
+	subs := make([]*Subscription, 32)
+	for i := range subs {
+		sub, err := Subscribe(bus, "order.created", func(event orderCreated) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs[i] = sub
+	}
+	var kept int32
+	if _, err := Subscribe(bus, "order.created", func(event orderCreated) {
+		atomic.AddInt32(&kept, 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range subs {
+		wg.Add(1)
+		go func(sub *Subscription) {
+			defer wg.Done()
+			sub.Unsubscribe()
+		}(subs[i])
+	}
+	wg.Wait()
+	bus.Publish("order.created", orderCreated{})
+	if n := atomic.LoadInt32(&kept); n != 1 {
+		t.Fatalf("kept handler called %d times, want 1", n)
+	}
+	if n := len(bus.(*EventBus).loadHandlers("order.created")); n != 1 {
+		t.Fatalf("%d handlers left, want 1", n)
+	}
+}
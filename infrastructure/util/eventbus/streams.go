@@ -1,14 +1,18 @@
 package eventbus
 
 import (
-	"DDD/infrastructure/config"
+	"DDD/infrastructure/config/config"
 	"DDD/infrastructure/util/redis"
 
 	"go.uber.org/zap"
+
+	"time"
 )
 
 const (
-	streamsMaxLen uint64 = 1000000 //streams 最大长度
+	streamsMaxLen        uint64 = 1000000 //streams 最大长度
+	streamsDelayedSuffix        = ":delayed"
+	streamsEnvelopeField        = "envelope" //序列化后的Envelope
 )
 
 type streams struct {
@@ -21,20 +25,31 @@ func (s *streams) Publish(event *mqBusEvent) error {
 			Value: event.id,
 		},
 		redis.StreamValue{
-			Field: "datetime",
-			Value: event.datetime,
+			Field: "type",
+			Value: event.envelope.Type,
 		},
 		redis.StreamValue{
-			Field: "data",
-			Value: event.data,
+			Field: streamsEnvelopeField,
+			Value: string(event.data),
 		},
 	}
-	client := redis.NewClient(redis.Pool.Get())
+	client := redis.NewClient(redis.Pool.Pool.Get())
 	defer client.Close()
 	config.Logger.Info("print-srv:event-bus",
 		zap.Any("source", event.source),
-		zap.Any("data", data),
+		zap.String("id", event.id),
+		zap.String("type", event.envelope.Type),
+		zap.Duration("delay", event.delay),
 	)
+	if event.delay > 0 {
+		//延迟消息先写入有序集合 到期后由消费者写入streams
+		deliverAt := time.Now().Add(event.delay).UnixNano() / int64(time.Millisecond)
+		if err := client.XAddDelayed(event.source+streamsDelayedSuffix, deliverAt, data...); err != nil {
+			config.Logger.Error("Error", zap.Error(err))
+			return err
+		}
+		return nil
+	}
 	if err := client.XAdd(event.source, "*", streamsMaxLen, data...); err != nil {
 		config.Logger.Error("Error", zap.Error(err))
 		return err
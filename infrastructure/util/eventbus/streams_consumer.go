@@ -0,0 +1,319 @@
+package eventbus
+
+import (
+	"DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/redis"
+
+	"go.uber.org/zap"
+
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	streamsReadCount       int64 = 10               //每次读取的条数
+	streamsReadBlock       int64 = 2000             //阻塞读取的超时时间 毫秒
+	streamsRetryInterval         = 5 * time.Second  //读取失败后的重试间隔
+	streamsClaimIdle             = 5 * time.Minute  //pending消息空闲超过该时间会被重新投递
+	streamsClaimInterval         = 30 * time.Second //检查pending消息的间隔
+	streamsMaxAttempts     int64 = 5                //最大投递次数 超过后转入死信队列
+	streamsPromoteCount    int64 = 100              //每次写入streams的到期延迟消息条数
+	streamsPromoteInterval       = time.Second      //检查到期延迟消息的间隔
+)
+
+// StreamsHandler 处理streams消息 返回nil时消息会被确认 否则留在pending列表等待重新投递
+// 投递次数达到MaxAttempts仍然失败的消息会转入`<stream>:dlq` 重放的死信从RetryStream读取
+type StreamsHandler func(message redis.StreamMessage) error
+
+// StreamsConsumer streams消费者组的消费者
+type StreamsConsumer struct {
+	Stream        string
+	Group         string
+	Consumer      string
+	Count         int64
+	Block         int64
+	ClaimIdle     time.Duration
+	ClaimInterval time.Duration
+	MaxAttempts   int64
+	handler       StreamsHandler
+	quit          chan struct{}
+	wg            sync.WaitGroup
+	once          sync.Once
+}
+
+type streamsConsumers struct {
+	consumers []*StreamsConsumer
+	sync.Mutex
+}
+
+var runningConsumers = new(streamsConsumers)
+
+// NewStreamsConsumer new
+func NewStreamsConsumer(stream, group, consumer string) *StreamsConsumer {
+	return &StreamsConsumer{
+		Stream:        stream,
+		Group:         group,
+		Consumer:      consumer,
+		Count:         streamsReadCount,
+		Block:         streamsReadBlock,
+		ClaimIdle:     streamsClaimIdle,
+		ClaimInterval: streamsClaimInterval,
+		MaxAttempts:   streamsMaxAttempts,
+		quit:          make(chan struct{}),
+	}
+}
+
+// Handle 注册处理函数
+func (c *StreamsConsumer) Handle(handler StreamsHandler) *StreamsConsumer {
+	c.handler = handler
+	return c
+}
+
+// HandleEnvelope 注册处理函数 消息会被解析成信封和注册的事件类型
+func (c *StreamsConsumer) HandleEnvelope(handler EnvelopeHandler) *StreamsConsumer {
+	return c.Handle(func(message redis.StreamMessage) error {
+		envelope, err := DecodeStreamsMessage(message)
+		if err != nil {
+			return err
+		}
+		return handleEnvelope(envelope, handler)
+	})
+}
+
+// Start 创建消费者组并开始消费 同时启动pending消息的回收和到期延迟消息的投递
+func (c *StreamsConsumer) Start() error {
+	if c.handler == nil {
+		return errors.New("streams消费者没有注册处理函数")
+	}
+	if err := c.createGroup(); err != nil {
+		return err
+	}
+	runningConsumers.Lock()
+	runningConsumers.consumers = append(runningConsumers.consumers, c)
+	runningConsumers.Unlock()
+	c.wg.Add(3)
+	go c.run()
+	go c.reclaim()
+	go c.promote()
+	return nil
+}
+
+// Stop 停止消费 等待正在处理的消息完成
+func (c *StreamsConsumer) Stop() {
+	c.once.Do(func() {
+		close(c.quit)
+	})
+	c.wg.Wait()
+}
+
+// streams 消费的streams 原streams和消费者组的重放streams
+func (c *StreamsConsumer) streams() []string {
+	return []string{c.Stream, RetryStream(c.Stream, c.Group)}
+}
+
+// createGroup 消费者组不存在时创建 已存在忽略
+func (c *StreamsConsumer) createGroup() error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	for _, stream := range c.streams() {
+		err := client.XGroup("CREATE", stream, c.Group, "0", "MKSTREAM")
+		if err != nil && !redis.IsBusyGroup(err) {
+			config.Logger.Error("Error", zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *StreamsConsumer) run() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.quit:
+			return
+		default:
+		}
+		if err := c.consume(); err != nil {
+			c.logError(err)
+			if !c.sleep(streamsRetryInterval) {
+				return
+			}
+		}
+	}
+}
+
+func (c *StreamsConsumer) consume() error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	messages, err := client.XReadGroup(c.Group, c.Consumer, ">", c.streams(), c.Count, c.Block)
+	if err != nil {
+		return err
+	}
+	//新消息都是第一次投递
+	attempts := make(map[string]int64, len(messages))
+	for i := range messages[:] {
+		attempts[messages[i].Id] = 1
+	}
+	return c.dispatch(client, messages, attempts)
+}
+
+// reclaim 定时把空闲超过ClaimIdle的pending消息转移给当前消费者并重新处理
+func (c *StreamsConsumer) reclaim() {
+	defer c.wg.Done()
+	for c.sleep(c.ClaimInterval) {
+		if err := c.claim(); err != nil {
+			c.logError(err)
+		}
+	}
+}
+
+func (c *StreamsConsumer) claim() error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	for _, stream := range c.streams() {
+		if err := c.claimStream(client, stream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimStream 按id翻页读取空闲超过ClaimIdle的pending消息 每页Count条
+func (c *StreamsConsumer) claimStream(client *redis.Client, stream string) error {
+	minIdle := int64(c.ClaimIdle / time.Millisecond)
+	start := "-"
+	for {
+		pending, err := client.XPendingIdle(stream, c.Group, minIdle, start, "+", c.Count)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		ids := make([]string, 0, len(pending))
+		//XCLAIM会让投递次数加1
+		attempts := make(map[string]int64, len(pending))
+		for i := range pending[:] {
+			ids = append(ids, pending[i].Id)
+			attempts[pending[i].Id] = pending[i].Count + 1
+		}
+		messages, err := client.XClaim(stream, c.Group, c.Consumer, minIdle, ids...)
+		if err != nil {
+			return err
+		}
+		if err := c.dispatch(client, messages, attempts); err != nil {
+			return err
+		}
+		if int64(len(pending)) < c.Count {
+			return nil
+		}
+		select {
+		case <-c.quit:
+			return nil
+		default:
+		}
+		start = "(" + pending[len(pending)-1].Id
+	}
+}
+
+// promote 定时把到期的延迟消息写入streams 多个消费者同时执行也不会重复写入
+func (c *StreamsConsumer) promote() {
+	defer c.wg.Done()
+	for c.sleep(streamsPromoteInterval) {
+		if err := c.promoteDue(); err != nil {
+			c.logError(err)
+		}
+	}
+}
+
+func (c *StreamsConsumer) promoteDue() error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for {
+		n, err := client.XPromote(c.Stream+streamsDelayedSuffix, c.Stream, now, streamsMaxLen, streamsPromoteCount)
+		if err != nil {
+			return err
+		}
+		if int64(n) < streamsPromoteCount {
+			return nil
+		}
+	}
+}
+
+// dispatch 逐条处理 成功后确认 失败且投递次数达到上限的转入死信队列
+func (c *StreamsConsumer) dispatch(client *redis.Client, messages []redis.StreamMessage, attempts map[string]int64) error {
+	for i := range messages[:] {
+		if messages[i].Deleted {
+			//已经从streams删除的消息无法处理 确认后移出pending列表
+			config.Logger.Warn("print-srv:event-bus",
+				zap.String("stream", messages[i].Stream),
+				zap.String("id", messages[i].Id),
+				zap.String("skip", "deleted"),
+			)
+			if err := client.XAck(messages[i].Stream, c.Group, messages[i].Id); err != nil {
+				return err
+			}
+			continue
+		}
+		n := attempts[messages[i].Id]
+		var err error
+		if n > c.MaxAttempts {
+			//已经超过上限的消息不再处理 例如处理时消费者崩溃
+			err = fmt.Errorf("投递次数%d超过上限%d", n-1, c.MaxAttempts)
+		} else {
+			err = c.handler(messages[i])
+		}
+		if err != nil {
+			config.Logger.Error("print-srv:event-bus",
+				zap.String("stream", messages[i].Stream),
+				zap.String("id", messages[i].Id),
+				zap.Int64("attempts", n),
+				zap.Any("values", messages[i].Values),
+				zap.Error(err),
+			)
+			if n < c.MaxAttempts {
+				continue
+			}
+			if err := NewDeadLetter(c.Stream).add(client, messages[i], c.Group, n, err); err != nil {
+				return err
+			}
+		}
+		if err := client.XAck(messages[i].Stream, c.Group, messages[i].Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sleep 等待d 期间收到停止信号返回false
+func (c *StreamsConsumer) sleep(d time.Duration) bool {
+	select {
+	case <-c.quit:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (c *StreamsConsumer) logError(err error) {
+	config.Logger.Error("print-srv:event-bus",
+		zap.String("stream", c.Stream),
+		zap.String("group", c.Group),
+		zap.String("consumer", c.Consumer),
+		zap.Error(err),
+	)
+}
+
+// StopStreamsConsumers 停止所有已启动的消费者 用于服务退出
+func StopStreamsConsumers() {
+	runningConsumers.Lock()
+	consumers := runningConsumers.consumers
+	runningConsumers.consumers = nil
+	runningConsumers.Unlock()
+	for i := range consumers[:] {
+		consumers[i].Stop()
+	}
+}
@@ -0,0 +1,94 @@
+package eventbus
+
+import (
+	"DDD/infrastructure/util/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/spf13/viper"
+
+	"testing"
+	"time"
+)
+
+// useRedis 启动miniredis并通过redis.Init连接
+func useRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	viper.Set("redis.addr", server.Addr())
+	t.Cleanup(func() {
+		viper.Set("redis.addr", nil)
+		if redis.Pool != nil {
+			redis.Pool.Close()
+			redis.Pool = nil
+		}
+	})
+	if err := redis.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func redisClient(t *testing.T) *redis.Client {
+	t.Helper()
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	t.Cleanup(client.Close)
+	return client
+}
+
+func startConsumer(t *testing.T, consumer *StreamsConsumer) {
+	t.Helper()
+	consumer.Block = 20
+	if err := consumer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(consumer.Stop)
+}
+
+func TestStreamsConsumerHandlesAndAcks(t *testing.T) {
+	useRedis(t)
+	received := make(chan userRenamed, 1)
+	consumer := NewStreamsConsumer("user_stream", "profile", "c1").HandleEnvelope(func(envelope *Envelope, event interface{}) error {
+		received <- *event.(*userRenamed)
+		return nil
+	})
+	startConsumer(t, consumer)
+
+	if err := NewMqBus().PublishEvent(EventStreams, "user_stream", userRenamed{Id: 1, Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-received:
+		if event != (userRenamed{Id: 1, Name: "alice"}) {
+			t.Fatalf("received %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the consumer did not receive the event")
+	}
+	consumer.Stop()
+	pending, err := redisClient(t).XPending("user_stream", "profile", "-", "+", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("handled message should be acked, pending %+v", pending)
+	}
+}
+
+func TestStreamsConsumerStartRequiresHandler(t *testing.T) {
+	if err := NewStreamsConsumer("user_stream", "profile", "c1").Start(); err == nil {
+		t.Fatal("expected an error without a handler")
+	}
+}
+
+func TestStreamsConsumerStartCreatesGroupOnce(t *testing.T) {
+	server := useRedis(t)
+	for i := 0; i < 2; i++ {
+		consumer := NewStreamsConsumer("user_stream", "profile", "c1").Handle(func(message redis.StreamMessage) error {
+			return nil
+		})
+		startConsumer(t, consumer)
+	}
+	if !server.Exists("user_stream") {
+		t.Fatal("the stream should be created with the group")
+	}
+}
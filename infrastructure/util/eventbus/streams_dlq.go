@@ -0,0 +1,146 @@
+package eventbus
+
+import (
+	"DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/redis"
+
+	"go.uber.org/zap"
+
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	deadLetterSuffix   = ":dlq"
+	retrySuffix        = ":retry:"
+	deadLetterPrefix   = "dlq_"         //死信附加字段的前缀 重放时去掉
+	deadLetterError    = "dlq_error"    //最后一次处理的错误
+	deadLetterId       = "dlq_id"       //原消息id
+	deadLetterGroup    = "dlq_group"    //处理失败的消费者组
+	deadLetterAttempts = "dlq_attempts" //投递次数
+)
+
+// RetryStream 消费者组的重放streams 只有该组的消费者读取
+func RetryStream(stream, group string) string {
+	return stream + retrySuffix + group
+}
+
+// DeadLetter streams的死信队列 key为`<stream>:dlq`
+type DeadLetter struct {
+	Stream string
+}
+
+// NewDeadLetter new
+func NewDeadLetter(stream string) *DeadLetter {
+	return &DeadLetter{
+		Stream: stream,
+	}
+}
+
+// Key 死信队列的streams名称
+func (d *DeadLetter) Key() string {
+	return d.Stream + deadLetterSuffix
+}
+
+// List 查看死信 start end为id范围 -和+表示全部 count为0不限制
+func (d *DeadLetter) List(start, end string, count int64) ([]redis.StreamMessage, error) {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	return client.XRange(d.Key(), start, end, count)
+}
+
+// Replay 把死信写入处理失败的消费者组的重放streams并从死信队列删除 不传id重放全部
+// 其它消费者组已经处理过该消息 不会再收到
+func (d *DeadLetter) Replay(id ...string) error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	messages, err := d.find(client, id...)
+	if err != nil {
+		return err
+	}
+	for i := range messages[:] {
+		group := messages[i].Values[deadLetterGroup]
+		if group == "" {
+			return fmt.Errorf("死信%s没有记录消费者组", messages[i].Id)
+		}
+		data := make([]redis.StreamValue, 0, len(messages[i].Values))
+		for field, value := range messages[i].Values {
+			if strings.HasPrefix(field, deadLetterPrefix) {
+				continue
+			}
+			data = append(data, redis.StreamValue{
+				Field: field,
+				Value: value,
+			})
+		}
+		if err := client.XAdd(RetryStream(d.Stream, group), "*", streamsMaxLen, data...); err != nil {
+			return err
+		}
+		if err := client.XDel(d.Key(), messages[i].Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge 删除死信 不传id清空死信队列
+func (d *DeadLetter) Purge(id ...string) error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	if len(id) == 0 {
+		return client.XTrim(d.Key(), 0)
+	}
+	return client.XDel(d.Key(), id...)
+}
+
+func (d *DeadLetter) find(client *redis.Client, id ...string) ([]redis.StreamMessage, error) {
+	if len(id) == 0 {
+		return client.XRange(d.Key(), "-", "+", 0)
+	}
+	messages := make([]redis.StreamMessage, 0, len(id))
+	for i := range id[:] {
+		m, err := client.XRange(d.Key(), id[i], id[i], 1)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, m...)
+	}
+	return messages, nil
+}
+
+// add 写入死信 附带错误信息和投递次数
+func (d *DeadLetter) add(client *redis.Client, message redis.StreamMessage, group string, attempts int64, cause error) error {
+	data := make([]redis.StreamValue, 0, len(message.Values)+4)
+	for field, value := range message.Values {
+		data = append(data, redis.StreamValue{
+			Field: field,
+			Value: value,
+		})
+	}
+	data = append(data,
+		redis.StreamValue{
+			Field: deadLetterId,
+			Value: message.Id,
+		},
+		redis.StreamValue{
+			Field: deadLetterGroup,
+			Value: group,
+		},
+		redis.StreamValue{
+			Field: deadLetterAttempts,
+			Value: strconv.FormatInt(attempts, 10),
+		},
+		redis.StreamValue{
+			Field: deadLetterError,
+			Value: cause.Error(),
+		},
+	)
+	config.Logger.Warn("print-srv:event-bus",
+		zap.String("dlq", d.Key()),
+		zap.String("id", message.Id),
+		zap.Int64("attempts", attempts),
+		zap.Error(cause),
+	)
+	return client.XAdd(d.Key(), "*", streamsMaxLen, data...)
+}
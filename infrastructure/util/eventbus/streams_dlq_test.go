@@ -0,0 +1,124 @@
+package eventbus
+
+import (
+	"DDD/infrastructure/util/redis"
+
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// eventually 等待cond成立
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeadLetterReplaysOnlyToFailedGroup(t *testing.T) {
+	useRedis(t)
+	var failing int32 = 1
+	var billed, shipped int32
+	billing := NewStreamsConsumer("order_stream", "billing", "c1").Handle(func(message redis.StreamMessage) error {
+		if atomic.LoadInt32(&failing) == 1 {
+			return errors.New("billing down")
+		}
+		atomic.AddInt32(&billed, 1)
+		return nil
+	})
+	billing.MaxAttempts = 1
+	shipping := NewStreamsConsumer("order_stream", "shipping", "c1").Handle(func(message redis.StreamMessage) error {
+		atomic.AddInt32(&shipped, 1)
+		return nil
+	})
+	startConsumer(t, billing)
+	startConsumer(t, shipping)
+
+	if err := NewMqBus().PublishEvent(EventStreams, "order_stream", userRenamed{Id: 1, Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	dlq := NewDeadLetter("order_stream")
+	var letters []redis.StreamMessage
+	eventually(t, "the dead letter", func() bool {
+		letters, _ = dlq.List("-", "+", 0)
+		return len(letters) == 1
+	})
+	letter := letters[0].Values
+	if letter[deadLetterGroup] != "billing" || letter[deadLetterAttempts] != "1" || letter[deadLetterError] != "billing down" {
+		t.Fatalf("unexpected dead letter %v", letter)
+	}
+	eventually(t, "shipping", func() bool { return atomic.LoadInt32(&shipped) == 1 })
+
+	atomic.StoreInt32(&failing, 0)
+	if err := dlq.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the replayed message", func() bool { return atomic.LoadInt32(&billed) == 1 })
+	//等待一轮读取 确认shipping没有再次收到
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&shipped); n != 1 {
+		t.Fatalf("the replay should only reach the failed group, shipping handled %d", n)
+	}
+	if letters, _ := dlq.List("-", "+", 0); len(letters) != 0 {
+		t.Fatalf("replayed letters should be removed, got %v", letters)
+	}
+	replayed, err := redisClient(t).XRange(RetryStream("order_stream", "billing"), "-", "+", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field := range replayed[0].Values {
+		if field == deadLetterGroup || field == deadLetterError {
+			t.Fatalf("dead letter fields should be removed on replay, got %v", replayed[0].Values)
+		}
+	}
+	if pending, _ := redisClient(t).XPending(RetryStream("order_stream", "billing"), "billing", "-", "+", 10); len(pending) != 0 {
+		t.Fatalf("the replayed message should be acked, pending %+v", pending)
+	}
+}
+
+func TestDeadLetterReplayRequiresGroup(t *testing.T) {
+	useRedis(t)
+	client := redisClient(t)
+	if err := client.XAdd("order_stream:dlq", "*", streamsMaxLen, redis.StreamValue{Field: "data", Value: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDeadLetter("order_stream").Replay(); err == nil {
+		t.Fatal("a dead letter without a group should not be replayed")
+	}
+	if messages, _ := client.XRange("order_stream", "-", "+", 0); len(messages) != 0 {
+		t.Fatalf("nothing should be written to the source stream, got %v", messages)
+	}
+}
+
+func TestDeadLetterPurge(t *testing.T) {
+	useRedis(t)
+	client := redisClient(t)
+	dlq := NewDeadLetter("order_stream")
+	for i := 0; i < 3; i++ {
+		if err := client.XAdd(dlq.Key(), "*", streamsMaxLen, redis.StreamValue{Field: deadLetterGroup, Value: "billing"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	letters, err := dlq.List("-", "+", 0)
+	if err != nil || len(letters) != 3 {
+		t.Fatalf("expected 3 letters, got %d %v", len(letters), err)
+	}
+	if err := dlq.Purge(letters[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if letters, _ := dlq.List("-", "+", 0); len(letters) != 2 {
+		t.Fatalf("expected 2 letters after purging one, got %d", len(letters))
+	}
+	if err := dlq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if letters, _ := dlq.List("-", "+", 0); len(letters) != 0 {
+		t.Fatalf("expected no letters after purging all, got %d", len(letters))
+	}
+}
@@ -0,0 +1,153 @@
+package eventbus
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	topicSeparator  = "."
+	topicWildcard   = "*" //匹配一段 例如order.*.created
+	topicMultiLevel = "#" //匹配零段或多段 例如order.#
+)
+
+// isPattern topic中是否有通配符
+func isPattern(topic string) bool {
+	for _, segment := range strings.Split(topic, topicSeparator) {
+		if segment == topicWildcard || segment == topicMultiLevel {
+			return true
+		}
+	}
+	return false
+}
+
+// topicsOverlap 两个topic是否能匹配到同一个topic 都可以有通配符
+func topicsOverlap(a, b string) bool {
+	x, y := strings.Split(a, topicSeparator), strings.Split(b, topicSeparator)
+	seen := make(map[[2]int]bool)
+	var overlap func(i, j int) bool
+	overlap = func(i, j int) bool {
+		key := [2]int{i, j}
+		if result, ok := seen[key]; ok {
+			return result
+		}
+		var result bool
+		switch {
+		case i < len(x) && x[i] == topicMultiLevel:
+			//#匹配零段或者吃掉另一边的一段
+			result = overlap(i+1, j) || j < len(y) && overlap(i, j+1)
+		case j < len(y) && y[j] == topicMultiLevel:
+			result = overlap(i, j+1) || i < len(x) && overlap(i+1, j)
+		case i == len(x) || j == len(y):
+			result = i == len(x) && j == len(y)
+		default:
+			result = (x[i] == y[j] || x[i] == topicWildcard || y[j] == topicWildcard) && overlap(i+1, j+1)
+		}
+		seen[key] = result
+		return result
+	}
+	return overlap(0, 0)
+}
+
+// topicTrie 按topic分段保存通配符订阅 推送时只遍历能匹配的分支
+type topicTrie struct {
+	root *trieNode
+	sync.RWMutex
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	pattern  string //在该节点结束的订阅
+}
+
+func newTopicTrie() *topicTrie {
+	return &topicTrie{
+		root: new(trieNode),
+	}
+}
+
+func (t *topicTrie) insert(pattern string) {
+	t.Lock()
+	defer t.Unlock()
+	node := t.root
+	for _, segment := range strings.Split(pattern, topicSeparator) {
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+		child, ok := node.children[segment]
+		if !ok {
+			child = new(trieNode)
+			node.children[segment] = child
+		}
+		node = child
+	}
+	node.pattern = pattern
+}
+
+func (t *topicTrie) remove(pattern string) {
+	t.Lock()
+	defer t.Unlock()
+	segments := strings.Split(pattern, topicSeparator)
+	path := make([]*trieNode, 0, len(segments)+1)
+	node := t.root
+	path = append(path, node)
+	for _, segment := range segments {
+		child, ok := node.children[segment]
+		if !ok {
+			return
+		}
+		node = child
+		path = append(path, node)
+	}
+	node.pattern = ""
+	//删除不再使用的节点
+	for i := len(segments) - 1; i >= 0; i-- {
+		child := path[i+1]
+		if child.pattern != "" || len(child.children) > 0 {
+			return
+		}
+		delete(path[i].children, segments[i])
+	}
+}
+
+// match 返回能匹配topic的订阅 按字符串排序
+func (t *topicTrie) match(topic string) []string {
+	t.RLock()
+	defer t.RUnlock()
+	if len(t.root.children) == 0 {
+		return nil
+	}
+	found := make(map[string]struct{})
+	t.root.match(strings.Split(topic, topicSeparator), found)
+	if len(found) == 0 {
+		return nil
+	}
+	patterns := make([]string, 0, len(found))
+	for pattern := range found {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+func (node *trieNode) match(segments []string, found map[string]struct{}) {
+	if child, ok := node.children[topicMultiLevel]; ok {
+		//#匹配剩下的零段到全部
+		for i := 0; i <= len(segments); i++ {
+			child.match(segments[i:], found)
+		}
+	}
+	if len(segments) == 0 {
+		if node.pattern != "" {
+			found[node.pattern] = struct{}{}
+		}
+		return
+	}
+	if child, ok := node.children[segments[0]]; ok {
+		child.match(segments[1:], found)
+	}
+	if child, ok := node.children[topicWildcard]; ok {
+		child.match(segments[1:], found)
+	}
+}
@@ -0,0 +1,104 @@
+package eventbus
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopicTrieMatch(t *testing.T) {
+	trie := newTopicTrie()
+	for _, pattern := range []string{"order.*", "order.*.created", "order.#", "#", "*.paid", "order.#.refunded"} {
+		trie.insert(pattern)
+	}
+	cases := []struct {
+		topic string
+		want  []string
+	}{
+		{"order.created", []string{"#", "order.#", "order.*"}},
+		{"order.paid", []string{"#", "*.paid", "order.#", "order.*"}},
+		{"order.eu.created", []string{"#", "order.#", "order.*.created"}},
+		{"order", []string{"#", "order.#"}},
+		{"order.refunded", []string{"#", "order.#", "order.#.refunded", "order.*"}},
+		{"order.eu.de.refunded", []string{"#", "order.#", "order.#.refunded"}},
+		{"user.created", []string{"#"}},
+		{"user.paid", []string{"#", "*.paid"}},
+	}
+	for _, c := range cases {
+		if got := trie.match(c.topic); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("match(%q) = %v, want %v", c.topic, got, c.want)
+		}
+	}
+
+	trie.remove("#")
+	trie.remove("order.#")
+	trie.remove("order.#.refunded")
+	//删除不存在的订阅不影响其他订阅
+	trie.remove("order.*.paid")
+	if got := trie.match("order.eu.created"); !reflect.DeepEqual(got, []string{"order.*.created"}) {
+		t.Fatalf("after remove got %v", got)
+	}
+	if got := trie.match("order"); got != nil {
+		t.Fatalf("after remove got %v", got)
+	}
+	if _, ok := trie.root.children["order"].children["#"]; ok {
+		t.Fatal("unused nodes should be removed")
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	cases := map[string]bool{
+		"order.created":  false,
+		"order.*":        true,
+		"order.#":        true,
+		"#":              true,
+		"order.c*":       false,
+		"order.created#": false,
+	}
+	for topic, want := range cases {
+		if got := isPattern(topic); got != want {
+			t.Fatalf("isPattern(%q) = %v, want %v", topic, got, want)
+		}
+	}
+}
+
+func TestPublishDeliversToWildcardSubscriptions(t *testing.T) {
+	bus := New()
+	var received []string
+	subscribe := func(topic string) *Subscription {
+		t.Helper()
+		subscription, err := Subscribe(bus, topic, func(event orderCreated) {
+			received = append(received, topic)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return subscription
+	}
+	subscribe("order.created")
+	single := subscribe("order.*")
+	multi := subscribe("order.#")
+	subscribe("user.*")
+
+	//先执行完全相同的topic 再按字符串顺序执行通配符订阅
+	bus.Publish("order.created", orderCreated{Id: 1})
+	if got := strings.Join(received, ","); got != "order.created,order.#,order.*" {
+		t.Fatalf("received %s", got)
+	}
+	received = nil
+	bus.Publish("order.eu.created", orderCreated{Id: 2})
+	if got := strings.Join(received, ","); got != "order.#" {
+		t.Fatalf("* should match one segment only, received %s", got)
+	}
+	if !bus.HasCallback("order.paid") || !bus.HasCallback("order.*") || bus.HasCallback("order.*.created") {
+		t.Fatal("unexpected HasCallback result")
+	}
+
+	single.Unsubscribe()
+	multi.Unsubscribe()
+	received = nil
+	bus.Publish("order.paid", orderCreated{Id: 3})
+	if len(received) != 0 || bus.HasCallback("order.paid") {
+		t.Fatalf("unsubscribed wildcard should not receive, received %v", received)
+	}
+}
@@ -0,0 +1,177 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+)
+
+// Subscription 类型安全订阅的句柄 用于取消订阅
+type Subscription struct {
+	bus     *EventBus
+	topic   string
+	handler *eventHandler
+}
+
+// Unsubscribe 取消订阅
+func (s *Subscription) Unsubscribe() {
+	s.bus.removeHandlerPtr(s.topic, s.handler)
+	s.bus.releasePool(s.handler)
+}
+
+// Subscribe 类型安全的订阅-同步 不需要struct tag
+// 同一个topic的订阅函数参数类型必须一致 不一致时在订阅时返回错误
+func Subscribe[T any](bus Bus, topic string, handler func(event T)) (*Subscription, error) {
+	return subscribe(bus, topic, handler, false, false, false)
+}
+
+// SubscribeAsync 类型安全的订阅-异步
+func SubscribeAsync[T any](bus Bus, topic string, handler func(event T), transactional bool) (*Subscription, error) {
+	return subscribe(bus, topic, handler, false, true, transactional)
+}
+
+// SubscribeOnce 类型安全的订阅-只执行一次-同步
+func SubscribeOnce[T any](bus Bus, topic string, handler func(event T)) (*Subscription, error) {
+	return subscribe(bus, topic, handler, true, false, false)
+}
+
+// SubscribeOnceAsync 类型安全的订阅-只执行一次-异步
+func SubscribeOnceAsync[T any](bus Bus, topic string, handler func(event T)) (*Subscription, error) {
+	return subscribe(bus, topic, handler, true, true, false)
+}
+
+// SubscribeWithError 类型安全的订阅-同步 handler返回的错误由PublishErr返回
+func SubscribeWithError[T any](bus Bus, topic string, handler func(event T) error) (*Subscription, error) {
+	return subscribeFunc(bus, topic, handlerName(handler), withoutContext(handler), false, false, false)
+}
+
+// SubscribeAsyncWithError 类型安全的订阅-异步 handler返回的错误交给ErrorHandler处理
+func SubscribeAsyncWithError[T any](bus Bus, topic string, handler func(event T) error, transactional bool) (*Subscription, error) {
+	return subscribeFunc(bus, topic, handlerName(handler), withoutContext(handler), false, true, transactional)
+}
+
+// SubscribeCtx 类型安全的订阅-同步 handler接收PublishCtx传入的ctx
+func SubscribeCtx[T any](bus Bus, topic string, handler func(ctx context.Context, event T) error) (*Subscription, error) {
+	return subscribeFunc(bus, topic, handlerName(handler), handler, false, false, false)
+}
+
+// SubscribeAsyncCtx 类型安全的订阅-异步 ctx取消后还没有执行的handler不再执行
+func SubscribeAsyncCtx[T any](bus Bus, topic string, handler func(ctx context.Context, event T) error, transactional bool) (*Subscription, error) {
+	return subscribeFunc(bus, topic, handlerName(handler), handler, false, true, transactional)
+}
+
+// Emit 类型安全的推送 编译时检查事件类型
+func Emit[T any](bus Bus, topic string, event T) {
+	bus.Publish(topic, event)
+}
+
+// EmitCtx 类型安全的推送 传入ctx 返回同步订阅函数的错误
+func EmitCtx[T any](ctx context.Context, bus Bus, topic string, event T) error {
+	return bus.PublishCtx(ctx, topic, event)
+}
+
+func subscribe[T any](bus Bus, topic string, handler func(event T), flagOnce, async, transactional bool) (*Subscription, error) {
+	if handler == nil {
+		return nil, errors.New("订阅函数不能为nil")
+	}
+	return subscribeFunc(bus, topic, handlerName(handler), func(_ context.Context, event T) error {
+		handler(event)
+		return nil
+	}, flagOnce, async, transactional)
+}
+
+func withoutContext[T any](handler func(event T) error) func(ctx context.Context, event T) error {
+	if handler == nil {
+		return nil
+	}
+	return func(_ context.Context, event T) error {
+		return handler(event)
+	}
+}
+
+func subscribeFunc[T any](bus Bus, topic, name string, handler func(ctx context.Context, event T) error, flagOnce, async, transactional bool) (*Subscription, error) {
+	if handler == nil {
+		return nil, errors.New("订阅函数不能为nil")
+	}
+	b, err := eventBusOf(bus)
+	if err != nil {
+		return nil, err
+	}
+	h := &eventHandler{
+		flagOnce:      flagOnce,
+		async:         async,
+		transactional: transactional,
+		serial:        new(serialQueue),
+		eventType:     reflect.TypeOf((*T)(nil)).Elem(),
+		name:          name,
+		typed: func(ctx context.Context, args []interface{}) error {
+			var event T
+			if len(args) != 1 {
+				return fmt.Errorf("参数数量错误 需要1个 收到%d个", len(args))
+			}
+			if args[0] != nil {
+				var ok bool
+				if event, ok = args[0].(T); !ok {
+					return fmt.Errorf("参数类型%T错误 需要%T", args[0], event)
+				}
+			}
+			return handler(ctx, event)
+		},
+	}
+	if err := b.doSubscribe(topic, h); err != nil {
+		return nil, err
+	}
+	return &Subscription{
+		bus:     b,
+		topic:   topic,
+		handler: h,
+	}, nil
+}
+
+// handlerName 函数名称 用于错误信息
+func handlerName(handler interface{}) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()); f != nil {
+		return f.Name()
+	}
+	return "unknown"
+}
+
+// eventBusOf 找到Bus对应的EventBus
+func eventBusOf(bus Bus) (*EventBus, error) {
+	switch b := bus.(type) {
+	case *EventBus:
+		return b, nil
+	case *Bridge:
+		return eventBusOf(b.Bus)
+	}
+	return nil, fmt.Errorf("不支持的Bus类型%T", bus)
+}
+
+// argType 订阅函数只有一个事件参数时返回参数类型
+func (handler *eventHandler) argType() (reflect.Type, bool) {
+	types := handler.argTypes()
+	if len(types) != 1 {
+		return nil, false
+	}
+	return types[0], true
+}
+
+// argTypes 订阅函数的事件参数类型 不包括observer和context.Context
+func (handler *eventHandler) argTypes() []reflect.Type {
+	if handler.typed != nil {
+		return []reflect.Type{handler.eventType}
+	}
+	funcType := handler.callBack.Type()
+	//第一个参数是observer
+	start := 1
+	if handler.acceptsContext() {
+		start = 2
+	}
+	types := make([]reflect.Type, 0, funcType.NumIn())
+	for i := start; i < funcType.NumIn(); i++ {
+		types = append(types, funcType.In(i))
+	}
+	return types
+}
@@ -0,0 +1,184 @@
+package eventbus
+
+import (
+	"testing"
+)
+
+type orderCreated struct {
+	Id    uint64
+	Total int64
+}
+
+var sink int64
+
+type orderObserver struct {
+	Fn string `subscribe:"Handle" topic:"order.created"`
+}
+
+func (o orderObserver) Handle(event orderCreated) {
+	sink += event.Total
+}
+
+func TestSubscribeDeliversTypedEvent(t *testing.T) {
+	bus := New()
+	var got orderCreated
+	if _, err := Subscribe(bus, "order.created", func(event orderCreated) {
+		got = event
+	}); err != nil {
+		t.Fatal(err)
+	}
+	Emit(bus, "order.created", orderCreated{Id: 1, Total: 100})
+	if got.Id != 1 || got.Total != 100 {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestSubscribeRejectsMismatchedType(t *testing.T) {
+	bus := New()
+	if _, err := Subscribe(bus, "order.created", func(event orderCreated) {}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Subscribe(bus, "order.created", func(event string) {}); err == nil {
+		t.Fatal("expected mismatched subscription to fail")
+	}
+	if _, err := Subscribe(bus, "order.created", func(event *orderCreated) {}); err == nil {
+		t.Fatal("expected pointer subscription to fail")
+	}
+}
+
+func TestSubscribeChecksReflectionHandlers(t *testing.T) {
+	bus := New()
+	if err := bus.Subscribe(orderObserver{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Subscribe(bus, "order.created", func(event orderCreated) {}); err != nil {
+		t.Fatalf("matching type rejected: %v", err)
+	}
+	if _, err := Subscribe(bus, "order.created", func(event int) {}); err == nil {
+		t.Fatal("expected mismatched subscription to fail")
+	}
+}
+
+func TestSubscribeChecksWildcardHandlers(t *testing.T) {
+	cases := []struct {
+		first, second string
+		ok            bool
+	}{
+		{"order.*", "order.created", false},
+		{"order.created", "order.*", false},
+		{"order.#", "order.created.v2", false},
+		{"#", "user.created", false},
+		{"order.*.created", "order.paid.*", false},
+		{"order.*", "order.created.v2", true},
+		{"order.#", "user.created", true},
+		{"order.*", "user.*", true},
+	}
+	for _, c := range cases {
+		bus := New()
+		if _, err := Subscribe(bus, c.first, func(event orderCreated) {}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Subscribe(bus, c.second, func(event string) {})
+		if (err == nil) != c.ok {
+			t.Fatalf("%s then %s: unexpected error %v", c.first, c.second, err)
+		}
+		//类型一致时可以订阅
+		if _, err := Subscribe(bus, c.second, func(event orderCreated) {}); !c.ok && err != nil {
+			t.Fatalf("%s then %s: matching type rejected: %v", c.first, c.second, err)
+		}
+	}
+}
+
+func TestTopicsOverlap(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.paid", false},
+		{"order.*", "order.created", true},
+		{"order.*", "order", false},
+		{"order.*", "order.created.v2", false},
+		{"order.#", "order", true},
+		{"order.#", "order.created.v2", true},
+		{"order.#", "user.created", false},
+		{"#", "order.created", true},
+		{"*.created", "order.*", true},
+		{"*.created", "order.paid", false},
+		{"order.#.v2", "order.*.created.*", true},
+		{"order.#.v2", "order.created.v1", false},
+		{"#.v2", "order.#", true},
+		{"a.#.b.#.c", "a.x.b.y.c", true},
+		{"a.#.b.#.c", "a.x.y.c", false},
+	}
+	for _, c := range cases {
+		if got := topicsOverlap(c.a, c.b); got != c.want {
+			t.Fatalf("topicsOverlap(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+		if got := topicsOverlap(c.b, c.a); got != c.want {
+			t.Fatalf("topicsOverlap(%q, %q) = %v, want %v", c.b, c.a, got, c.want)
+		}
+	}
+}
+
+func TestPublishWrongTypeDoesNotPanic(t *testing.T) {
+	bus := New()
+	called := false
+	if _, err := Subscribe(bus, "order.created", func(event orderCreated) {
+		called = true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish("order.created", "not an order")
+	if called {
+		t.Fatal("handler called with wrong type")
+	}
+}
+
+func TestSubscriptionUnsubscribe(t *testing.T) {
+	bus := New()
+	calls := 0
+	sub, err := Subscribe(bus, "order.created", func(event orderCreated) {
+		calls++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Emit(bus, "order.created", orderCreated{})
+	sub.Unsubscribe()
+	Emit(bus, "order.created", orderCreated{})
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+	if bus.HasCallback("order.created") {
+		t.Fatal("topic still has callbacks")
+	}
+}
+
+func BenchmarkPublishReflect(b *testing.B) {
+	bus := New()
+	if err := bus.Subscribe(orderObserver{}); err != nil {
+		b.Fatal(err)
+	}
+	event := orderCreated{Id: 1, Total: 1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.Publish("order.created", event)
+	}
+}
+
+func BenchmarkPublishTyped(b *testing.B) {
+	bus := New()
+	if _, err := Subscribe(bus, "order.created", func(event orderCreated) {
+		sink += event.Total
+	}); err != nil {
+		b.Fatal(err)
+	}
+	event := orderCreated{Id: 1, Total: 1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Emit(bus, "order.created", event)
+	}
+}
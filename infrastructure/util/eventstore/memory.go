@@ -0,0 +1,128 @@
+package eventstore
+
+import (
+	"DDD/infrastructure/util/eventbus"
+
+	"sync"
+)
+
+// MemoryStore 保存在内存中 用于测试
+type MemoryStore struct {
+	streams map[string][]Record
+	all     []Record
+	sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		streams: make(map[string][]Record),
+	}
+}
+
+func (s *MemoryStore) Append(aggregateId string, expectedVersion int64, events ...eventbus.Event) ([]Record, error) {
+	records, err := newRecords(aggregateId, expectedVersion, events)
+	if err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if int64(len(s.streams[aggregateId])) != expectedVersion {
+		return nil, ErrConcurrency
+	}
+	for i := range records[:] {
+		records[i].Position = int64(len(s.all)) + int64(i) + 1
+	}
+	s.streams[aggregateId] = append(s.streams[aggregateId], records...)
+	s.all = append(s.all, records...)
+	return records, nil
+}
+
+func (s *MemoryStore) Load(aggregateId string) ([]Record, error) {
+	return s.LoadFrom(aggregateId, 0)
+}
+
+func (s *MemoryStore) LoadFrom(aggregateId string, fromVersion int64) ([]Record, error) {
+	s.RLock()
+	defer s.RUnlock()
+	stream := s.streams[aggregateId]
+	if fromVersion < 0 {
+		fromVersion = 0
+	}
+	if fromVersion >= int64(len(stream)) {
+		return nil, nil
+	}
+	//版本从1开始 下标为版本减1
+	records := make([]Record, len(stream)-int(fromVersion))
+	copy(records, stream[fromVersion:])
+	return records, nil
+}
+
+func (s *MemoryStore) Version(aggregateId string) (int64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return int64(len(s.streams[aggregateId])), nil
+}
+
+func (s *MemoryStore) ReadAll(after int64, limit int) ([]Record, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if after < 0 {
+		after = 0
+	}
+	if after >= int64(len(s.all)) {
+		return nil, nil
+	}
+	//位置从1开始 下标为位置减1
+	end := int64(len(s.all))
+	if limit > 0 && after+int64(limit) < end {
+		end = after + int64(limit)
+	}
+	records := make([]Record, end-after)
+	copy(records, s.all[after:end])
+	return records, nil
+}
+
+func (s *MemoryStore) Head() (int64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return int64(len(s.all)), nil
+}
+
+// MemorySnapshotStore 快照保存在内存中 用于测试
+type MemorySnapshotStore struct {
+	snapshots map[string]Snapshot
+	sync.RWMutex
+}
+
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{
+		snapshots: make(map[string]Snapshot),
+	}
+}
+
+func (s *MemorySnapshotStore) Save(snapshot *Snapshot) error {
+	s.Lock()
+	defer s.Unlock()
+	if latest, ok := s.snapshots[snapshot.AggregateId]; ok && latest.Version > snapshot.Version {
+		return nil
+	}
+	s.snapshots[snapshot.AggregateId] = *snapshot
+	return nil
+}
+
+func (s *MemorySnapshotStore) Latest(aggregateId string) (*Snapshot, error) {
+	s.RLock()
+	defer s.RUnlock()
+	snapshot, ok := s.snapshots[aggregateId]
+	if !ok {
+		return nil, nil
+	}
+	return &snapshot, nil
+}
+
+func (s *MemorySnapshotStore) Delete(aggregateId string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.snapshots, aggregateId)
+	return nil
+}
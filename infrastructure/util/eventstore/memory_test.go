@@ -0,0 +1,150 @@
+package eventstore
+
+import (
+	"DDD/infrastructure/util/eventbus"
+
+	"errors"
+	"testing"
+)
+
+type counterAdded struct {
+	Amount int `json:"amount"`
+}
+
+func (counterAdded) EventType() string {
+	return "eventstore.counter.added"
+}
+
+func (counterAdded) EventVersion() int {
+	return 1
+}
+
+func init() {
+	eventbus.RegisterEvent(counterAdded{})
+}
+
+func appendAdded(t *testing.T, store Store, aggregateId string, expectedVersion int64, amounts ...int) []Record {
+	t.Helper()
+	events := make([]eventbus.Event, 0, len(amounts))
+	for _, amount := range amounts {
+		events = append(events, counterAdded{Amount: amount})
+	}
+	records, err := store.Append(aggregateId, expectedVersion, events...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestMemoryStoreAppendAssignsVersions(t *testing.T) {
+	store := NewMemoryStore()
+	records := appendAdded(t, store, "counter:1", 0, 1, 2)
+	records = append(records, appendAdded(t, store, "counter:1", 2, 3)...)
+	for i := range records {
+		if records[i].Version != int64(i)+1 {
+			t.Fatalf("record %d has version %d", i, records[i].Version)
+		}
+		if records[i].EventId == "" {
+			t.Fatalf("record %d has no event id", i)
+		}
+	}
+	if version, _ := store.Version("counter:1"); version != 3 {
+		t.Fatalf("expected version 3, got %d", version)
+	}
+}
+
+func TestMemoryStoreAppendConflict(t *testing.T) {
+	store := NewMemoryStore()
+	appendAdded(t, store, "counter:1", 0, 1)
+	for _, expected := range []int64{0, 2} {
+		_, err := store.Append("counter:1", expected, counterAdded{Amount: 2})
+		if !errors.Is(err, ErrConcurrency) {
+			t.Fatalf("expected version %d: expected ErrConcurrency, got %v", expected, err)
+		}
+	}
+	if version, _ := store.Version("counter:1"); version != 1 {
+		t.Fatalf("conflicting append changed the version to %d", version)
+	}
+	if head, _ := store.Head(); head != 1 {
+		t.Fatalf("conflicting append changed the head to %d", head)
+	}
+}
+
+func TestMemoryStoreLoadFrom(t *testing.T) {
+	store := NewMemoryStore()
+	appendAdded(t, store, "counter:1", 0, 1, 2, 3, 4)
+	appendAdded(t, store, "counter:2", 0, 10)
+	cases := []struct {
+		from     int64
+		versions []int64
+	}{
+		{-1, []int64{1, 2, 3, 4}},
+		{0, []int64{1, 2, 3, 4}},
+		{2, []int64{3, 4}},
+		{4, nil},
+		{9, nil},
+	}
+	for _, c := range cases {
+		records, err := store.LoadFrom("counter:1", c.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != len(c.versions) {
+			t.Fatalf("from %d: expected %d records, got %d", c.from, len(c.versions), len(records))
+		}
+		for i := range records {
+			if records[i].AggregateId != "counter:1" || records[i].Version != c.versions[i] {
+				t.Fatalf("from %d: record %d is %s@%d", c.from, i, records[i].AggregateId, records[i].Version)
+			}
+		}
+	}
+	if records, _ := store.Load("counter:3"); len(records) != 0 {
+		t.Fatalf("unknown aggregate returned %d records", len(records))
+	}
+}
+
+func TestMemoryStoreReadAllOrderAndPaging(t *testing.T) {
+	store := NewMemoryStore()
+	appendAdded(t, store, "counter:1", 0, 1, 2)
+	appendAdded(t, store, "counter:2", 0, 3)
+	appendAdded(t, store, "counter:1", 2, 4)
+	appendAdded(t, store, "counter:2", 1, 5)
+
+	var read []Record
+	var after int64
+	for {
+		page, err := store.ReadAll(after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > 2 {
+			t.Fatalf("page has %d records", len(page))
+		}
+		read = append(read, page...)
+		after = page[len(page)-1].Position
+	}
+	if len(read) != 5 {
+		t.Fatalf("expected 5 records, got %d", len(read))
+	}
+	for i := range read {
+		if read[i].Position != int64(i)+1 {
+			t.Fatalf("record %d has position %d", i, read[i].Position)
+		}
+		event, err := read[i].Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.(*counterAdded).Amount != i+1 {
+			t.Fatalf("record %d is out of order: %+v", i, event)
+		}
+	}
+	if head, _ := store.Head(); head != 5 {
+		t.Fatalf("expected head 5, got %d", head)
+	}
+	if all, _ := store.ReadAll(0, 0); len(all) != 5 {
+		t.Fatalf("limit 0 should read everything, got %d", len(all))
+	}
+}
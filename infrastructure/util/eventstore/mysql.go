@@ -0,0 +1,184 @@
+package eventstore
+
+import (
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/jinzhu/gorm"
+
+	"database/sql"
+	"time"
+)
+
+// StoredEvent event_store表 同一个聚合的版本唯一
+type StoredEvent struct {
+	mysql.BaseModel
+	EventId      string    `gorm:"column:event_id;type:varchar(32);unique_index" json:"event_id"`
+	AggregateId  string    `gorm:"column:aggregate_id;type:varchar(64);unique_index:uk_event_store_aggregate_version" json:"aggregate_id"`
+	Version      int64     `gorm:"column:version;unique_index:uk_event_store_aggregate_version" json:"version"`
+	Type         string    `gorm:"column:type;type:varchar(128)" json:"type"`
+	EventVersion int       `gorm:"column:event_version" json:"event_version"`
+	Data         string    `gorm:"column:data;type:mediumtext" json:"data"`
+	OccurredAt   time.Time `gorm:"column:occurred_at" json:"occurred_at"`
+}
+
+func (e *StoredEvent) TableName() string {
+	return "event_store"
+}
+
+func (e *StoredEvent) record() Record {
+	return Record{
+		Position:     int64(e.Id),
+		EventId:      e.EventId,
+		AggregateId:  e.AggregateId,
+		Version:      e.Version,
+		Type:         e.Type,
+		EventVersion: e.EventVersion,
+		Data:         []byte(e.Data),
+		OccurredAt:   e.OccurredAt,
+	}
+}
+
+// MysqlStore 使用mysql保存
+type MysqlStore struct {
+	db *gorm.DB
+}
+
+func NewMysqlStore(db *gorm.DB) *MysqlStore {
+	return &MysqlStore{
+		db: db,
+	}
+}
+
+// WithDB 使用指定的连接 传入事务时事件和其他数据一起提交
+func (s *MysqlStore) WithDB(db *gorm.DB) *MysqlStore {
+	return &MysqlStore{
+		db: db,
+	}
+}
+
+// AutoMigrate 创建event_store表
+func (s *MysqlStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&StoredEvent{}).Error
+}
+
+// Append 在事务中检查版本后写入 并发写入同一个版本时由唯一索引保证只有一个成功
+func (s *MysqlStore) Append(aggregateId string, expectedVersion int64, events ...eventbus.Event) ([]Record, error) {
+	records, err := newRecords(aggregateId, expectedVersion, events)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return records, nil
+	}
+	err = s.transaction(func(tx *gorm.DB) error {
+		version, err := version(tx, aggregateId)
+		if err != nil {
+			return err
+		}
+		if version != expectedVersion {
+			return ErrConcurrency
+		}
+		now := time.Now()
+		for i := range records[:] {
+			row := &StoredEvent{
+				BaseModel:    mysql.BaseModel{CreatedAt: now, UpdatedAt: now},
+				EventId:      records[i].EventId,
+				AggregateId:  records[i].AggregateId,
+				Version:      records[i].Version,
+				Type:         records[i].Type,
+				EventVersion: records[i].EventVersion,
+				Data:         string(records[i].Data),
+				OccurredAt:   records[i].OccurredAt,
+			}
+			err := tx.Create(row).Error
+			if mysql.IsDuplicateEntry(err) {
+				return ErrConcurrency
+			}
+			if err != nil {
+				return err
+			}
+			records[i].Position = int64(row.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// transaction 已经在事务中时直接使用
+func (s *MysqlStore) transaction(fn func(tx *gorm.DB) error) error {
+	if _, ok := s.db.CommonDB().(*sql.Tx); ok {
+		return fn(s.db)
+	}
+	return s.db.Transaction(fn)
+}
+
+func (s *MysqlStore) Load(aggregateId string) ([]Record, error) {
+	return s.LoadFrom(aggregateId, 0)
+}
+
+func (s *MysqlStore) LoadFrom(aggregateId string, fromVersion int64) ([]Record, error) {
+	var rows []StoredEvent
+	err := s.db.Where("aggregate_id = ? AND version > ?", aggregateId, fromVersion).
+		Order("version").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0, len(rows))
+	for i := range rows[:] {
+		records = append(records, rows[i].record())
+	}
+	return records, nil
+}
+
+func (s *MysqlStore) Version(aggregateId string) (int64, error) {
+	return version(s.db, aggregateId)
+}
+
+// ReadAll 按自增id读取 id可能不连续 未提交事务中较小的id可能稍后才可见
+func (s *MysqlStore) ReadAll(after int64, limit int) ([]Record, error) {
+	var rows []StoredEvent
+	query := s.db.Where("id > ?", after).Order("id")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0, len(rows))
+	for i := range rows[:] {
+		records = append(records, rows[i].record())
+	}
+	return records, nil
+}
+
+func (s *MysqlStore) Head() (int64, error) {
+	var result struct {
+		Head int64
+	}
+	err := s.db.Model(&StoredEvent{}).
+		Select("COALESCE(MAX(id), 0) AS head").
+		Scan(&result).Error
+	if err != nil {
+		return 0, err
+	}
+	return result.Head, nil
+}
+
+func version(db *gorm.DB, aggregateId string) (int64, error) {
+	var result struct {
+		Version int64
+	}
+	err := db.Model(&StoredEvent{}).
+		Select("COALESCE(MAX(version), 0) AS version").
+		Where("aggregate_id = ?", aggregateId).
+		Scan(&result).Error
+	if err != nil {
+		return 0, err
+	}
+	return result.Version, nil
+}
@@ -0,0 +1,75 @@
+package eventstore
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"time"
+)
+
+// StoredSnapshot event_snapshot表 每个聚合一行 只保留最新的快照
+type StoredSnapshot struct {
+	Id            uint64    `gorm:"primary_key;AUTO_INCREMENT;column:id" json:"id"`
+	CreatedAt     time.Time `gorm:"column:created_at" json:"created_at"`
+	AggregateId   string    `gorm:"column:aggregate_id;type:varchar(64);unique_index" json:"aggregate_id"`
+	Version       int64     `gorm:"column:version" json:"version"`
+	SchemaVersion int       `gorm:"column:schema_version" json:"schema_version"`
+	Data          string    `gorm:"column:data;type:mediumtext" json:"data"`
+}
+
+func (s *StoredSnapshot) TableName() string {
+	return "event_snapshot"
+}
+
+// MysqlSnapshotStore 快照保存在mysql
+type MysqlSnapshotStore struct {
+	db *gorm.DB
+}
+
+func NewMysqlSnapshotStore(db *gorm.DB) *MysqlSnapshotStore {
+	return &MysqlSnapshotStore{
+		db: db,
+	}
+}
+
+// AutoMigrate 创建event_snapshot表
+func (s *MysqlSnapshotStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&StoredSnapshot{}).Error
+}
+
+// Save 版本不小于已有快照时覆盖 version需要最后更新
+func (s *MysqlSnapshotStore) Save(snapshot *Snapshot) error {
+	return s.db.Exec("INSERT INTO event_snapshot (created_at, aggregate_id, version, schema_version, data) VALUES (?, ?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE "+
+		"created_at = IF(VALUES(version) >= version, VALUES(created_at), created_at), "+
+		"schema_version = IF(VALUES(version) >= version, VALUES(schema_version), schema_version), "+
+		"data = IF(VALUES(version) >= version, VALUES(data), data), "+
+		"version = IF(VALUES(version) >= version, VALUES(version), version)",
+		snapshot.CreatedAt,
+		snapshot.AggregateId,
+		snapshot.Version,
+		snapshot.SchemaVersion,
+		string(snapshot.Data),
+	).Error
+}
+
+func (s *MysqlSnapshotStore) Latest(aggregateId string) (*Snapshot, error) {
+	var row StoredSnapshot
+	err := s.db.Where("aggregate_id = ?", aggregateId).First(&row).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		AggregateId:   row.AggregateId,
+		Version:       row.Version,
+		SchemaVersion: row.SchemaVersion,
+		Data:          []byte(row.Data),
+		CreatedAt:     row.CreatedAt,
+	}, nil
+}
+
+func (s *MysqlSnapshotStore) Delete(aggregateId string) error {
+	return s.db.Where("aggregate_id = ?", aggregateId).Delete(&StoredSnapshot{}).Error
+}
@@ -0,0 +1,64 @@
+package eventstore
+
+import (
+	"DDD/infrastructure/util/redis"
+
+	"encoding/json"
+	"time"
+)
+
+const redisSnapshotPrefix = "snapshot:"
+
+// RedisSnapshotStore 快照保存在redis TTL为0时不过期
+type RedisSnapshotStore struct {
+	TTL time.Duration
+}
+
+func NewRedisSnapshotStore() *RedisSnapshotStore {
+	return new(RedisSnapshotStore)
+}
+
+func (s *RedisSnapshotStore) key(aggregateId string) string {
+	return redisSnapshotPrefix + aggregateId
+}
+
+// Save 已有更新的快照时忽略 并发保存时可能覆盖 只会导致读取时多重放一些事件
+func (s *RedisSnapshotStore) Save(snapshot *Snapshot) error {
+	latest, err := s.Latest(snapshot.AggregateId)
+	if err != nil {
+		return err
+	}
+	if latest != nil && latest.Version > snapshot.Version {
+		return nil
+	}
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	return client.Set(s.key(snapshot.AggregateId), string(value), s.TTL)
+}
+
+func (s *RedisSnapshotStore) Latest(aggregateId string) (*Snapshot, error) {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	value, err := client.Get(s.key(aggregateId))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := new(Snapshot)
+	if err := json.Unmarshal([]byte(value), snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (s *RedisSnapshotStore) Delete(aggregateId string) error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	return client.Del(s.key(aggregateId))
+}
@@ -0,0 +1,137 @@
+package eventstore
+
+import (
+	"DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/eventbus"
+
+	"go.uber.org/zap"
+
+	"encoding/json"
+	"time"
+)
+
+const snapshotEvery = 100
+
+// Snapshot 聚合在某个版本的状态
+type Snapshot struct {
+	AggregateId   string          `json:"aggregate_id"`
+	Version       int64           `json:"version"`        //快照对应的聚合版本
+	SchemaVersion int             `json:"schema_version"` //快照结构的版本
+	Data          json.RawMessage `json:"data"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// SnapshotStore 快照存储 每个聚合只需要保留最新的快照
+type SnapshotStore interface {
+	// Save 保存快照 已有更新的快照时忽略
+	Save(snapshot *Snapshot) error
+	// Latest 读取最新的快照 没有快照时返回nil
+	Latest(aggregateId string) (*Snapshot, error)
+	// Delete 删除聚合的快照
+	Delete(aggregateId string) error
+}
+
+// Snapshotable 可以保存快照的聚合
+type Snapshotable interface {
+	Applier
+	// SnapshotVersion 快照结构的版本 聚合的字段变化后需要增加 旧版本的快照会被丢弃
+	SnapshotVersion() int
+	// Snapshot 序列化聚合当前的状态
+	Snapshot() ([]byte, error)
+	// Restore 从快照恢复聚合的状态
+	Restore(data []byte) error
+}
+
+// Snapshotter 每Every个事件保存一次快照 读取时从最新的快照开始重放
+type Snapshotter struct {
+	Every     int64
+	store     Store
+	snapshots SnapshotStore
+}
+
+func NewSnapshotter(store Store, snapshots SnapshotStore) *Snapshotter {
+	return &Snapshotter{
+		Every:     snapshotEvery,
+		store:     store,
+		snapshots: snapshots,
+	}
+}
+
+// Load 重建聚合 返回聚合当前的版本 aggregate需要是空的聚合
+// 快照结构的版本和聚合不一致时丢弃快照 从第一个事件开始重建 然后保存新的快照
+func (s *Snapshotter) Load(aggregateId string, aggregate Snapshotable) (int64, error) {
+	snapshot, err := s.snapshots.Latest(aggregateId)
+	if err != nil {
+		return 0, err
+	}
+	var from int64
+	discarded := false
+	if snapshot != nil && snapshot.SchemaVersion != aggregate.SnapshotVersion() {
+		if err := s.snapshots.Delete(aggregateId); err != nil {
+			return 0, err
+		}
+		snapshot = nil
+		discarded = true
+	}
+	if snapshot != nil {
+		if err := aggregate.Restore(snapshot.Data); err != nil {
+			return 0, err
+		}
+		from = snapshot.Version
+	}
+	records, err := s.store.LoadFrom(aggregateId, from)
+	if err != nil {
+		return 0, err
+	}
+	version, err := Replay(aggregate, records)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return from, nil
+	}
+	//丢弃快照后不论事件多少都保存 否则事件少于Every的聚合一直没有快照
+	if s.Every > 0 && (discarded || version-from >= s.Every) {
+		s.save(aggregateId, version, aggregate)
+	}
+	return version, nil
+}
+
+// Append 追加事件 版本跨过Every的倍数时保存快照
+// aggregate需要已经应用了events 快照保存失败只记录日志 事件已经保存 下次读取时多重放一些事件
+func (s *Snapshotter) Append(aggregateId string, expectedVersion int64, aggregate Snapshotable, events ...eventbus.Event) ([]Record, error) {
+	records, err := s.store.Append(aggregateId, expectedVersion, events...)
+	if err != nil || len(records) == 0 {
+		return records, err
+	}
+	version := records[len(records)-1].Version
+	if s.Every > 0 && version/s.Every != expectedVersion/s.Every {
+		s.save(aggregateId, version, aggregate)
+	}
+	return records, nil
+}
+
+// Save 立即保存快照
+func (s *Snapshotter) Save(aggregateId string, version int64, aggregate Snapshotable) error {
+	data, err := aggregate.Snapshot()
+	if err != nil {
+		return err
+	}
+	return s.snapshots.Save(&Snapshot{
+		AggregateId:   aggregateId,
+		Version:       version,
+		SchemaVersion: aggregate.SnapshotVersion(),
+		Data:          data,
+		CreatedAt:     time.Now(),
+	})
+}
+
+func (s *Snapshotter) save(aggregateId string, version int64, aggregate Snapshotable) {
+	if err := s.Save(aggregateId, version, aggregate); err != nil {
+		config.Logger.Error("print-srv:event-snapshot",
+			zap.String("aggregate_id", aggregateId),
+			zap.Int64("version", version),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,215 @@
+package eventstore
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+type counter struct {
+	Total   int `json:"total"`
+	schema  int
+	applied int
+}
+
+func newCounter(schema int) *counter {
+	return &counter{schema: schema}
+}
+
+func (c *counter) Apply(event interface{}) error {
+	added, ok := event.(*counterAdded)
+	if !ok {
+		return fmt.Errorf("unexpected event %T", event)
+	}
+	c.Total += added.Amount
+	c.applied++
+	return nil
+}
+
+func (c *counter) SnapshotVersion() int {
+	return c.schema
+}
+
+func (c *counter) Snapshot() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (c *counter) Restore(data []byte) error {
+	return json.Unmarshal(data, c)
+}
+
+func TestSnapshotterSavesEveryAndLoadsSnapshotPlusTail(t *testing.T) {
+	store := NewMemoryStore()
+	snapshots := NewMemorySnapshotStore()
+	snapshotter := NewSnapshotter(store, snapshots)
+	snapshotter.Every = 3
+
+	aggregate := newCounter(1)
+	var version int64
+	for amount := 1; amount <= 5; amount++ {
+		aggregate.Apply(&counterAdded{Amount: amount})
+		records, err := snapshotter.Append("counter:1", version, aggregate, counterAdded{Amount: amount})
+		if err != nil {
+			t.Fatal(err)
+		}
+		version = records[len(records)-1].Version
+	}
+	snapshot, _ := snapshots.Latest("counter:1")
+	if snapshot == nil || snapshot.Version != 3 || snapshot.SchemaVersion != 1 {
+		t.Fatalf("expected a schema 1 snapshot at version 3, got %+v", snapshot)
+	}
+
+	loaded := newCounter(1)
+	version, err := snapshotter.Load("counter:1", loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 5 || loaded.Total != 15 {
+		t.Fatalf("expected version 5 total 15, got version %d total %d", version, loaded.Total)
+	}
+	if loaded.applied != 2 {
+		t.Fatalf("expected only the 2 events after the snapshot to be replayed, got %d", loaded.applied)
+	}
+}
+
+func TestSnapshotterLoadWithoutTail(t *testing.T) {
+	store := NewMemoryStore()
+	snapshots := NewMemorySnapshotStore()
+	snapshotter := NewSnapshotter(store, snapshots)
+	appendAdded(t, store, "counter:1", 0, 1, 2)
+	if err := snapshotter.Save("counter:1", 2, &counter{Total: 3, schema: 1}); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newCounter(1)
+	version, err := snapshotter.Load("counter:1", loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || loaded.Total != 3 || loaded.applied != 0 {
+		t.Fatalf("version %d total %d applied %d", version, loaded.Total, loaded.applied)
+	}
+}
+
+func TestSnapshotterDiscardsSnapshotWithOtherSchema(t *testing.T) {
+	store := NewMemoryStore()
+	snapshots := NewMemorySnapshotStore()
+	snapshotter := NewSnapshotter(store, snapshots)
+	snapshotter.Every = 3
+	appendAdded(t, store, "counter:1", 0, 1, 2, 3, 4)
+	//旧结构的快照 内容和事件不一致 如果被使用结果会是错的
+	if err := snapshotter.Save("counter:1", 4, &counter{Total: 100, schema: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newCounter(2)
+	version, err := snapshotter.Load("counter:1", loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 4 || loaded.Total != 10 || loaded.applied != 4 {
+		t.Fatalf("expected a full rebuild, got version %d total %d applied %d", version, loaded.Total, loaded.applied)
+	}
+	snapshot, _ := snapshots.Latest("counter:1")
+	if snapshot == nil || snapshot.SchemaVersion != 2 || snapshot.Version != 4 {
+		t.Fatalf("expected the rebuild to save a schema 2 snapshot, got %+v", snapshot)
+	}
+}
+
+func TestSnapshotterResavesShortAggregateAfterSchemaChange(t *testing.T) {
+	store := NewMemoryStore()
+	snapshots := NewMemorySnapshotStore()
+	snapshotter := NewSnapshotter(store, snapshots)
+	snapshotter.Every = 3
+	//事件数少于Every
+	appendAdded(t, store, "counter:1", 0, 1, 2)
+	if err := snapshotter.Save("counter:1", 2, &counter{Total: 100, schema: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newCounter(2)
+	version, err := snapshotter.Load("counter:1", loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || loaded.Total != 3 || loaded.applied != 2 {
+		t.Fatalf("expected a full rebuild, got version %d total %d applied %d", version, loaded.Total, loaded.applied)
+	}
+	snapshot, _ := snapshots.Latest("counter:1")
+	if snapshot == nil || snapshot.SchemaVersion != 2 || snapshot.Version != 2 {
+		t.Fatalf("expected a schema 2 snapshot at version 2, got %+v", snapshot)
+	}
+
+	//下次读取使用新快照
+	loaded = newCounter(2)
+	if version, err := snapshotter.Load("counter:1", loaded); err != nil || version != 2 || loaded.Total != 3 || loaded.applied != 0 {
+		t.Fatalf("expected the new snapshot to be used, got version %d total %d applied %d %v", version, loaded.Total, loaded.applied, err)
+	}
+}
+
+func testSnapshotStoreKeepsNewer(t *testing.T, snapshots SnapshotStore, aggregateId string) {
+	t.Helper()
+	save := func(version int64, data string) {
+		t.Helper()
+		err := snapshots.Save(&Snapshot{
+			AggregateId:   aggregateId,
+			Version:       version,
+			SchemaVersion: 1,
+			Data:          json.RawMessage(data),
+			CreatedAt:     time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(5, `{"total":5}`)
+	save(3, `{"total":3}`)
+	snapshot, err := snapshots.Latest(aggregateId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot == nil || snapshot.Version != 5 || string(snapshot.Data) != `{"total":5}` {
+		t.Fatalf("older snapshot overwrote the newer one: %+v", snapshot)
+	}
+	save(8, `{"total":8}`)
+	snapshot, _ = snapshots.Latest(aggregateId)
+	if snapshot == nil || snapshot.Version != 8 || string(snapshot.Data) != `{"total":8}` {
+		t.Fatalf("newer snapshot was not saved: %+v", snapshot)
+	}
+}
+
+func TestMemorySnapshotStoreKeepsNewer(t *testing.T) {
+	testSnapshotStoreKeepsNewer(t, NewMemorySnapshotStore(), "counter:1")
+}
+
+// testDB 连接DDD_TEST_MYSQL_DSN指定的数据库 没有设置时跳过
+func testDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("DDD_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("DDD_TEST_MYSQL_DSN is not set")
+	}
+	db, err := gorm.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+func TestMysqlSnapshotStoreKeepsNewer(t *testing.T) {
+	db := testDB(t)
+	snapshots := NewMysqlSnapshotStore(db)
+	if err := snapshots.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	aggregateId := fmt.Sprintf("counter:%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		snapshots.Delete(aggregateId)
+	})
+	testSnapshotStoreKeepsNewer(t, snapshots, aggregateId)
+}
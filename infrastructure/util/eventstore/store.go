@@ -0,0 +1,120 @@
+package eventstore
+
+import (
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/pkg/snowflake"
+
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrConcurrency 聚合的版本和期望的版本不一致 说明聚合已经被其他请求修改
+var ErrConcurrency = errors.New("聚合版本冲突")
+
+// Store 事件存储 每个聚合的事件按版本顺序保存
+type Store interface {
+	// Append 追加事件 expectedVersion为聚合当前的版本 新聚合为0 版本不一致时返回ErrConcurrency
+	Append(aggregateId string, expectedVersion int64, events ...eventbus.Event) ([]Record, error)
+	// Load 读取聚合的全部事件
+	Load(aggregateId string) ([]Record, error)
+	// LoadFrom 读取版本大于fromVersion的事件
+	LoadFrom(aggregateId string, fromVersion int64) ([]Record, error)
+	// Version 聚合当前的版本 没有事件时为0
+	Version(aggregateId string) (int64, error)
+}
+
+// Feed 按写入顺序读取所有聚合的事件 用于投影
+type Feed interface {
+	// ReadAll 读取位置大于after的事件 最多limit条
+	ReadAll(after int64, limit int) ([]Record, error)
+	// Head 最新事件的位置 没有事件时为0
+	Head() (int64, error)
+}
+
+// Record 保存后的事件
+type Record struct {
+	Position     int64           `json:"position"` //所有事件中的位置 从1开始递增
+	EventId      string          `json:"event_id"`
+	AggregateId  string          `json:"aggregate_id"`
+	Version      int64           `json:"version"` //聚合的版本 从1开始
+	Type         string          `json:"type"`
+	EventVersion int             `json:"event_version"` //事件结构的版本
+	Data         json.RawMessage `json:"data"`
+	OccurredAt   time.Time       `json:"occurred_at"`
+}
+
+// Envelope 转换成事件信封 可以用eventbus的注册类型解析
+func (r *Record) Envelope() *eventbus.Envelope {
+	return &eventbus.Envelope{
+		Id:         r.EventId,
+		Type:       r.Type,
+		Version:    r.EventVersion,
+		OccurredAt: r.OccurredAt,
+		Source:     r.AggregateId,
+		Data:       r.Data,
+	}
+}
+
+// Decode 解析成eventbus.RegisterEvent注册的类型 返回该类型的指针
+func (r *Record) Decode() (interface{}, error) {
+	return r.Envelope().Decode()
+}
+
+// Applier 根据事件修改聚合的状态
+type Applier interface {
+	Apply(event interface{}) error
+}
+
+// Replay 按顺序应用事件 返回最后一个事件的版本
+func Replay(applier Applier, records []Record) (int64, error) {
+	var version int64
+	for i := range records[:] {
+		event, err := records[i].Decode()
+		if err != nil {
+			return version, err
+		}
+		if err := applier.Apply(event); err != nil {
+			return version, err
+		}
+		version = records[i].Version
+	}
+	return version, nil
+}
+
+// Rehydrate 读取聚合的全部事件并重建状态 返回聚合当前的版本
+func Rehydrate(store Store, aggregateId string, applier Applier) (int64, error) {
+	records, err := store.Load(aggregateId)
+	if err != nil {
+		return 0, err
+	}
+	return Replay(applier, records)
+}
+
+// newRecords 生成版本连续的事件
+func newRecords(aggregateId string, expectedVersion int64, events []eventbus.Event) ([]Record, error) {
+	if aggregateId == "" {
+		return nil, errors.New("聚合id不能为空")
+	}
+	if expectedVersion < 0 {
+		return nil, errors.New("期望版本不能小于0")
+	}
+	now := time.Now()
+	records := make([]Record, 0, len(events))
+	for i := range events[:] {
+		data, err := json.Marshal(events[i])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, Record{
+			EventId:      snowflake.BaseNumberString(),
+			AggregateId:  aggregateId,
+			Version:      expectedVersion + int64(i) + 1,
+			Type:         events[i].EventType(),
+			EventVersion: events[i].EventVersion(),
+			Data:         data,
+			OccurredAt:   now,
+		})
+	}
+	return records, nil
+}
@@ -0,0 +1,101 @@
+package inbox
+
+import (
+	"DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/pkg/snowflake"
+
+	"go.uber.org/zap"
+
+	"errors"
+	"fmt"
+	"time"
+)
+
+// State 事件在收件箱中的状态
+type State int8
+
+const (
+	StateNew        State = iota //第一次收到 可以处理
+	StateProcessing              //其他消费者正在处理
+	StateDone                    //已经处理完成
+)
+
+const (
+	inboxTTL   = 7 * 24 * time.Hour //处理完成的记录保留时间 需要大于mq可能重复投递的时间
+	inboxLease = 5 * time.Minute    //处理中记录的保留时间 消费者崩溃后到期可以重新处理
+)
+
+// ErrProcessing 同一个事件正在被处理 稍后重试 mq延迟后重新投递
+var ErrProcessing = fmt.Errorf("事件正在处理中: %w", eventbus.ErrRetryLater)
+
+// ErrLeaseLost 处理超过了Lease 记录已经过期或者被其他消费者占用
+var ErrLeaseLost = errors.New("事件处理超时 已被其他消费者占用")
+
+// Store 保存已处理的事件id
+type Store interface {
+	// Acquire 记录开始处理 记录不存在时以token写入并返回StateNew lease为处理中记录的过期时间
+	Acquire(consumer, eventId, token string, lease time.Duration) (State, error)
+	// Complete 记录处理完成 ttl为过期时间 记录不再属于token时返回ErrLeaseLost
+	Complete(consumer, eventId, token string, ttl time.Duration) error
+	// Release 处理失败时删除记录 之后的重新投递可以再次处理 只删除属于token的记录
+	Release(consumer, eventId, token string) error
+}
+
+// Inbox 幂等消费 同一个consumer对同一个事件id只会成功处理一次
+type Inbox struct {
+	Consumer string
+	TTL      time.Duration
+	Lease    time.Duration
+
+	store Store
+}
+
+// New consumer用于区分不同的消费者 同一个事件可以被不同的consumer各处理一次
+func New(store Store, consumer string) *Inbox {
+	return &Inbox{
+		Consumer: consumer,
+		TTL:      inboxTTL,
+		Lease:    inboxLease,
+		store:    store,
+	}
+}
+
+// Wrap 包装处理函数 重复的事件直接返回nil 让mq确认消息
+func (i *Inbox) Wrap(handler eventbus.EnvelopeHandler) eventbus.EnvelopeHandler {
+	return func(envelope *eventbus.Envelope, event interface{}) error {
+		return i.Handle(envelope.Id, func() error {
+			return handler(envelope, event)
+		})
+	}
+}
+
+// Handle 以eventId去重执行handler
+func (i *Inbox) Handle(eventId string, handler func() error) error {
+	if eventId == "" {
+		return errors.New("事件id不能为空")
+	}
+	token := snowflake.BaseNumberString()
+	state, err := i.store.Acquire(i.Consumer, eventId, token, i.Lease)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case StateDone:
+		config.Logger.Info("print-srv:inbox",
+			zap.String("consumer", i.Consumer),
+			zap.String("id", eventId),
+			zap.String("skip", "duplicate"),
+		)
+		return nil
+	case StateProcessing:
+		return ErrProcessing
+	}
+	if err := handler(); err != nil {
+		if e := i.store.Release(i.Consumer, eventId, token); e != nil {
+			config.Logger.Error("Error", zap.Error(e))
+		}
+		return err
+	}
+	return i.store.Complete(i.Consumer, eventId, token, i.TTL)
+}
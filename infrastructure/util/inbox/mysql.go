@@ -0,0 +1,95 @@
+package inbox
+
+import (
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/jinzhu/gorm"
+
+	"time"
+)
+
+// Record 已处理的事件
+type Record struct {
+	mysql.BaseModel
+	Consumer  string    `gorm:"column:consumer;type:varchar(64);unique_index:uk_inbox_consumer_event" json:"consumer"`
+	EventId   string    `gorm:"column:event_id;type:varchar(32);unique_index:uk_inbox_consumer_event" json:"event_id"`
+	State     State     `gorm:"column:state" json:"state"`
+	Token     string    `gorm:"column:token;type:varchar(32)" json:"token"` //占用记录的处理
+	ExpiresAt time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+}
+
+func (r *Record) TableName() string {
+	return "inbox"
+}
+
+// MysqlStore 使用mysql保存 过期记录需要定时调用Purge删除
+type MysqlStore struct {
+	db *gorm.DB
+}
+
+func NewMysqlStore(db *gorm.DB) *MysqlStore {
+	return &MysqlStore{
+		db: db,
+	}
+}
+
+// AutoMigrate 创建inbox表
+func (s *MysqlStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&Record{}).Error
+}
+
+func (s *MysqlStore) Acquire(consumer, eventId, token string, lease time.Duration) (State, error) {
+	now := time.Now()
+	//删除过期的记录后再写入
+	err := s.db.Where("consumer = ? AND event_id = ? AND expires_at <= ?", consumer, eventId, now).
+		Delete(&Record{}).Error
+	if err != nil {
+		return StateNew, err
+	}
+	result := s.db.Exec("INSERT IGNORE INTO inbox (consumer, event_id, state, token, expires_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		consumer, eventId, StateProcessing, token, now.Add(lease), now, now)
+	if result.Error != nil {
+		return StateNew, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return StateNew, nil
+	}
+	record := new(Record)
+	err = s.db.Where("consumer = ? AND event_id = ?", consumer, eventId).First(record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return StateProcessing, nil
+	}
+	if err != nil {
+		return StateNew, err
+	}
+	if record.State == StateDone {
+		return StateDone, nil
+	}
+	return StateProcessing, nil
+}
+
+func (s *MysqlStore) Complete(consumer, eventId, token string, ttl time.Duration) error {
+	result := s.db.Model(&Record{}).
+		Where("consumer = ? AND event_id = ? AND token = ? AND state = ?", consumer, eventId, token, StateProcessing).
+		Updates(map[string]interface{}{
+			"state":      StateDone,
+			"expires_at": time.Now().Add(ttl),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *MysqlStore) Release(consumer, eventId, token string) error {
+	return s.db.Where("consumer = ? AND event_id = ? AND token = ? AND state = ?", consumer, eventId, token, StateProcessing).
+		Delete(&Record{}).Error
+}
+
+// Purge 删除过期的记录
+func (s *MysqlStore) Purge() error {
+	return s.db.Where("expires_at <= ?", time.Now()).Delete(&Record{}).Error
+}
@@ -0,0 +1,110 @@
+package inbox
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+
+	"testing"
+	"time"
+)
+
+const (
+	sqlDeleteExpired = "DELETE FROM `inbox`  WHERE (consumer = ? AND event_id = ? AND expires_at <= ?)"
+	sqlInsert        = "INSERT IGNORE INTO inbox (consumer, event_id, state, token, expires_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	sqlSelect        = "SELECT * FROM `inbox`  WHERE (consumer = ? AND event_id = ?) ORDER BY `inbox`.`id` ASC LIMIT 1"
+	sqlComplete      = "UPDATE `inbox` SET `expires_at` = ?, `state` = ?, `updated_at` = ?  WHERE (consumer = ? AND event_id = ? AND token = ? AND state = ?)"
+	sqlRelease       = "DELETE FROM `inbox`  WHERE (consumer = ? AND event_id = ? AND token = ? AND state = ?)"
+)
+
+// mockStore 使用sqlmock检查执行的语句
+func mockStore(t *testing.T) (*MysqlStore, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("mysql", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return NewMysqlStore(db), mock
+}
+
+// expectAcquire 删除过期记录后写入 inserted为写入的行数
+func expectAcquire(mock sqlmock.Sqlmock, expired int64, token string, inserted int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlDeleteExpired).WithArgs("billing", "e1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, expired))
+	mock.ExpectCommit()
+	mock.ExpectExec(sqlInsert).
+		WithArgs("billing", "e1", StateProcessing, token, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, inserted))
+}
+
+func TestMysqlStoreAcquireDuplicate(t *testing.T) {
+	cases := []struct {
+		name   string
+		rows   *sqlmock.Rows
+		expect State
+	}{
+		{"done", sqlmock.NewRows([]string{"id", "state", "token"}).AddRow(1, StateDone, "a"), StateDone},
+		{"processing", sqlmock.NewRows([]string{"id", "state", "token"}).AddRow(1, StateProcessing, "a"), StateProcessing},
+		//写入失败后记录被删除 让mq稍后重新投递
+		{"released", sqlmock.NewRows([]string{"id", "state", "token"}), StateProcessing},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store, mock := mockStore(t)
+			expectAcquire(mock, 0, "b", 0)
+			mock.ExpectQuery(sqlSelect).WithArgs("billing", "e1").WillReturnRows(c.rows)
+			state, err := store.Acquire("billing", "e1", "b", time.Minute)
+			if err != nil || state != c.expect {
+				t.Fatalf("expected %d, got %d %v", c.expect, state, err)
+			}
+		})
+	}
+}
+
+func TestMysqlStoreStolenLeaseCannotComplete(t *testing.T) {
+	store, mock := mockStore(t)
+	expectAcquire(mock, 0, "a", 1)
+	//a的记录过期后被b删除并重新写入
+	expectAcquire(mock, 1, "b", 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlComplete).WithArgs(sqlmock.AnyArg(), StateDone, sqlmock.AnyArg(), "billing", "e1", "a", StateProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlComplete).WithArgs(sqlmock.AnyArg(), StateDone, sqlmock.AnyArg(), "billing", "e1", "b", StateProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if state, err := store.Acquire("billing", "e1", "a", time.Minute); err != nil || state != StateNew {
+		t.Fatalf("expected a to acquire, got %d %v", state, err)
+	}
+	if state, err := store.Acquire("billing", "e1", "b", time.Minute); err != nil || state != StateNew {
+		t.Fatalf("expected b to take the expired lease, got %d %v", state, err)
+	}
+	if err := store.Complete("billing", "e1", "a", time.Hour); err != ErrLeaseLost {
+		t.Fatalf("expected ErrLeaseLost for the stolen lease, got %v", err)
+	}
+	if err := store.Complete("billing", "e1", "b", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMysqlStoreReleaseOnlyOwnToken(t *testing.T) {
+	store, mock := mockStore(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlRelease).WithArgs("billing", "e1", "a", StateProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := store.Release("billing", "e1", "a"); err != nil {
+		t.Fatal(err)
+	}
+}
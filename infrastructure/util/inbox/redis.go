@@ -0,0 +1,70 @@
+package inbox
+
+import (
+	"DDD/infrastructure/util/redis"
+
+	"time"
+)
+
+const (
+	redisKeyPrefix  = "inbox:"
+	redisProcessing = "processing:" //后面是占用记录的token
+	redisDone       = "done"
+)
+
+// RedisStore 使用redis保存 过期由redis自动删除
+type RedisStore struct {
+}
+
+func NewRedisStore() *RedisStore {
+	return new(RedisStore)
+}
+
+func (s *RedisStore) key(consumer, eventId string) string {
+	return redisKeyPrefix + consumer + ":" + eventId
+}
+
+func (s *RedisStore) Acquire(consumer, eventId, token string, lease time.Duration) (State, error) {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	key := s.key(consumer, eventId)
+	ok, err := client.SetNX(key, redisProcessing+token, lease)
+	if err != nil {
+		return StateNew, err
+	}
+	if ok {
+		return StateNew, nil
+	}
+	value, err := client.Get(key)
+	if err == redis.ErrNil {
+		//刚好过期 让mq稍后重新投递
+		return StateProcessing, nil
+	}
+	if err != nil {
+		return StateNew, err
+	}
+	if value == redisDone {
+		return StateDone, nil
+	}
+	return StateProcessing, nil
+}
+
+func (s *RedisStore) Complete(consumer, eventId, token string, ttl time.Duration) error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	ok, err := client.CompareAndSet(s.key(consumer, eventId), redisProcessing+token, redisDone, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *RedisStore) Release(consumer, eventId, token string) error {
+	client := redis.NewClient(redis.Pool.Pool.Get())
+	defer client.Close()
+	_, err := client.CompareAndDel(s.key(consumer, eventId), redisProcessing+token)
+	return err
+}
@@ -0,0 +1,94 @@
+package inbox
+
+import (
+	"DDD/infrastructure/util/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/spf13/viper"
+
+	"testing"
+	"time"
+)
+
+// useRedis 启动miniredis并通过redis.Init连接
+func useRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	viper.Set("redis.addr", server.Addr())
+	t.Cleanup(func() {
+		viper.Set("redis.addr", nil)
+		if redis.Pool != nil {
+			redis.Pool.Close()
+			redis.Pool = nil
+		}
+	})
+	if err := redis.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestRedisStoreDuplicate(t *testing.T) {
+	useRedis(t)
+	store := NewRedisStore()
+	if state, err := store.Acquire("billing", "e1", "a", time.Minute); err != nil || state != StateNew {
+		t.Fatalf("expected a to acquire, got %d %v", state, err)
+	}
+	if state, _ := store.Acquire("billing", "e1", "b", time.Minute); state != StateProcessing {
+		t.Fatalf("expected processing while a holds the lease, got %d", state)
+	}
+	if err := store.Complete("billing", "e1", "a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := store.Acquire("billing", "e1", "b", time.Minute); state != StateDone {
+		t.Fatalf("expected done after complete, got %d", state)
+	}
+	//不同的consumer各处理一次
+	if state, _ := store.Acquire("shipping", "e1", "b", time.Minute); state != StateNew {
+		t.Fatalf("expected another consumer to acquire, got %d", state)
+	}
+}
+
+func TestRedisStoreStolenLeaseCannotComplete(t *testing.T) {
+	server := useRedis(t)
+	store := NewRedisStore()
+	store.Acquire("billing", "e1", "a", time.Minute)
+	server.FastForward(time.Minute)
+	if state, err := store.Acquire("billing", "e1", "b", time.Minute); err != nil || state != StateNew {
+		t.Fatalf("expected b to take the expired lease, got %d %v", state, err)
+	}
+	if err := store.Complete("billing", "e1", "a", time.Hour); err != ErrLeaseLost {
+		t.Fatalf("expected ErrLeaseLost for the stolen lease, got %v", err)
+	}
+	//a失败时也不能删除b的记录
+	if err := store.Release("billing", "e1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Complete("billing", "e1", "b", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL(store.key("billing", "e1")); ttl != time.Hour {
+		t.Fatalf("expected the done ttl, got %v", ttl)
+	}
+}
+
+func TestRedisStoreRelease(t *testing.T) {
+	server := useRedis(t)
+	store := NewRedisStore()
+	store.Acquire("billing", "e1", "a", time.Minute)
+	if err := store.Release("billing", "e1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists(store.key("billing", "e1")) {
+		t.Fatal("the released record should be deleted")
+	}
+	if state, _ := store.Acquire("billing", "e1", "b", time.Minute); state != StateNew {
+		t.Fatalf("expected a redelivery to acquire after release, got %d", state)
+	}
+	//已经完成的记录不会被删除
+	store.Complete("billing", "e1", "b", time.Hour)
+	store.Release("billing", "e1", "b")
+	if state, _ := store.Acquire("billing", "e1", "c", time.Minute); state != StateDone {
+		t.Fatalf("release should not remove a done record, got %d", state)
+	}
+}
@@ -0,0 +1,243 @@
+package migrate
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SourceGo  = "go"
+	SourceSql = "sql"
+)
+
+// Migration 一个版本的迁移 版本一般为创建时间 例如20261017120000
+type Migration struct {
+	Version int64
+	Name    string
+	Source  string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error //为nil时不能回滚
+}
+
+type registry struct {
+	migrations []*Migration
+	sync.Mutex
+}
+
+var registered = new(registry)
+
+// Register 注册Go函数的迁移 在init中调用
+func Register(version int64, name string, up, down func(tx *gorm.DB) error) {
+	registered.Lock()
+	defer registered.Unlock()
+	registered.migrations = append(registered.migrations, &Migration{
+		Version: version,
+		Name:    name,
+		Source:  SourceGo,
+		Up:      up,
+		Down:    down,
+	})
+}
+
+// AutoMigrate 创建或补充模型的表 用于Up
+func AutoMigrate(models ...interface{}) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.AutoMigrate(models...).Error
+	}
+}
+
+// DropTables 删除模型的表 用于Down
+func DropTables(models ...interface{}) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.DropTableIfExists(models...).Error
+	}
+}
+
+// Load 合并注册的Go迁移和dir中的sql文件 按版本排序
+// sql文件命名为<version>_<name>.up.sql和<version>_<name>.down.sql dir不存在时只返回Go迁移
+func Load(dir string) ([]*Migration, error) {
+	registered.Lock()
+	migrations := append([]*Migration(nil), registered.migrations...)
+	registered.Unlock()
+	files, err := loadSql(dir)
+	if err != nil {
+		return nil, err
+	}
+	migrations = append(migrations, files...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("迁移版本%d重复: %s %s", migrations[i].Version, migrations[i-1].Name, migrations[i].Name)
+		}
+	}
+	return migrations, nil
+}
+
+func loadSql(dir string) ([]*Migration, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	var migrations []*Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		version, title, err := parseFileName(strings.TrimSuffix(name, "."+direction+".sql"))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{
+				Version: version,
+				Name:    title,
+				Source:  SourceSql,
+			}
+			byVersion[version] = migration
+			migrations = append(migrations, migration)
+		}
+		if migration.Name != title {
+			return nil, fmt.Errorf("迁移版本%d重复: %s %s", version, migration.Name, title)
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		fn := execSql(string(content))
+		if direction == "up" {
+			migration.Up = fn
+		} else {
+			migration.Down = fn
+		}
+	}
+	for i := range migrations[:] {
+		if migrations[i].Up == nil {
+			return nil, fmt.Errorf("迁移%d_%s没有up文件", migrations[i].Version, migrations[i].Name)
+		}
+	}
+	return migrations, nil
+}
+
+// parseFileName 拆分<version>_<name>
+func parseFileName(name string) (int64, string, error) {
+	i := strings.Index(name, "_")
+	if i <= 0 {
+		return 0, "", fmt.Errorf("迁移文件%s需要以<version>_开头", name)
+	}
+	version, err := strconv.ParseInt(name[:i], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("迁移文件%s的版本不是数字", name)
+	}
+	return version, name[i+1:], nil
+}
+
+func execSql(content string) func(tx *gorm.DB) error {
+	statements := splitStatements(content)
+	return func(tx *gorm.DB) error {
+		for i := range statements[:] {
+			if err := tx.Exec(statements[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// splitStatements 按分号拆分sql 忽略引号和注释中的分号
+func splitStatements(content string) []string {
+	var statements []string
+	var b strings.Builder
+	flush := func() {
+		if statement := strings.TrimSpace(b.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		b.Reset()
+	}
+	runes := []rune(content)
+	var quote rune
+	lineComment, blockComment := false, false
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		var next rune
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		switch {
+		case lineComment:
+			if c == '\n' {
+				lineComment = false
+				b.WriteRune(c)
+			}
+		case blockComment:
+			if c == '*' && next == '/' {
+				blockComment = false
+				i++
+			}
+		case quote != 0:
+			b.WriteRune(c)
+			if c == '\\' && next != 0 {
+				b.WriteRune(next)
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			b.WriteRune(c)
+		case c == '#', c == '-' && next == '-':
+			lineComment = true
+		case c == '/' && next == '*':
+			blockComment = true
+			i++
+		case c == ';':
+			flush()
+		default:
+			b.WriteRune(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// Create 在dir中创建一对空的sql文件 版本为当前时间 返回创建的文件
+func Create(dir, name string) ([]string, error) {
+	if name == "" || strings.ContainsAny(name, `/\ `) {
+		return nil, fmt.Errorf("迁移名称%q不合法", name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, time.Now().Format("20060102150405")+"_"+name)
+	files := []string{base + ".up.sql", base + ".down.sql"}
+	for _, file := range files {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+	return files, nil
+}
@@ -0,0 +1,124 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"empty", " \n\t", nil},
+		{"single without semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"multiple", "CREATE TABLE a (id INT);\nINSERT INTO a VALUES (1);\n", []string{"CREATE TABLE a (id INT)", "INSERT INTO a VALUES (1)"}},
+		{"empty statements", ";;SELECT 1;;", []string{"SELECT 1"}},
+		{"single quote", "INSERT INTO a VALUES ('x;y');SELECT 1", []string{"INSERT INTO a VALUES ('x;y')", "SELECT 1"}},
+		{"double quote", `INSERT INTO a VALUES ("x;y");`, []string{`INSERT INTO a VALUES ("x;y")`}},
+		{"backtick", "CREATE TABLE `a;b` (`c;d` INT);", []string{"CREATE TABLE `a;b` (`c;d` INT)"}},
+		{"other quotes inside quote", `INSERT INTO a VALUES ('"x;` + "`" + `');SELECT 1`, []string{`INSERT INTO a VALUES ('"x;` + "`" + `')`, "SELECT 1"}},
+		{"backslash escaped quote", `INSERT INTO a VALUES ('it\'s;ok');SELECT 1`, []string{`INSERT INTO a VALUES ('it\'s;ok')`, "SELECT 1"}},
+		{"doubled quote", "INSERT INTO a VALUES ('it''s;ok');SELECT 1", []string{"INSERT INTO a VALUES ('it''s;ok')", "SELECT 1"}},
+		{"escaped backslash before quote", `INSERT INTO a VALUES ('x\\');SELECT 1`, []string{`INSERT INTO a VALUES ('x\\')`, "SELECT 1"}},
+		{"dash comment", "-- drop a; first\nSELECT 1; -- trailing; comment\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"hash comment", "# note;\nSELECT 1;", []string{"SELECT 1"}},
+		{"block comment", "/* a;\nb; */SELECT 1;/**/SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"comment markers inside quotes", "INSERT INTO a VALUES ('-- x;', '/* y; */', '#z;');", []string{"INSERT INTO a VALUES ('-- x;', '/* y; */', '#z;')"}},
+		{"only comments", "-- one;\n/* two; */\n# three;", nil},
+		{"multibyte", "INSERT INTO a VALUES ('订单;已支付');SELECT 1", []string{"INSERT INTO a VALUES ('订单;已支付')", "SELECT 1"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := splitStatements(c.content)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("splitStatements(%q)\n got %q\nwant %q", c.content, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseFileName(t *testing.T) {
+	cases := []struct {
+		name    string
+		version int64
+		title   string
+		err     bool
+	}{
+		{"20261017120000_create_order", 20261017120000, "create_order", false},
+		{"1_init", 1, "init", false},
+		{"2_", 2, "", false},
+		{"create_order", 0, "", true},
+		{"_create_order", 0, "", true},
+		{"20261017120000", 0, "", true},
+		{"2026-10-17_create_order", 0, "", true},
+		{"99999999999999999999_overflow", 0, "", true},
+		{"", 0, "", true},
+	}
+	for _, c := range cases {
+		version, title, err := parseFileName(c.name)
+		if (err != nil) != c.err {
+			t.Fatalf("parseFileName(%q): unexpected error %v", c.name, err)
+		}
+		if err == nil && (version != c.version || title != c.title) {
+			t.Fatalf("parseFileName(%q) = %d %q, want %d %q", c.name, version, title, c.version, c.title)
+		}
+	}
+}
+
+func TestLoadSqlFiles(t *testing.T) {
+	write := func(dir, name, content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	write(dir, "2_add_index.up.sql", "CREATE INDEX i ON a (id);")
+	write(dir, "1_init.up.sql", "CREATE TABLE a (id INT);")
+	write(dir, "1_init.down.sql", "DROP TABLE a;")
+	write(dir, "README.md", "not a migration")
+	migrations, err := loadSql(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(migrations))
+	}
+	for _, migration := range migrations {
+		if migration.Source != SourceSql || migration.Up == nil {
+			t.Fatalf("unexpected migration %+v", migration)
+		}
+		if (migration.Down != nil) != (migration.Version == 1) {
+			t.Fatalf("migration %d has the wrong down file", migration.Version)
+		}
+	}
+
+	bad := []struct {
+		files map[string]string
+		err   string
+	}{
+		{map[string]string{"init.up.sql": ""}, "<version>_"},
+		{map[string]string{"v1_init.up.sql": ""}, "不是数字"},
+		{map[string]string{"1_init.down.sql": ""}, "没有up文件"},
+		{map[string]string{"1_init.up.sql": "", "1_other.down.sql": ""}, "重复"},
+	}
+	for _, c := range bad {
+		dir := t.TempDir()
+		for name, content := range c.files {
+			write(dir, name, content)
+		}
+		if _, err := loadSql(dir); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%v: expected an error containing %q, got %v", c.files, c.err, err)
+		}
+	}
+
+	if migrations, err := loadSql(filepath.Join(dir, "missing")); err != nil || migrations != nil {
+		t.Fatalf("a missing dir should be ignored, got %v %v", migrations, err)
+	}
+}
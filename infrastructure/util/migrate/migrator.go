@@ -0,0 +1,206 @@
+package migrate
+
+import (
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/jinzhu/gorm"
+
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const migratorLockTimeout = 10 * time.Second
+
+// ErrLocked 其他实例正在迁移
+var ErrLocked = errors.New("其他实例正在迁移")
+
+// SchemaMigration schema_migrations表 每个已经执行的版本一行
+type SchemaMigration struct {
+	Version   int64     `gorm:"primary_key;AUTO_INCREMENT:false;column:version" json:"version"`
+	Name      string    `gorm:"column:name;type:varchar(255)" json:"name"`
+	AppliedAt time.Time `gorm:"column:applied_at" json:"applied_at"`
+}
+
+func (m *SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status 迁移的状态 Missing为已经执行但是找不到迁移
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Source    string     `json:"source"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Missing   bool       `json:"missing"`
+}
+
+// Migrator 执行迁移
+type Migrator struct {
+	LockTimeout time.Duration
+
+	db  *gorm.DB
+	dir string
+}
+
+// New dir为sql文件所在的目录
+func New(db *gorm.DB, dir string) *Migrator {
+	return &Migrator{
+		LockTimeout: migratorLockTimeout,
+		db:          db,
+		dir:         dir,
+	}
+}
+
+// Up 按版本顺序执行未执行的迁移 steps为0时全部执行 返回执行的迁移
+// 每个迁移和版本记录在同一个事务中 mysql的DDL会隐式提交 失败时需要手动处理已经执行的语句
+func (m *Migrator) Up(steps int) ([]*Migration, error) {
+	var done []*Migration
+	err := m.locked(func() error {
+		migrations, applied, err := m.load()
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if steps > 0 && len(done) >= steps {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := m.db.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					AppliedAt: time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("迁移%d_%s失败: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down 从最新的版本开始回滚 steps小于1时回滚一个 返回回滚的迁移
+func (m *Migrator) Down(steps int) ([]*Migration, error) {
+	if steps < 1 {
+		steps = 1
+	}
+	var done []*Migration
+	err := m.locked(func() error {
+		migrations, applied, err := m.load()
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("迁移%d_%s不能回滚", migration.Version, migration.Name)
+			}
+			err := m.db.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Down(tx); err != nil {
+					return err
+				}
+				return tx.Where("version = ?", migration.Version).Delete(&SchemaMigration{}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("回滚%d_%s失败: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status 所有迁移的状态 按版本排序 只读取 不创建schema_migrations表
+func (m *Migrator) Status() ([]Status, error) {
+	migrations, applied, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		s := Status{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Source:  migration.Source,
+		}
+		if row, ok := applied[migration.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &row.AppliedAt
+			delete(applied, migration.Version)
+		}
+		status = append(status, s)
+	}
+	for version := range applied {
+		row := applied[version]
+		status = append(status, Status{
+			Version:   row.Version,
+			Name:      row.Name,
+			Applied:   true,
+			AppliedAt: &row.AppliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+	return status, nil
+}
+
+// load 读取迁移和已经执行的版本
+func (m *Migrator) load() ([]*Migration, map[int64]SchemaMigration, error) {
+	migrations, err := Load(m.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows []SchemaMigration
+	//表不存在时没有执行过的迁移
+	if err := m.db.Order("version").Find(&rows).Error; err != nil && !mysql.IsNoSuchTable(err) {
+		return nil, nil, err
+	}
+	applied := make(map[int64]SchemaMigration, len(rows))
+	for i := range rows[:] {
+		applied[rows[i].Version] = rows[i]
+	}
+	return migrations, applied, nil
+}
+
+// locked 持有迁移锁时执行fn
+// 使用mysql的GET_LOCK 锁属于连接 所以单独占用一个连接直到fn结束
+func (m *Migrator) locked(fn func() error) error {
+	ctx := context.Background()
+	conn, err := m.db.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var got sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(CONCAT('schema_migrations:', DATABASE()), ?)", int(m.LockTimeout/time.Second)).Scan(&got)
+	if err != nil {
+		return err
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return ErrLocked
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK(CONCAT('schema_migrations:', DATABASE()))")
+	if err := m.db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return err
+	}
+	return fn()
+}
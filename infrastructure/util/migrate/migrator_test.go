@@ -0,0 +1,231 @@
+package migrate
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	getLockSql          = "SELECT GET_LOCK(CONCAT('schema_migrations:', DATABASE()), ?)"
+	releaseLockSql      = "SELECT RELEASE_LOCK(CONCAT('schema_migrations:', DATABASE()))"
+	createMigrationsSql = "CREATE TABLE `schema_migrations` (`version` bigint,`name` varchar(255),`applied_at` DATETIME NULL , PRIMARY KEY (`version`))"
+	selectMigrationsSql = "SELECT * FROM `schema_migrations`   ORDER BY `version`"
+	insertMigrationSql  = "INSERT INTO `schema_migrations` (`version`,`name`,`applied_at`) VALUES (?,?,?)"
+	deleteMigrationSql  = "DELETE FROM `schema_migrations`  WHERE (version = ?)"
+	showMigrationsSql   = "SHOW TABLES FROM `ddd` WHERE `Tables_in_ddd` = ?"
+	selectDatabaseSql   = "SELECT DATABASE()"
+	lockTimeoutSeconds  = 10
+)
+
+// mockDB 使用sqlmock检查迁移执行的语句
+func mockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("mysql", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// migrationsDir 创建sql迁移文件 文件名到内容
+func migrationsDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// expectLocked 获取迁移锁 创建schema_migrations表 读取已经执行的版本
+func expectLocked(mock sqlmock.Sqlmock, applied ...int64) {
+	mock.ExpectQuery(getLockSql).WithArgs(lockTimeoutSeconds).WillReturnRows(sqlmock.NewRows([]string{"got"}).AddRow(1))
+	mock.ExpectQuery(selectDatabaseSql).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("ddd"))
+	mock.ExpectQuery(showMigrationsSql).WithArgs("schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectExec(createMigrationsSql).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectApplied(mock, applied...)
+}
+
+func expectApplied(mock sqlmock.Sqlmock, applied ...int64) {
+	rows := sqlmock.NewRows([]string{"version", "name", "applied_at"})
+	for _, version := range applied {
+		rows.AddRow(version, fmt.Sprintf("m%d", version), time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery(selectMigrationsSql).WillReturnRows(rows)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(releaseLockSql).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func versions(migrations []*Migration) string {
+	var s []string
+	for _, migration := range migrations {
+		s = append(s, fmt.Sprintf("%d_%s", migration.Version, migration.Name))
+	}
+	return strings.Join(s, " ")
+}
+
+var threeMigrations = map[string]string{
+	"1_m1.up.sql":   "CREATE TABLE a (id INT)",
+	"1_m1.down.sql": "DROP TABLE a",
+	"2_m2.up.sql":   "CREATE INDEX i ON a (id)",
+	"2_m2.down.sql": "DROP INDEX i ON a",
+	"3_m3.up.sql":   "INSERT INTO a VALUES (1);INSERT INTO a VALUES (2)",
+}
+
+func TestMigratorUpRunsPendingInOrder(t *testing.T) {
+	db, mock := mockDB(t)
+	expectLocked(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE INDEX i ON a (id)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insertMigrationSql).WithArgs(2, "m2", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO a VALUES (1)").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO a VALUES (2)").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(insertMigrationSql).WithArgs(3, "m3", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	done, err := New(db, migrationsDir(t, threeMigrations)).Up(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); got != "2_m2 3_m3" {
+		t.Fatalf("expected the pending migrations in order, got %s", got)
+	}
+}
+
+func TestMigratorUpSteps(t *testing.T) {
+	db, mock := mockDB(t)
+	expectLocked(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE a (id INT)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insertMigrationSql).WithArgs(1, "m1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	done, err := New(db, migrationsDir(t, threeMigrations)).Up(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); got != "1_m1" {
+		t.Fatalf("expected only one migration, got %s", got)
+	}
+}
+
+func TestMigratorUpStopsAtFailure(t *testing.T) {
+	db, mock := mockDB(t)
+	cause := errors.New("syntax error")
+	expectLocked(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE INDEX i ON a (id)").WillReturnError(cause)
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	done, err := New(db, migrationsDir(t, threeMigrations)).Up(0)
+	if !errors.Is(err, cause) || !strings.Contains(err.Error(), "2_m2") {
+		t.Fatalf("expected the failing migration in the error, got %v", err)
+	}
+	if len(done) != 0 {
+		t.Fatalf("no migration should be reported as done, got %s", versions(done))
+	}
+}
+
+func TestMigratorDownRollsBackLatest(t *testing.T) {
+	db, mock := mockDB(t)
+	expectLocked(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP INDEX i ON a").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(deleteMigrationSql).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	done, err := New(db, migrationsDir(t, threeMigrations)).Down(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); got != "2_m2" {
+		t.Fatalf("expected the latest applied migration, got %s", got)
+	}
+}
+
+func TestMigratorDownWithoutDownFile(t *testing.T) {
+	db, mock := mockDB(t)
+	expectLocked(mock, 1, 2, 3)
+	expectUnlock(mock)
+
+	_, err := New(db, migrationsDir(t, threeMigrations)).Down(1)
+	if err == nil || !strings.Contains(err.Error(), "3_m3不能回滚") {
+		t.Fatalf("expected an error for a migration without down, got %v", err)
+	}
+}
+
+func TestMigratorLockedByOtherInstance(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectQuery(getLockSql).WithArgs(lockTimeoutSeconds).WillReturnRows(sqlmock.NewRows([]string{"got"}).AddRow(0))
+
+	if _, err := New(db, migrationsDir(t, threeMigrations)).Up(0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+}
+
+func TestMigratorStatus(t *testing.T) {
+	db, mock := mockDB(t)
+	//只读取 不获取锁也不创建表
+	expectApplied(mock, 1, 9)
+
+	status, err := New(db, migrationsDir(t, threeMigrations)).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range status {
+		got = append(got, fmt.Sprintf("%d:%v:%v", s.Version, s.Applied, s.Missing))
+	}
+	if strings.Join(got, " ") != "1:true:false 2:false:false 3:false:false 9:true:true" {
+		t.Fatalf("unexpected status %v", got)
+	}
+	if status[0].AppliedAt == nil || status[0].Source != SourceSql || status[3].Name != "m9" {
+		t.Fatalf("unexpected status %+v %+v", status[0], status[3])
+	}
+}
+
+func TestMigratorStatusWithoutTable(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectQuery(selectMigrationsSql).WillReturnError(&mysqldriver.MySQLError{Number: 1146, Message: "Table 'ddd.schema_migrations' doesn't exist"})
+
+	status, err := New(db, migrationsDir(t, threeMigrations)).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 {
+		t.Fatalf("expected 3 migrations, got %+v", status)
+	}
+	for _, s := range status {
+		if s.Applied {
+			t.Fatalf("nothing should be applied without the table, got %+v", s)
+		}
+	}
+}
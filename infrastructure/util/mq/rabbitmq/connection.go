@@ -0,0 +1,120 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+
+	"time"
+)
+
+// connection is the part of amqp.Connection we use, tests replace it with an in-process stand-in
+type connection interface {
+	Channel() (channel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// channel is the part of amqp.Channel we use
+type channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	Close() error
+}
+
+type dialer func(url string) (connection, error)
+
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (c *amqpConnection) Channel() (channel, error) {
+	ch, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func dial(url string) (connection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return &amqpConnection{conn}, nil
+}
+
+// pooledChannel is a pooled channel, generation tells which connection it was opened on
+// confirms and returns are only set when the channel is in confirm mode
+type pooledChannel struct {
+	channel
+	generation uint64
+	closeChann chan *amqp.Error
+	confirms   chan amqp.Confirmation
+	returns    chan amqp.Return
+}
+
+// closed reports whether the channel was closed by the broker or the client
+func (pc *pooledChannel) closed() bool {
+	select {
+	case <-pc.closeChann:
+		return true
+	default:
+		return false
+	}
+}
+
+// confirm puts the channel into confirm mode and listens for acks and returns
+func (pc *pooledChannel) confirm() error {
+	if err := pc.Confirm(false); err != nil {
+		return err
+	}
+	pc.confirms = pc.NotifyPublish(make(chan amqp.Confirmation, 1))
+	pc.returns = pc.NotifyReturn(make(chan amqp.Return, 1))
+	return nil
+}
+
+// wait blocks until the broker confirms the last publishing
+// the broker sends basic.return before basic.ack for an unroutable mandatory message
+func (pc *pooledChannel) wait(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	returns := pc.returns
+	var returned *ReturnError
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			returned = newReturnError(r)
+		case c, ok := <-pc.confirms:
+			if !ok {
+				return amqp.ErrClosed
+			}
+			if !c.Ack {
+				return ErrNack
+			}
+			if returned != nil {
+				return returned
+			}
+			select {
+			case r, ok := <-returns:
+				if ok {
+					return newReturnError(r)
+				}
+			default:
+			}
+			return nil
+		case <-timer.C:
+			return ErrConfirmTimeout
+		}
+	}
+}
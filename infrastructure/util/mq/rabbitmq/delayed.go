@@ -1,91 +1,250 @@
 package rabbitmq
 
 import (
-	"dhb168.com/x-store/print-srv/infrastructure/config"
+	"DDD/infrastructure/config/config"
 
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	channelPoolSize       = 10              // max idle channels kept in the pool
+	defaultConfirmTimeout = 5 * time.Second // how long a publish waits for the broker ack in confirm mode
+)
+
+// reconnectInterval is how long handleDisconnect waits between reconnect attempts
+var reconnectInterval = 5 * time.Second
+
+var (
+	// ErrClosed means Shutdown has been called
+	ErrClosed = errors.New("rabbitmq connection is shut down")
+	// ErrNotConnected means Load has not succeeded yet
+	ErrNotConnected = errors.New("rabbitmq is not connected")
+	// ErrNack means the broker refused the message in confirm mode
+	ErrNack = errors.New("rabbitmq nacked the message")
+	// ErrConfirmTimeout means the broker did not confirm the message in time
+	ErrConfirmTimeout = errors.New("rabbitmq publish confirm timed out")
+)
+
+// ReturnError is returned when a mandatory message could not be routed to any queue
+type ReturnError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+}
+
+func newReturnError(r amqp.Return) *ReturnError {
+	return &ReturnError{
+		Exchange:   r.Exchange,
+		RoutingKey: r.RoutingKey,
+		ReplyCode:  r.ReplyCode,
+		ReplyText:  r.ReplyText,
+	}
+}
+
+func (e *ReturnError) Error() string {
+	return fmt.Sprintf("rabbitmq returned the message: exchange %q, routing key %q, %d %s",
+		e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
 // RabbitMQ stores rabbitmq's connection information
-// it also handles disconnection (purpose of URL and QueueName storage)
+// it keeps one long-lived connection with a pool of channels
+// and reconnects when the connection is closed by an error
+//
+// With Confirm set every publish waits up to ConfirmTimeout for the broker ack,
+// with Mandatory set an unroutable message is reported as *ReturnError.
+// Mandatory does not cover delayed messages: the x-delayed-message exchange
+// routes them only when the delay expires, after the publish has returned,
+// and the broker drops a delayed message that has no bound queue at that time.
 type RabbitMQ struct {
-	URL        string
-	Exchange   string
-	Conn       *amqp.Connection
-	Chann      *amqp.Channel
-	Queue      amqp.Queue
-	closeChann chan *amqp.Error
-	quitChann  chan bool
+	URL            string
+	Exchange       string
+	Confirm        bool
+	Mandatory      bool
+	ConfirmTimeout time.Duration
+	dial           dialer
+	conn           connection
+	generation     uint64
+	channels       chan *pooledChannel
+	closeChann     chan *amqp.Error
+	quitChann      chan struct{}
+	quitOnce       sync.Once
+	closed         bool
+	wg             sync.WaitGroup
+	mu             sync.RWMutex
+}
+
+// New new
+func New(url, exchange string) *RabbitMQ {
+	return &RabbitMQ{
+		URL:      url,
+		Exchange: exchange,
+	}
 }
 
+// Load dials the connection, declares the exchange and starts handleDisconnect
 func (rmq *RabbitMQ) Load() error {
-	var err error
+	rmq.mu.Lock()
+	if rmq.closed {
+		rmq.mu.Unlock()
+		return ErrClosed
+	}
+	if rmq.conn != nil {
+		rmq.mu.Unlock()
+		return nil
+	}
+	if rmq.dial == nil {
+		rmq.dial = dial
+	}
+	if rmq.channels == nil {
+		rmq.channels = make(chan *pooledChannel, channelPoolSize)
+	}
+	if rmq.quitChann == nil {
+		rmq.quitChann = make(chan struct{})
+	}
+	rmq.mu.Unlock()
 
-	rmq.Conn, err = amqp.Dial(rmq.URL)
-	if err != nil {
-		config.Logger.Error("Error", zap.Error(err))
+	if err := rmq.connect(); err != nil {
 		return err
 	}
+	if rmq.Exchange != "" {
+		if err := rmq.ExchangeDeclare(rmq.Exchange); err != nil {
+			return err
+		}
+	}
+	rmq.wg.Add(1)
+	go rmq.handleDisconnect()
+	return nil
+}
 
-	rmq.Chann, err = rmq.Conn.Channel()
+// connect dials a new connection and drops the channels of the old one
+func (rmq *RabbitMQ) connect() error {
+	conn, err := rmq.dial(rmq.URL)
 	if err != nil {
 		config.Logger.Error("Error", zap.Error(err))
 		return err
 	}
+	closeChann := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	rmq.mu.Lock()
+	defer rmq.mu.Unlock()
+	if rmq.closed {
+		conn.Close()
+		return ErrClosed
+	}
+	rmq.conn = conn
+	rmq.closeChann = closeChann
+	rmq.generation++
+	rmq.drain()
+	return nil
+}
 
-	rmq.closeChann = make(chan *amqp.Error)
-	rmq.Conn.NotifyClose(rmq.closeChann)
+// drain closes the pooled channels, the caller must hold the lock
+func (rmq *RabbitMQ) drain() {
+	for {
+		select {
+		case pc := <-rmq.channels:
+			pc.Close()
+		default:
+			return
+		}
+	}
+}
 
-	// declare exchange if not exist
-	//err = rmq.Chann.ExchangeDeclare(rmq.Exchange, "direct", true, false, false, false, nil)
-	//if err != nil {
-	//	return errors.Wrapf(err, "declaring exchange %q", rmq.Exchange)
-	//}
-	exchange := strings.ToLower(rmq.Exchange)
+// ExchangeDeclare declares a x-delayed-message exchange if not exist
+func (rmq *RabbitMQ) ExchangeDeclare(exchange string) error {
+	pc, err := rmq.getChannel()
+	if err != nil {
+		return err
+	}
 	args := make(amqp.Table)
 	args["x-delayed-type"] = "direct"
-	err = rmq.Chann.ExchangeDeclare(exchange, "x-delayed-message", true, false, false, false, args)
+	err = pc.ExchangeDeclare(strings.ToLower(exchange), "x-delayed-message", true, false, false, false, args)
+	rmq.putChannel(pc, err)
 	if err != nil {
 		config.Logger.Error("Error", zap.Error(err))
 		return err
 	}
+	return nil
+}
 
-	//err = declareConsumer(rmq)
-	//if err != nil {
-	//	return err
-	//}
+// consumerSuffix is appended to the queue name to build the consumer tag
+const consumerSuffix = "_consumer"
 
+// QueueOf returns the queue a delivery was consumed from by DeclareAndConsumer
+func QueueOf(delivery amqp.Delivery) string {
+	return strings.TrimSuffix(delivery.ConsumerTag, consumerSuffix)
+}
+
+// QueueDeclare declares a durable queue if not exist
+func (rmq *RabbitMQ) QueueDeclare(queueName string) error {
+	pc, err := rmq.getChannel()
+	if err != nil {
+		return err
+	}
+	_, err = pc.QueueDeclare(strings.ToLower(queueName), true, false, false, false, nil)
+	rmq.putChannel(pc, err)
+	if err != nil {
+		config.Logger.Error("Error", zap.Error(err))
+		return err
+	}
 	return nil
 }
 
+// DeclareAndConsumer declares the queue, binds it to the exchange and starts consuming on a dedicated channel
+// the queue is also bound with its own name, so a retry published with the queue name reaches this queue only
+// the deliveries channel is closed when the connection drops, call it again after reconnecting
 func (rmq *RabbitMQ) DeclareAndConsumer(queueName, routeKey string) (ds <-chan amqp.Delivery, err error) {
 	queueName = strings.ToLower(queueName)
 	routeKey = strings.ToLower(routeKey)
-	delayedQueue, err := rmq.Chann.QueueDeclare(queueName, true, false, false, false, nil)
+	rmq.mu.RLock()
+	conn := rmq.conn
+	closed := rmq.closed
+	rmq.mu.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+	ch, err := conn.Channel()
 	if err != nil {
 		config.Logger.Error("Error", zap.Error(err))
 		return nil, err
 	}
-	err = rmq.Chann.QueueBind(delayedQueue.Name, routeKey, rmq.Exchange, false, nil)
+	delayedQueue, err := ch.QueueDeclare(queueName, true, false, false, false, nil)
 	if err != nil {
 		config.Logger.Error("Error", zap.Error(err))
+		ch.Close()
 		return nil, err
 	}
+	for _, key := range []string{routeKey, delayedQueue.Name} {
+		err = ch.QueueBind(delayedQueue.Name, key, strings.ToLower(rmq.Exchange), false, nil)
+		if err != nil {
+			config.Logger.Error("Error", zap.Error(err))
+			ch.Close()
+			return nil, err
+		}
+	}
 
 	// Set our quality of service.  Since we're sharing 3 consumers on the same
 	// channel, we want at least 2 messages in flight.
-	err = rmq.Chann.Qos(2, 0, false)
+	err = ch.Qos(2, 0, false)
 	if err != nil {
+		ch.Close()
 		return nil, err
 	}
 
-	published, err := rmq.Chann.Consume(
+	published, err := ch.Consume(
 		delayedQueue.Name,
-		delayedQueue.Name+"_consumer",
+		delayedQueue.Name+consumerSuffix,
 		false,
 		false,
 		false,
@@ -93,98 +252,134 @@ func (rmq *RabbitMQ) DeclareAndConsumer(queueName, routeKey string) (ds <-chan a
 		nil)
 	if err != nil {
 		config.Logger.Error("Error", zap.Error(err))
+		ch.Close()
 		return nil, err
 	}
 	return published, err
 }
 
-// declareConsumer declares all queues and bindings for the consumer
-func declareConsumer(rmq *RabbitMQ) error {
-	var err error
-
-	// rmq.Queue, err = rmq.Chann.QueueDeclare("user-created-queue", true, false, false, false, nil)
-	// if err != nil {
-	// 	return err
-	// }
-	// err = rmq.Chann.QueueBind(rmq.Queue.Name, "user.event.create", rmq.Exchange, false, nil)
-	// if err != nil {
-	// 	return err
-	// }
-
-	delayedQueue, err := rmq.Chann.QueueDeclare("user-published-queue", true, false, false, false, nil)
-	if err != nil {
-		return err
+// getChannel takes an idle channel from the pool or opens a new one
+func (rmq *RabbitMQ) getChannel() (*pooledChannel, error) {
+	rmq.mu.RLock()
+	conn := rmq.conn
+	generation := rmq.generation
+	closed := rmq.closed
+	rmq.mu.RUnlock()
+	if closed {
+		return nil, ErrClosed
 	}
-	err = rmq.Chann.QueueBind(delayedQueue.Name, "user.event.publish", "delayed", false, nil)
-	if err != nil {
-		return err
+	if conn == nil {
+		return nil, ErrNotConnected
 	}
-
-	// Set our quality of service.  Since we're sharing 3 consumers on the same
-	// channel, we want at least 2 messages in flight.
-	err = rmq.Chann.Qos(2, 0, false)
-	if err != nil {
-		return err
+	for {
+		var pc *pooledChannel
+		select {
+		case pc = <-rmq.channels:
+		default:
+		}
+		if pc == nil {
+			break
+		}
+		if pc.generation == generation && !pc.closed() {
+			return pc, nil
+		}
+		pc.Close()
 	}
-
-	published, err := rmq.Chann.Consume(
-		"user-published-queue",
-		"user-published-consumer",
-		false,
-		false,
-		false,
-		false,
-		nil)
+	ch, err := conn.Channel()
 	if err != nil {
-		return err
+		config.Logger.Error("Error", zap.Error(err))
+		return nil, err
 	}
-
-	go consume(published)
-
-	return nil
+	pc := &pooledChannel{
+		channel:    ch,
+		generation: generation,
+		closeChann: ch.NotifyClose(make(chan *amqp.Error, 1)),
+	}
+	if rmq.Confirm {
+		if err := pc.confirm(); err != nil {
+			config.Logger.Error("Error", zap.Error(err))
+			pc.Close()
+			return nil, err
+		}
+	}
+	return pc, nil
 }
 
-func consume(ds <-chan amqp.Delivery) {
-	for {
-
-		select {
-		case d, ok := <-ds:
-			if !ok {
-				return
-			}
-			d.Ack(false)
-		}
+// putChannel returns the channel to the pool, channels that failed or belong to an old connection are closed
+func (rmq *RabbitMQ) putChannel(pc *pooledChannel, err error) {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
+	if err != nil || rmq.closed || pc.generation != rmq.generation || pc.closed() {
+		pc.Close()
+		return
+	}
+	select {
+	case rmq.channels <- pc:
+	default:
+		pc.Close()
 	}
 }
 
 // Shutdown closes rabbitmq's connection
+// it is safe to call more than once or before Load
 func (rmq *RabbitMQ) Shutdown() {
-	rmq.quitChann <- true
-
-	<-rmq.quitChann
-}
+	rmq.mu.Lock()
+	if rmq.closed {
+		rmq.mu.Unlock()
+		return
+	}
+	rmq.closed = true
+	if rmq.channels != nil {
+		rmq.drain()
+	}
+	if rmq.conn != nil {
+		rmq.conn.Close()
+	}
+	rmq.mu.Unlock()
 
-func (rmq *RabbitMQ) Destroy() {
-	rmq.Chann.Close()
-	rmq.Conn.Close()
+	rmq.quitOnce.Do(func() {
+		if rmq.quitChann != nil {
+			close(rmq.quitChann)
+		}
+	})
+	rmq.wg.Wait()
 }
 
 // handleDisconnect handle a disconnection trying to reconnect every 5 seconds
 func (rmq *RabbitMQ) handleDisconnect() {
+	defer rmq.wg.Done()
 	for {
+		rmq.mu.RLock()
+		closeChann := rmq.closeChann
+		rmq.mu.RUnlock()
 		select {
-		case errChann := <-rmq.closeChann:
-			if errChann != nil {
+		case errChann := <-closeChann:
+			if errChann == nil {
+				// graceful close by Shutdown
+				return
 			}
+			config.Logger.Error("rabbitmq connection closed",
+				zap.String("url", rmq.URL),
+				zap.Error(errChann),
+			)
 		case <-rmq.quitChann:
-			rmq.Conn.Close()
-			rmq.quitChann <- true
 			return
 		}
 
-		time.Sleep(5 * time.Second)
-
-		if err := rmq.Load(); err != nil {
+		for {
+			select {
+			case <-rmq.quitChann:
+				return
+			case <-time.After(reconnectInterval):
+			}
+			if err := rmq.connect(); err != nil {
+				if err == ErrClosed {
+					return
+				}
+				continue
+			}
+			config.Logger.Info("rabbitmq reconnected", zap.String("url", rmq.URL))
+			break
 		}
 	}
 }
@@ -199,18 +394,67 @@ func (rmq *RabbitMQ) PublishWithDelay(routingKey string, body []byte, delay int6
 	return rmq.publish(rmq.Exchange, routingKey, body, delay)
 }
 
+// Message is a publishing with its delay and headers
+type Message struct {
+	Body        []byte
+	ContentType string
+	Headers     amqp.Table
+	Delay       time.Duration
+}
+
 func (rmq *RabbitMQ) publish(exchange string, routingKey string, body []byte, delay int64) error {
+	return rmq.PublishMessage(exchange, routingKey, Message{
+		Body:  body,
+		Delay: time.Duration(delay) * time.Second,
+	})
+}
+
+// PublishMessage sends the given message on the routingKey to the exchange
+// A message with a Delay is published without mandatory, see RabbitMQ.
+func (rmq *RabbitMQ) PublishMessage(exchange string, routingKey string, msg Message) error {
 	headers := make(amqp.Table)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
 	routingKey = strings.ToLower(routingKey)
 	exchange = strings.ToLower(exchange)
-	if delay != 0 {
-		headers["x-delay"] = delay * 1000
+	if msg.Delay != 0 {
+		headers["x-delay"] = int64(msg.Delay / time.Millisecond)
 	}
-	return rmq.Chann.Publish(exchange, routingKey, false, false, amqp.Publishing{
+	contentType := msg.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	// the delayed message exchange holds the message before routing it,
+	// so the broker would return every delayed message as unroutable
+	// and cannot report the routing that happens when the delay expires
+	mandatory := rmq.Mandatory && msg.Delay == 0
+	pc, err := rmq.getChannel()
+	if err != nil {
+		return err
+	}
+	err = pc.Publish(exchange, routingKey, mandatory, false, amqp.Publishing{
 		DeliveryMode: amqp.Persistent,
 		Timestamp:    time.Now(),
-		ContentType:  "application/json",
-		Body:         body,
+		ContentType:  contentType,
+		Body:         msg.Body,
 		Headers:      headers,
 	})
+	if err == nil && rmq.Confirm {
+		err = pc.wait(rmq.confirmTimeout())
+	}
+	// a returned message leaves the channel usable
+	if _, ok := err.(*ReturnError); ok {
+		rmq.putChannel(pc, nil)
+	} else {
+		rmq.putChannel(pc, err)
+	}
+	return err
+}
+
+func (rmq *RabbitMQ) confirmTimeout() time.Duration {
+	if rmq.ConfirmTimeout > 0 {
+		return rmq.ConfirmTimeout
+	}
+	return defaultConfirmTimeout
 }
@@ -0,0 +1,500 @@
+package rabbitmq
+
+import (
+	"github.com/streadway/amqp"
+
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroker is an in-process stand-in for the amqp server
+type fakeBroker struct {
+	mu          sync.Mutex
+	dials       int
+	dialErr     error
+	conns       []*fakeConnection
+	published   []fakePublishing
+	exchanges   map[string]bool
+	channelOpen int
+	routes      map[string]bool // routing keys that have a queue bound
+	nack        bool
+	noConfirm   bool
+}
+
+type fakePublishing struct {
+	exchange  string
+	key       string
+	mandatory bool
+	msg       amqp.Publishing
+}
+
+type fakeConnection struct {
+	broker *fakeBroker
+	closes []chan *amqp.Error
+	closed bool
+}
+
+type fakeChannel struct {
+	broker   *fakeBroker
+	closes   []chan *amqp.Error
+	closed   bool
+	confirm  bool
+	tag      uint64
+	confirms []chan amqp.Confirmation
+	returns  []chan amqp.Return
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{exchanges: make(map[string]bool), routes: make(map[string]bool)}
+}
+
+func (b *fakeBroker) dial(url string) (connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dials++
+	if b.dialErr != nil {
+		return nil, b.dialErr
+	}
+	c := &fakeConnection{broker: b}
+	b.conns = append(b.conns, c)
+	return c, nil
+}
+
+// drop closes the latest connection with an error, like a broker restart
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	c := b.conns[len(b.conns)-1]
+	b.mu.Unlock()
+	c.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "broker restart"})
+}
+
+func (b *fakeBroker) dialCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dials
+}
+
+func (b *fakeBroker) publishedCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.published)
+}
+
+func (b *fakeBroker) openedChannels() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.channelOpen
+}
+
+func (c *fakeConnection) Channel() (channel, error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	c.broker.channelOpen++
+	return &fakeChannel{broker: c.broker}, nil
+}
+
+func (c *fakeConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.closes = append(c.closes, receiver)
+	return receiver
+}
+
+func (c *fakeConnection) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+func (c *fakeConnection) shutdown(err *amqp.Error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, ch := range c.closes {
+		if err != nil {
+			ch <- err
+		}
+		close(ch)
+	}
+}
+
+func (ch *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	ch.broker.exchanges[name] = true
+	return nil
+}
+
+func (ch *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (ch *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
+
+func (ch *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return make(chan amqp.Delivery), nil
+}
+
+func (ch *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.broker.published = append(ch.broker.published, fakePublishing{exchange, key, mandatory, msg})
+	if !ch.confirm || ch.broker.noConfirm {
+		return nil
+	}
+	// like the broker, send basic.return before basic.ack
+	if mandatory && !ch.broker.routes[key] {
+		for _, c := range ch.returns {
+			c <- amqp.Return{ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE", Exchange: exchange, RoutingKey: key}
+		}
+	}
+	ch.tag++
+	for _, c := range ch.confirms {
+		c <- amqp.Confirmation{DeliveryTag: ch.tag, Ack: !ch.broker.nack}
+	}
+	return nil
+}
+
+func (ch *fakeChannel) Confirm(noWait bool) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	ch.confirm = true
+	return nil
+}
+
+func (ch *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	ch.confirms = append(ch.confirms, confirm)
+	return confirm
+}
+
+func (ch *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	ch.returns = append(ch.returns, c)
+	return c
+}
+
+func (ch *fakeChannel) NotifyClose(c chan *amqp.Error) chan *amqp.Error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	ch.closes = append(ch.closes, c)
+	return c
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	if ch.closed {
+		return nil
+	}
+	ch.closed = true
+	for _, c := range ch.closes {
+		close(c)
+	}
+	for _, c := range ch.confirms {
+		close(c)
+	}
+	for _, c := range ch.returns {
+		close(c)
+	}
+	return nil
+}
+
+func newTestRabbitMQ(b *fakeBroker, exchange string) *RabbitMQ {
+	rmq := New("amqp://fake", exchange)
+	rmq.dial = b.dial
+	return rmq
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLoadDeclaresExchange(t *testing.T) {
+	b := newFakeBroker()
+	rmq := newTestRabbitMQ(b, "Order:Delay:Exchange")
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.exchanges["order:delay:exchange"] {
+		t.Fatalf("exchange not declared: %v", b.exchanges)
+	}
+}
+
+func TestPublishReusesConnectionAndChannels(t *testing.T) {
+	b := newFakeBroker()
+	rmq := newTestRabbitMQ(b, "orders")
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := rmq.PublishWithDelay("Order.Created", []byte("{}"), 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.dialCount() != 1 {
+		t.Fatalf("dials = %d, want 1", b.dialCount())
+	}
+	if b.openedChannels() != 1 {
+		t.Fatalf("opened channels = %d, want 1", b.openedChannels())
+	}
+	if b.publishedCount() != 20 {
+		t.Fatalf("published = %d, want 20", b.publishedCount())
+	}
+	p := b.published[0]
+	if p.key != "order.created" || p.msg.Headers["x-delay"] != int64(3000) {
+		t.Fatalf("unexpected publishing %+v", p)
+	}
+}
+
+func TestConcurrentPublishBoundsPool(t *testing.T) {
+	b := newFakeBroker()
+	rmq := newTestRabbitMQ(b, "orders")
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rmq.Publish("k", []byte("{}")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if b.publishedCount() != 50 {
+		t.Fatalf("published = %d, want 50", b.publishedCount())
+	}
+	if n := len(rmq.channels); n > channelPoolSize {
+		t.Fatalf("pool holds %d channels, max %d", n, channelPoolSize)
+	}
+}
+
+func TestReconnectAfterConnectionFailure(t *testing.T) {
+	old := reconnectInterval
+	reconnectInterval = 10 * time.Millisecond
+	defer func() { reconnectInterval = old }()
+
+	b := newFakeBroker()
+	rmq := newTestRabbitMQ(b, "orders")
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rmq.Publish("k", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	b.mu.Lock()
+	b.dialErr = errors.New("connection refused")
+	b.mu.Unlock()
+	b.drop()
+	waitFor(t, func() bool { return b.dialCount() >= 3 })
+
+	b.mu.Lock()
+	b.dialErr = nil
+	b.mu.Unlock()
+	waitFor(t, func() bool {
+		return rmq.Publish("k", []byte("{}")) == nil
+	})
+	if b.publishedCount() != 2 {
+		t.Fatalf("published = %d, want 2", b.publishedCount())
+	}
+}
+
+func TestShutdownIsIdempotent(t *testing.T) {
+	b := newFakeBroker()
+	rmq := newTestRabbitMQ(b, "orders")
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	rmq.Shutdown()
+	rmq.Shutdown()
+	if err := rmq.Publish("k", []byte("{}")); err != ErrClosed {
+		t.Fatalf("err = %v, want ErrClosed", err)
+	}
+	if err := rmq.Load(); err != ErrClosed {
+		t.Fatalf("err = %v, want ErrClosed", err)
+	}
+}
+
+func TestShutdownBeforeLoad(t *testing.T) {
+	rmq := New("amqp://fake", "orders")
+	rmq.Shutdown()
+	if err := rmq.Publish("k", []byte("{}")); err != ErrClosed {
+		t.Fatalf("err = %v, want ErrClosed", err)
+	}
+}
+
+func newConfirmRabbitMQ(b *fakeBroker) *RabbitMQ {
+	rmq := newTestRabbitMQ(b, "orders")
+	rmq.Confirm = true
+	rmq.Mandatory = true
+	rmq.ConfirmTimeout = 50 * time.Millisecond
+	return rmq
+}
+
+func TestConfirmAck(t *testing.T) {
+	b := newFakeBroker()
+	b.routes["k"] = true
+	rmq := newConfirmRabbitMQ(b)
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := rmq.Publish("k", []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.openedChannels() != 1 {
+		t.Fatalf("opened channels = %d, want 1", b.openedChannels())
+	}
+}
+
+func TestConfirmNack(t *testing.T) {
+	b := newFakeBroker()
+	b.routes["k"] = true
+	b.nack = true
+	rmq := newConfirmRabbitMQ(b)
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rmq.Publish("k", []byte("{}")); err != ErrNack {
+		t.Fatalf("err = %v, want ErrNack", err)
+	}
+}
+
+func TestConfirmTimeoutDropsChannel(t *testing.T) {
+	b := newFakeBroker()
+	b.routes["k"] = true
+	rmq := newConfirmRabbitMQ(b)
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	b.noConfirm = true
+	b.mu.Unlock()
+	if err := rmq.Publish("k", []byte("{}")); err != ErrConfirmTimeout {
+		t.Fatalf("err = %v, want ErrConfirmTimeout", err)
+	}
+	b.mu.Lock()
+	b.noConfirm = false
+	b.mu.Unlock()
+	if err := rmq.Publish("k", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if b.openedChannels() != 2 {
+		t.Fatalf("opened channels = %d, want 2", b.openedChannels())
+	}
+}
+
+func TestMandatoryReturn(t *testing.T) {
+	b := newFakeBroker()
+	rmq := newConfirmRabbitMQ(b)
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	err := rmq.Publish("Nowhere", []byte("{}"))
+	r, ok := err.(*ReturnError)
+	if !ok {
+		t.Fatalf("err = %v, want *ReturnError", err)
+	}
+	if r.RoutingKey != "nowhere" || r.ReplyCode != amqp.NoRoute {
+		t.Fatalf("unexpected return %+v", r)
+	}
+	// the channel stays in the pool and the next confirm is not mixed up
+	b.routes["k"] = true
+	if err := rmq.Publish("k", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if b.openedChannels() != 1 {
+		t.Fatalf("opened channels = %d, want 1", b.openedChannels())
+	}
+}
+
+func TestDelayedPublishIsNotMandatory(t *testing.T) {
+	b := newFakeBroker()
+	rmq := newConfirmRabbitMQ(b)
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	// no queue is bound to k, a mandatory publish would be returned
+	if err := rmq.PublishWithDelay("k", []byte("{}"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := rmq.Publish("k", []byte("{}")); err == nil {
+		t.Fatal("an immediate publish should stay mandatory")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delayed, immediate := b.published[0], b.published[1]
+	if delayed.mandatory || delayed.msg.Headers["x-delay"] != int64(10000) {
+		t.Fatalf("delayed publish: mandatory %v, headers %v", delayed.mandatory, delayed.msg.Headers)
+	}
+	if !immediate.mandatory {
+		t.Fatal("immediate publish should be mandatory")
+	}
+}
+
+func TestPublishMessage(t *testing.T) {
+	b := newFakeBroker()
+	rmq := newTestRabbitMQ(b, "")
+	defer rmq.Shutdown()
+	if err := rmq.Load(); err != nil {
+		t.Fatal(err)
+	}
+	err := rmq.PublishMessage("Orders", "Created", Message{
+		Body:        []byte("<order/>"),
+		ContentType: "application/xml",
+		Headers:     amqp.Table{"key": "order-1"},
+		Delay:       1500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := b.published[0]
+	if p.exchange != "orders" || p.key != "created" {
+		t.Fatalf("unexpected route %q %q", p.exchange, p.key)
+	}
+	if p.msg.ContentType != "application/xml" {
+		t.Fatalf("content type = %q", p.msg.ContentType)
+	}
+	if p.msg.Headers["x-delay"] != int64(1500) || p.msg.Headers["key"] != "order-1" {
+		t.Fatalf("unexpected headers %v", p.msg.Headers)
+	}
+}
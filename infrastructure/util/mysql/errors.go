@@ -0,0 +1,24 @@
+package mysql
+
+import (
+	driver "github.com/go-sql-driver/mysql"
+
+	"errors"
+)
+
+const (
+	duplicateEntry = 1062
+	noSuchTable    = 1146
+)
+
+// IsDuplicateEntry 违反唯一索引
+func IsDuplicateEntry(err error) bool {
+	var e *driver.MySQLError
+	return errors.As(err, &e) && e.Number == duplicateEntry
+}
+
+// IsNoSuchTable 表不存在
+func IsNoSuchTable(err error) bool {
+	var e *driver.MySQLError
+	return errors.As(err, &e) && e.Number == noSuchTable
+}
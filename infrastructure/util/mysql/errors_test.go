@@ -0,0 +1,48 @@
+package mysql
+
+import (
+	driver "github.com/go-sql-driver/mysql"
+
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsDuplicateEntry(t *testing.T) {
+	duplicate := &driver.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"duplicate", duplicate, true},
+		{"wrapped", fmt.Errorf("save: %w", duplicate), true},
+		{"other mysql error", &driver.MySQLError{Number: 1213, Message: "Deadlock found"}, false},
+		{"other error", errors.New("Duplicate entry"), false},
+	}
+	for _, c := range cases {
+		if got := IsDuplicateEntry(c.err); got != c.want {
+			t.Fatalf("%s: IsDuplicateEntry = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsNoSuchTable(t *testing.T) {
+	noSuchTable := &driver.MySQLError{Number: 1146, Message: "Table 'ddd.schema_migrations' doesn't exist"}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"no such table", noSuchTable, true},
+		{"wrapped", fmt.Errorf("load: %w", noSuchTable), true},
+		{"other mysql error", &driver.MySQLError{Number: 1062, Message: "Duplicate entry"}, false},
+	}
+	for _, c := range cases {
+		if got := IsNoSuchTable(c.err); got != c.want {
+			t.Fatalf("%s: IsNoSuchTable = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
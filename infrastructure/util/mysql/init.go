@@ -1,89 +1,209 @@
 package mysql
 
 import (
-	log "DDD/infrastructure/config/config"
-	"fmt"
-	"github.com/spf13/viper"
-	"go.uber.org/zap"
-	"time"
-	// MySQL driver.
 	"github.com/jinzhu/gorm"
+	// MySQL driver.
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	"github.com/spf13/viper"
+
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
 )
 
-type Database struct {
-	DDD *gorm.DB
-	//Docker    *gorm.DB
+// DefaultName db配置的数据库在Databases中的名称
+const DefaultName = "ddd"
+
+// Config 数据库连接配置
+type Config struct {
+	Username        string
+	Password        string
+	Addr            string
+	Name            string
+	MaxOpenConns    int           // 用于设置最大打开的连接数，默认值为0表示不限制.设置最大的连接数，可以避免并发太高导致连接mysql出现too many connections的错误。
+	MaxIdleConns    int           // 用于设置闲置的连接数.设置闲置的连接数则当开启的一个连接使用完成后可以放在池里等候下一次使用。
+	ConnMaxLifetime time.Duration // 连接超时时间
+	LogMode         bool
+
+	Replicas        []Config      // 从库 为空时读写都使用主库
+	Balance         Balance       // 从库的负载均衡方式 默认轮询
+	StickyWindow    time.Duration // 会话写入后读取主库的时间
+	RecheckInterval time.Duration // 检查从库的间隔 摘除的从库恢复后重新加入
 }
 
-var DB *Database
+// ConfigFromViper 读取key下的配置 例如db databases.docker
+// replicas中没有配置的字段使用主库的配置
+func ConfigFromViper(key string) Config {
+	config := Config{
+		Username:        viper.GetString(key + ".username"),
+		Password:        viper.GetString(key + ".password"),
+		Addr:            viper.GetString(key + ".addr"),
+		Name:            viper.GetString(key + ".name"),
+		MaxOpenConns:    viper.GetInt(key + ".max_open_conns"),
+		MaxIdleConns:    viper.GetInt(key + ".wet_max_idle_conns"),
+		ConnMaxLifetime: time.Duration(viper.GetInt(key+".conn_max_lifetime")) * time.Second,
+		LogMode:         viper.GetBool("gormlog"),
+		Balance:         Balance(viper.GetString(key + ".balance")),
+		StickyWindow:    viper.GetDuration(key + ".sticky_window"),
+		RecheckInterval: viper.GetDuration(key + ".recheck_interval"),
+	}
+	var replicas []struct {
+		Addr     string
+		Username string
+		Password string
+		Name     string
+	}
+	if err := viper.UnmarshalKey(key+".replicas", &replicas); err == nil {
+		for i := range replicas[:] {
+			replica := config
+			replica.Replicas = nil
+			replica.Addr = replicas[i].Addr
+			if replicas[i].Username != "" {
+				replica.Username = replicas[i].Username
+				replica.Password = replicas[i].Password
+			}
+			if replicas[i].Name != "" {
+				replica.Name = replicas[i].Name
+			}
+			config.Replicas = append(config.Replicas, replica)
+		}
+	}
+	return config
+}
 
-func init() {
-	DB.Init()
+// Open 连接数据库 连接失败时返回错误
+func Open(config Config) (*gorm.DB, error) {
+	db, err := gorm.Open("mysql", dsn(config))
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库%s失败: %w", config.Name, err)
+	}
+	setup(db, config)
+	return db, nil
+}
+
+// openLazy 创建连接池但不检查连通 用于从库 连不上时由健康检查摘除
+func openLazy(config Config) (*gorm.DB, error) {
+	sqlDB, err := sql.Open("mysql", dsn(config))
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库%s失败: %w", config.Name, err)
+	}
+	//传入*sql.DB时ping失败也会返回可用的连接
+	db, _ := gorm.Open("mysql", sqlDB)
+	setup(db, config)
+	return db, nil
 }
 
-func openDB(username, password, addr, name string) *gorm.DB {
-	config := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=%t&loc=%s",
-		username,
-		password,
-		addr,
-		name,
+func dsn(config Config) string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=%t&loc=%s",
+		config.Username,
+		config.Password,
+		config.Addr,
+		config.Name,
 		true,
 		//"Asia/Shanghai"),
 		"Local")
+}
 
-	db, err := gorm.Open("mysql", config)
-	if err != nil {
-		log.Logger.Panic("Database connection failed. Database",
-			zap.String("name", name),
-		)
-	}
-
+func setup(db *gorm.DB, config Config) {
 	// set for db connection
-	setupDB(db)
-
-	return db
+	db.LogMode(config.LogMode)
+	db.DB().SetMaxOpenConns(config.MaxOpenConns)
+	db.DB().SetMaxIdleConns(config.MaxIdleConns)
+	db.DB().SetConnMaxLifetime(config.ConnMaxLifetime)
 }
 
-func setupDB(db *gorm.DB) {
-	db.LogMode(viper.GetBool("gormlog"))
-	db.DB().SetMaxOpenConns(viper.GetInt("db.max_open_conns"))     // 用于设置最大打开的连接数，默认值为0表示不限制.设置最大的连接数，可以避免并发太高导致连接mysql出现too many connections的错误。
-	db.DB().SetMaxIdleConns(viper.GetInt("db.wet_max_idle_conns")) // 用于设置闲置的连接数.设置闲置的连接数则当开启的一个连接使用完成后可以放在池里等候下一次使用。
-	t := time.Duration(viper.GetInt("db.conn_max_lifetime"))
-	db.DB().SetConnMaxLifetime(time.Second * t) // 连接超时时间
+// Database 主库和从库 DDD为主库
+type Database struct {
+	DDD *gorm.DB
+
+	replicas     *replicaSet
+	stickyWindow time.Duration
 }
 
-// used for cli
-func InitSelfDB() *gorm.DB {
-	return openDB(viper.GetString("db.username"),
-		viper.GetString("db.password"),
-		viper.GetString("db.addr"),
-		viper.GetString("db.name"))
+// DB Init之后可用
+var DB *Database
+
+// NewDatabase 连接主库和从库 用于注入到仓储等需要连接的地方
+func NewDatabase(config Config) (*Database, error) {
+	db, err := Open(config)
+	if err != nil {
+		return nil, err
+	}
+	database := &Database{
+		DDD:          db,
+		stickyWindow: config.StickyWindow,
+	}
+	if database.stickyWindow <= 0 {
+		database.stickyWindow = replicaStickyWindow
+	}
+	if len(config.Replicas) > 0 {
+		database.replicas, err = openReplicas(config.Replicas, config.Balance, config.RecheckInterval)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return database, nil
 }
 
-func GetSelfDB() *gorm.DB {
-	return InitSelfDB()
+// Reader 读取使用的连接 ctx中有事务或者会话刚写入过时使用主库 否则选择一个可用的从库
+func (db *Database) Reader(ctx context.Context) *gorm.DB {
+	if tx := DBFromContext(ctx, db.DDD); tx != db.DDD {
+		return tx
+	}
+	if db.replicas == nil || Pinned(ctx, db.stickyWindow) {
+		return db.DDD
+	}
+	if r := db.replicas.pick(); r != nil {
+		return r.db
+	}
+	return db.DDD
 }
 
-func InitDockerDB() *gorm.DB {
-	return openDB(viper.GetString("docker_db.username"),
-		viper.GetString("docker_db.password"),
-		viper.GetString("docker_db.addr"),
-		viper.GetString("docker_db.name"))
+// Writer 写入使用的连接 ctx中有事务时使用事务 并记录会话写入过主库
+func (db *Database) Writer(ctx context.Context) *gorm.DB {
+	MarkWrite(ctx)
+	return DBFromContext(ctx, db.DDD)
 }
 
-func GetDockerDB() *gorm.DB {
-	return InitDockerDB()
+// ReplicaStats 从库的状态
+func (db *Database) ReplicaStats() []ReplicaStats {
+	if db.replicas == nil {
+		return nil
+	}
+	return db.replicas.stats()
 }
 
-func (db *Database) Init() {
-	DB = &Database{
-		DDD: GetSelfDB(),
-		//Docker: GetDockerDB(),
+// Init 连接db配置的数据库和databases下配置的其他数据库 在main中调用
+//
+//	databases:
+//	  docker:
+//	    name: docker
+//	    addr: 127.0.0.1:3306
+func Init() error {
+	database, err := NewDatabase(ConfigFromViper("db"))
+	if err != nil {
+		return err
+	}
+	if err := Databases.Register(DefaultName, database); err != nil {
+		database.Close()
+		return err
 	}
+	for name := range viper.GetStringMap("databases") {
+		if _, err := Databases.Open(name, ConfigFromViper("databases."+name)); err != nil {
+			Databases.Close()
+			return err
+		}
+	}
+	DB = database
+	return nil
 }
 
-func (db *Database) Close() {
-	DB.DDD.Close()
-	//DB.Docker.Close()
+// Close 关闭主库和从库
+func (db *Database) Close() error {
+	if db.replicas != nil {
+		db.replicas.close()
+	}
+	return db.DDD.Close()
 }
@@ -9,3 +9,12 @@ type BaseModel struct {
 	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
 	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
 }
+
+// EntityId 仓储使用的id
+func (m *BaseModel) EntityId() uint64 {
+	return m.Id
+}
+
+func (m *BaseModel) SetEntityId(id uint64) {
+	m.Id = id
+}
@@ -0,0 +1,88 @@
+package mysql
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry 按名称保存多个数据库连接
+type Registry struct {
+	dbs map[string]*Database
+	sync.RWMutex
+}
+
+// Databases Init连接的所有数据库
+var Databases = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		dbs: make(map[string]*Database),
+	}
+}
+
+// Register 保存连接 名称已经存在时返回错误
+func (r *Registry) Register(name string, db *Database) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.dbs[name]; ok {
+		return fmt.Errorf("数据库%s已经注册", name)
+	}
+	r.dbs[name] = db
+	return nil
+}
+
+// Open 连接数据库并保存
+func (r *Registry) Open(name string, config Config) (*Database, error) {
+	db, err := NewDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Register(name, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Get 读取连接 没有注册时返回错误
+func (r *Registry) Get(name string) (*Database, error) {
+	r.RLock()
+	defer r.RUnlock()
+	db, ok := r.dbs[name]
+	if !ok {
+		return nil, fmt.Errorf("数据库%s没有注册", name)
+	}
+	return db, nil
+}
+
+// Names 已经注册的名称
+func (r *Registry) Names() []string {
+	r.RLock()
+	defer r.RUnlock()
+	names := make([]string, 0, len(r.dbs))
+	for name := range r.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close 关闭并删除所有连接 返回第一个错误
+func (r *Registry) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	var first error
+	for name, db := range r.dbs {
+		if err := db.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(r.dbs, name)
+	}
+	return first
+}
+
+// Get 读取Databases中的连接
+func Get(name string) (*Database, error) {
+	return Databases.Get(name)
+}
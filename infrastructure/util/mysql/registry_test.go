@@ -0,0 +1,103 @@
+package mysql
+
+import (
+	"github.com/spf13/viper"
+
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockDatabase 只有主库的Database Close时检查连接被关闭
+func mockDatabase(t *testing.T) *Database {
+	db, mock := mockDB(t)
+	mock.ExpectClose()
+	return &Database{DDD: db}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	orders, users := mockDatabase(t), mockDatabase(t)
+	if err := registry.Register("orders", orders); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("users", users); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("orders", users); err == nil {
+		t.Fatal("registering a name twice should fail")
+	}
+	if db, err := registry.Get("orders"); err != nil || db != orders {
+		t.Fatalf("expected the orders database, got %v %v", db, err)
+	}
+	if _, err := registry.Get("missing"); err == nil {
+		t.Fatal("expected an error for a missing database")
+	}
+	if names := registry.Names(); !reflect.DeepEqual(names, []string{"orders", "users"}) {
+		t.Fatalf("unexpected names %v", names)
+	}
+	//mockDatabase检查两个连接都被关闭
+	if err := registry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if names := registry.Names(); len(names) != 0 {
+		t.Fatalf("Close should remove every database, got %v", names)
+	}
+}
+
+func TestConfigFromViperInheritsReplicaFields(t *testing.T) {
+	values := map[string]interface{}{
+		"databases.orders.username":         "app",
+		"databases.orders.password":         "secret",
+		"databases.orders.addr":             "primary:3306",
+		"databases.orders.name":             "orders",
+		"databases.orders.max_open_conns":   20,
+		"databases.orders.sticky_window":    "3s",
+		"databases.orders.recheck_interval": "10s",
+		"databases.orders.balance":          "random",
+		"databases.orders.replicas": []map[string]interface{}{
+			{"addr": "replica1:3306"},
+			{"addr": "replica2:3306", "username": "reader", "password": "read", "name": "orders_ro"},
+		},
+	}
+	for key, value := range values {
+		viper.Set(key, value)
+	}
+	t.Cleanup(func() {
+		viper.Set("databases", nil)
+	})
+
+	config := ConfigFromViper("databases.orders")
+	if config.Addr != "primary:3306" || config.MaxOpenConns != 20 || config.StickyWindow != 3*time.Second ||
+		config.RecheckInterval != 10*time.Second || config.Balance != Balance("random") {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if len(config.Replicas) != 2 {
+		t.Fatalf("expected 2 replicas, got %d", len(config.Replicas))
+	}
+	first, second := config.Replicas[0], config.Replicas[1]
+	if first.Addr != "replica1:3306" || first.Username != "app" || first.Password != "secret" || first.Name != "orders" || first.MaxOpenConns != 20 {
+		t.Fatalf("the first replica should inherit the primary settings, got %+v", first)
+	}
+	if second.Username != "reader" || second.Password != "read" || second.Name != "orders_ro" || len(second.Replicas) != 0 {
+		t.Fatalf("the second replica should use its own settings, got %+v", second)
+	}
+	if got := dsn(second); !strings.HasPrefix(got, "reader:read@tcp(replica2:3306)/orders_ro?") || !strings.Contains(got, "parseTime=true") {
+		t.Fatalf("unexpected dsn %s", got)
+	}
+}
+
+func TestInitFailsWithoutRegistering(t *testing.T) {
+	viper.Set("db.addr", "127.0.0.1:1")
+	viper.Set("db.name", "ddd")
+	t.Cleanup(func() {
+		viper.Set("db", nil)
+	})
+	if err := Init(); err == nil {
+		t.Fatal("expected an unreachable database to fail")
+	}
+	if DB != nil || len(Databases.Names()) != 0 {
+		t.Fatalf("a failed Init should not register anything, got %v", Databases.Names())
+	}
+}
@@ -0,0 +1,242 @@
+package mysql
+
+import (
+	log "DDD/infrastructure/config/config"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"go.uber.org/zap"
+
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balance 从库的负载均衡方式
+type Balance string
+
+const (
+	RoundRobin   Balance = "round_robin"   //轮询
+	LeastLatency Balance = "least_latency" //选择ping延迟最小的从库
+)
+
+const (
+	replicaStickyWindow    = time.Second      //写入后读取主库的时间
+	replicaRecheckInterval = 10 * time.Second //检查从库的间隔
+	replicaLatencyWeight   = 0.3              //延迟的指数移动平均中新值的权重
+)
+
+// ReplicaStats 从库的状态
+type ReplicaStats struct {
+	Addr    string        `json:"addr"`
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency"`
+}
+
+type replica struct {
+	addr    string
+	db      *gorm.DB
+	healthy int32
+	latency int64 //纳秒
+}
+
+func newReplica(addr string, db *gorm.DB) *replica {
+	r := &replica{
+		addr:    addr,
+		db:      db,
+		healthy: 1,
+	}
+	//查询时连接出错马上摘除
+	db.Callback().Query().After("gorm:query").Register("replica:eject", func(scope *gorm.Scope) {
+		if isConnError(scope.DB().Error) {
+			r.eject(scope.DB().Error)
+		}
+	})
+	db.Callback().RowQuery().After("gorm:row_query").Register("replica:eject", func(scope *gorm.Scope) {
+		if isConnError(scope.DB().Error) {
+			r.eject(scope.DB().Error)
+		}
+	})
+	return r
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// eject 连接出错时摘除 由检查重新加入
+func (r *replica) eject(err error) {
+	if atomic.CompareAndSwapInt32(&r.healthy, 1, 0) {
+		log.Logger.Error("print-srv:mysql-replica",
+			zap.String("addr", r.addr),
+			zap.Error(err),
+		)
+	}
+}
+
+func (r *replica) check() {
+	start := time.Now()
+	if err := r.db.DB().Ping(); err != nil {
+		r.eject(err)
+		return
+	}
+	latency := float64(time.Since(start))
+	old := atomic.LoadInt64(&r.latency)
+	if old > 0 {
+		latency = replicaLatencyWeight*latency + (1-replicaLatencyWeight)*float64(old)
+	}
+	atomic.StoreInt64(&r.latency, int64(latency))
+	if atomic.CompareAndSwapInt32(&r.healthy, 0, 1) {
+		log.Logger.Info("print-srv:mysql-replica",
+			zap.String("addr", r.addr),
+			zap.String("state", "recovered"),
+		)
+	}
+}
+
+// replicaSet 从库 定时ping 失败的从库被摘除 恢复后重新加入
+type replicaSet struct {
+	replicas []*replica
+	balance  Balance
+	next     uint64
+	quit     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+func openReplicas(configs []Config, balance Balance, recheck time.Duration) (*replicaSet, error) {
+	set := &replicaSet{
+		balance: balance,
+		quit:    make(chan struct{}),
+	}
+	for i := range configs[:] {
+		db, err := openLazy(configs[i])
+		if err != nil {
+			set.close()
+			return nil, err
+		}
+		r := newReplica(configs[i].Addr, db)
+		//连不上的从库记录日志后摘除 由检查重新加入 不影响启动
+		r.check()
+		set.replicas = append(set.replicas, r)
+	}
+	if recheck <= 0 {
+		recheck = replicaRecheckInterval
+	}
+	set.wg.Add(1)
+	go set.run(recheck)
+	return set, nil
+}
+
+func (s *replicaSet) run(recheck time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(recheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+		for i := range s.replicas[:] {
+			s.replicas[i].check()
+		}
+	}
+}
+
+// pick 选择一个可用的从库 都不可用时返回nil
+func (s *replicaSet) pick() *replica {
+	if s.balance == LeastLatency {
+		var picked *replica
+		for i := range s.replicas[:] {
+			r := s.replicas[i]
+			if !r.isHealthy() {
+				continue
+			}
+			if picked == nil || atomic.LoadInt64(&r.latency) < atomic.LoadInt64(&picked.latency) {
+				picked = r
+			}
+		}
+		return picked
+	}
+	n := uint64(len(s.replicas))
+	start := atomic.AddUint64(&s.next, 1)
+	for i := uint64(0); i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *replicaSet) stats() []ReplicaStats {
+	stats := make([]ReplicaStats, 0, len(s.replicas))
+	for i := range s.replicas[:] {
+		stats = append(stats, ReplicaStats{
+			Addr:    s.replicas[i].addr,
+			Healthy: s.replicas[i].isHealthy(),
+			Latency: time.Duration(atomic.LoadInt64(&s.replicas[i].latency)),
+		})
+	}
+	return stats
+}
+
+func (s *replicaSet) close() error {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+	s.wg.Wait()
+	var first error
+	for i := range s.replicas[:] {
+		if err := s.replicas[i].db.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// isConnError 连接不可用的错误 sql错误不摘除从库
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysqldriver.ErrInvalidConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// sessionKey 请求内的读写状态
+type sessionKey struct{}
+
+type session struct {
+	lastWrite int64
+}
+
+// WithSession 开始一个会话 会话内写入后的一段时间读取主库 避免读不到刚写入的数据
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, new(session))
+}
+
+// MarkWrite 记录会话写入了主库
+func MarkWrite(ctx context.Context) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		atomic.StoreInt64(&s.lastWrite, time.Now().UnixNano())
+	}
+}
+
+// Pinned 会话在window内写入过 读取应使用主库
+func Pinned(ctx context.Context, window time.Duration) bool {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok {
+		return false
+	}
+	lastWrite := atomic.LoadInt64(&s.lastWrite)
+	return lastWrite > 0 && time.Since(time.Unix(0, lastWrite)) < window
+}
@@ -0,0 +1,226 @@
+package mysql
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockReplica 检查ping的从库 gorm.Open时会ping一次
+func mockReplica(t *testing.T, addr string) (*replica, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual), sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectPing()
+	db, err := gorm.Open("mysql", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return newReplica(addr, db), mock
+}
+
+func TestReplicaCheckEjectsAndRecovers(t *testing.T) {
+	r, mock := mockReplica(t, "replica1:3306")
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing().WillDelayFor(time.Millisecond)
+
+	r.check()
+	if r.isHealthy() {
+		t.Fatal("a failed ping should eject the replica")
+	}
+	r.check()
+	if !r.isHealthy() {
+		t.Fatal("a successful ping should restore the replica")
+	}
+	if latency := atomic.LoadInt64(&r.latency); latency < int64(time.Millisecond) {
+		t.Fatalf("expected the ping latency to be recorded, got %v", time.Duration(latency))
+	}
+}
+
+func TestReplicaQueryEjectsOnlyOnConnError(t *testing.T) {
+	r, mock := mockReplica(t, "replica1:3306")
+	query := "SELECT * FROM `orders`"
+	mock.ExpectQuery(query).WillReturnError(&mysqldriver.MySQLError{Number: 1146, Message: "Table 'orders' doesn't exist"})
+	mock.ExpectQuery(query).WillReturnError(mysqldriver.ErrInvalidConn)
+
+	var rows []struct{ Id int }
+	if err := r.db.Table("orders").Find(&rows).Error; err == nil {
+		t.Fatal("expected the sql error")
+	}
+	if !r.isHealthy() {
+		t.Fatal("a sql error should not eject the replica")
+	}
+	if err := r.db.Table("orders").Find(&rows).Error; err == nil {
+		t.Fatal("expected the connection error")
+	}
+	if r.isHealthy() {
+		t.Fatal("a connection error should eject the replica")
+	}
+}
+
+func TestReplicaSetRecheckRestoresEjected(t *testing.T) {
+	r, mock := mockReplica(t, "replica1:3306")
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing()
+	set := &replicaSet{replicas: []*replica{r}, quit: make(chan struct{})}
+	set.wg.Add(1)
+	go set.run(20 * time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the replica was not rechecked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(set.quit)
+	set.wg.Wait()
+	if !r.isHealthy() {
+		t.Fatal("the recheck should restore the replica")
+	}
+	if stats := set.stats(); len(stats) != 1 || stats[0].Addr != "replica1:3306" || !stats[0].Healthy {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestReplicaSetPick(t *testing.T) {
+	newSet := func(balance Balance, latencies ...time.Duration) *replicaSet {
+		set := &replicaSet{balance: balance}
+		for i := range latencies {
+			set.replicas = append(set.replicas, &replica{
+				addr:    fmt.Sprintf("replica%d", i+1),
+				healthy: 1,
+				latency: int64(latencies[i]),
+			})
+		}
+		return set
+	}
+	picks := func(set *replicaSet, n int) map[string]int {
+		picked := make(map[string]int)
+		for i := 0; i < n; i++ {
+			if r := set.pick(); r != nil {
+				picked[r.addr]++
+			}
+		}
+		return picked
+	}
+
+	set := newSet(RoundRobin, 0, 0, 0)
+	if got := fmt.Sprint(picks(set, 6)); got != "map[replica1:2 replica2:2 replica3:2]" {
+		t.Fatalf("round robin should spread reads evenly, got %s", got)
+	}
+	set.replicas[1].eject(errors.New("down"))
+	if picked := picks(set, 4); picked["replica2"] != 0 || picked["replica1"]+picked["replica3"] != 4 {
+		t.Fatalf("round robin should skip the ejected replica, got %v", picked)
+	}
+	set.replicas[0].eject(errors.New("down"))
+	set.replicas[2].eject(errors.New("down"))
+	if r := set.pick(); r != nil {
+		t.Fatalf("expected no replica when all are ejected, got %s", r.addr)
+	}
+
+	set = newSet(LeastLatency, 3*time.Millisecond, time.Millisecond, 2*time.Millisecond)
+	if r := set.pick(); r == nil || r.addr != "replica2" {
+		t.Fatalf("expected the fastest replica, got %+v", r)
+	}
+	set.replicas[1].eject(errors.New("down"))
+	if r := set.pick(); r == nil || r.addr != "replica3" {
+		t.Fatalf("expected the fastest healthy replica, got %+v", r)
+	}
+}
+
+func TestReaderStickyWindow(t *testing.T) {
+	primary, _ := mockDB(t)
+	replicaDB, _ := mockDB(t)
+	r := &replica{addr: "replica1:3306", db: replicaDB, healthy: 1}
+	database := &Database{
+		DDD:          primary,
+		replicas:     &replicaSet{replicas: []*replica{r}},
+		stickyWindow: 50 * time.Millisecond,
+	}
+
+	//没有会话时写入不影响读取
+	if database.Writer(context.Background()) != primary || database.Reader(context.Background()) != replicaDB {
+		t.Fatal("reads without a session should go to the replica")
+	}
+
+	ctx := WithSession(context.Background())
+	if database.Reader(ctx) != replicaDB {
+		t.Fatal("reads before a write should go to the replica")
+	}
+	if database.Writer(ctx) != primary {
+		t.Fatal("writes should go to the primary")
+	}
+	if !Pinned(ctx, database.stickyWindow) || database.Reader(ctx) != primary {
+		t.Fatal("reads right after a write should go to the primary")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if Pinned(ctx, database.stickyWindow) || database.Reader(ctx) != replicaDB {
+		t.Fatal("reads after the sticky window should go back to the replica")
+	}
+
+	r.eject(errors.New("down"))
+	if database.Reader(ctx) != primary {
+		t.Fatal("reads should fall back to the primary when no replica is healthy")
+	}
+}
+
+func TestReaderUsesTransaction(t *testing.T) {
+	primary, mock := mockDB(t)
+	replicaDB, _ := mockDB(t)
+	database := &Database{
+		DDD:          primary,
+		replicas:     &replicaSet{replicas: []*replica{{addr: "replica1:3306", db: replicaDB, healthy: 1}}},
+		stickyWindow: time.Second,
+	}
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	err := NewUnitOfWork(primary, nil).Do(context.Background(), func(ctx context.Context) error {
+		tx := DBFromContext(ctx, primary)
+		if tx == primary {
+			return errors.New("expected a transaction in ctx")
+		}
+		if database.Reader(ctx) != tx || database.Writer(ctx) != tx {
+			return errors.New("reads and writes inside a unit of work should use the transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsConnError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("query: %w", mysqldriver.ErrInvalidConn), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{&mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry"}, false},
+		{gorm.ErrRecordNotFound, false},
+	}
+	for _, c := range cases {
+		if got := isConnError(c.err); got != c.want {
+			t.Errorf("isConnError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
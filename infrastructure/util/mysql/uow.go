@@ -0,0 +1,177 @@
+package mysql
+
+import (
+	log "DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/eventbus"
+
+	"github.com/jinzhu/gorm"
+	"go.uber.org/zap"
+
+	"context"
+	"fmt"
+	"sync"
+)
+
+// txKey 每个连接的事务单独保存 同一个context可以同时有多个数据库的事务
+type txKey struct {
+	db *gorm.DB
+}
+
+// currentKey 最内层的事务
+type currentKey struct{}
+
+// unitOfWork 一次事务的状态 嵌套调用共用
+type unitOfWork struct {
+	tx          *gorm.DB
+	bus         eventbus.Bus
+	depth       int
+	afterCommit []func(ctx context.Context)
+	sync.Mutex
+}
+
+// UnitOfWork 在事务中执行函数 事务通过context传给仓储 提交成功后才发布事件
+type UnitOfWork struct {
+	db  *gorm.DB
+	bus eventbus.Bus
+}
+
+// NewUnitOfWork bus为提交后发布事件的总线 为nil时使用eventbus.Default
+func NewUnitOfWork(db *gorm.DB, bus eventbus.Bus) *UnitOfWork {
+	if bus == nil {
+		bus = eventbus.Default
+	}
+	return &UnitOfWork{
+		db:  db,
+		bus: bus,
+	}
+}
+
+// Transaction 使用默认连接和eventbus.Default
+func (db *Database) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return NewUnitOfWork(db.DDD, eventbus.Default).Do(ctx, fn)
+}
+
+// Do 开启事务执行fn fn返回错误或panic时回滚 否则提交 提交后执行AfterCommit注册的函数
+// ctx中已经有同一个连接的事务时使用savepoint 内层回滚不影响外层 内层注册的函数随外层提交后执行
+func (u *UnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if state, ok := ctx.Value(txKey{db: u.db}).(*unitOfWork); ok {
+		return state.savepoint(ctx, fn)
+	}
+	tx := u.db.BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	state := &unitOfWork{
+		tx:  tx,
+		bus: u.bus,
+	}
+	parent := ctx
+	ctx = context.WithValue(ctx, txKey{db: u.db}, state)
+	ctx = context.WithValue(ctx, currentKey{}, state)
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	committed = true
+	MarkWrite(parent)
+	state.commit(parent)
+	return nil
+}
+
+// savepoint 嵌套事务
+func (w *unitOfWork) savepoint(ctx context.Context, fn func(ctx context.Context) error) error {
+	w.Lock()
+	w.depth++
+	name := fmt.Sprintf("sp_%d", w.depth)
+	mark := len(w.afterCommit)
+	w.Unlock()
+	defer func() {
+		w.Lock()
+		w.depth--
+		w.Unlock()
+	}()
+	if err := w.tx.Exec("SAVEPOINT " + name).Error; err != nil {
+		return err
+	}
+	released := false
+	defer func() {
+		if released {
+			return
+		}
+		w.tx.Exec("ROLLBACK TO SAVEPOINT " + name)
+		//丢弃内层注册的函数
+		w.Lock()
+		w.afterCommit = w.afterCommit[:mark]
+		w.Unlock()
+	}()
+	if err := fn(context.WithValue(ctx, currentKey{}, w)); err != nil {
+		return err
+	}
+	if err := w.tx.Exec("RELEASE SAVEPOINT " + name).Error; err != nil {
+		return err
+	}
+	released = true
+	return nil
+}
+
+// commit 按注册顺序执行提交后的函数 ctx中不再有这个事务
+func (w *unitOfWork) commit(ctx context.Context) {
+	w.Lock()
+	fns := w.afterCommit
+	w.afterCommit = nil
+	w.Unlock()
+	for i := range fns[:] {
+		fns[i](ctx)
+	}
+}
+
+// DBFromContext ctx中有db的事务时返回事务 否则返回db
+func DBFromContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if state, ok := ctx.Value(txKey{db: db}).(*unitOfWork); ok {
+		return state.tx
+	}
+	return db
+}
+
+// InTransaction ctx中是否有事务
+func InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(currentKey{}).(*unitOfWork)
+	return ok
+}
+
+// AfterCommit 最外层事务提交后执行fn 回滚时不执行 不在事务中时立即执行
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	state, ok := ctx.Value(currentKey{}).(*unitOfWork)
+	if !ok {
+		fn(ctx)
+		return
+	}
+	state.Lock()
+	state.afterCommit = append(state.afterCommit, fn)
+	state.Unlock()
+}
+
+// Publish 事务提交后发布到UnitOfWork的总线 不在事务中时立即发布到eventbus.Default
+// 事务已经提交 订阅函数的错误只记录日志
+func Publish(ctx context.Context, topic string, args ...interface{}) {
+	bus := eventbus.Default
+	if state, ok := ctx.Value(currentKey{}).(*unitOfWork); ok {
+		bus = state.bus
+	}
+	AfterCommit(ctx, func(ctx context.Context) {
+		if err := bus.PublishCtx(ctx, topic, args...); err != nil {
+			log.Logger.Error("print-srv:unit-of-work",
+				zap.String("topic", topic),
+				zap.Error(err),
+			)
+		}
+	})
+}
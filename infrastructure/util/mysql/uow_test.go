@@ -0,0 +1,203 @@
+package mysql
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// mockDB 使用sqlmock检查事务执行的语句
+func mockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("mysql", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+func exec(ctx context.Context, db *gorm.DB, query string) error {
+	return DBFromContext(ctx, db).Exec(query).Error
+}
+
+func TestUnitOfWorkNestedFailureRollsBackToSavepoint(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT outer").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT failed").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT inner").WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	uow := NewUnitOfWork(db, nil)
+	cause := errors.New("inner failed")
+	var called []string
+	err := uow.Do(context.Background(), func(ctx context.Context) error {
+		if err := exec(ctx, db, "INSERT outer"); err != nil {
+			return err
+		}
+		AfterCommit(ctx, func(ctx context.Context) { called = append(called, "outer") })
+		err := uow.Do(ctx, func(ctx context.Context) error {
+			AfterCommit(ctx, func(ctx context.Context) { called = append(called, "failed") })
+			if err := exec(ctx, db, "INSERT failed"); err != nil {
+				return err
+			}
+			return cause
+		})
+		if !errors.Is(err, cause) {
+			return fmt.Errorf("expected the inner error, got %v", err)
+		}
+		return uow.Do(ctx, func(ctx context.Context) error {
+			AfterCommit(ctx, func(ctx context.Context) { called = append(called, "inner") })
+			return exec(ctx, db, "INSERT inner")
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(called); got != "[outer inner]" {
+		t.Fatalf("callbacks of the rolled back savepoint should be dropped, got %s", got)
+	}
+}
+
+func TestUnitOfWorkOuterFailureRollsBackEverything(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT outer").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT inner").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	uow := NewUnitOfWork(db, nil)
+	cause := errors.New("outer failed")
+	called := 0
+	err := uow.Do(context.Background(), func(ctx context.Context) error {
+		if err := exec(ctx, db, "INSERT outer"); err != nil {
+			return err
+		}
+		AfterCommit(ctx, func(ctx context.Context) { called++ })
+		if err := uow.Do(ctx, func(ctx context.Context) error {
+			AfterCommit(ctx, func(ctx context.Context) { called++ })
+			return exec(ctx, db, "INSERT inner")
+		}); err != nil {
+			return err
+		}
+		return cause
+	})
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected the outer error, got %v", err)
+	}
+	if called != 0 {
+		t.Fatalf("after commit ran %d times on rollback", called)
+	}
+}
+
+func TestUnitOfWorkPanicRollsBack(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	uow := NewUnitOfWork(db, nil)
+	called := 0
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected the panic to propagate")
+			}
+		}()
+		uow.Do(context.Background(), func(ctx context.Context) error {
+			AfterCommit(ctx, func(ctx context.Context) { called++ })
+			panic("boom")
+		})
+	}()
+	if called != 0 {
+		t.Fatalf("after commit ran %d times after panic", called)
+	}
+}
+
+func TestUnitOfWorkAfterCommitRunsAfterOuterCommit(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT inner").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RELEASE SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	uow := NewUnitOfWork(db, nil)
+	var called []string
+	err := uow.Do(context.Background(), func(ctx context.Context) error {
+		AfterCommit(ctx, func(ctx context.Context) {
+			if InTransaction(ctx) {
+				t.Error("after commit should not run inside the transaction")
+			}
+			//提交后执行 sqlmock会检查commit已经执行
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("after commit ran before the commit: %v", err)
+			}
+			called = append(called, "outer")
+		})
+		if err := uow.Do(ctx, func(ctx context.Context) error {
+			return uow.Do(ctx, func(ctx context.Context) error {
+				AfterCommit(ctx, func(ctx context.Context) { called = append(called, "inner") })
+				return exec(ctx, db, "INSERT inner")
+			})
+		}); err != nil {
+			return err
+		}
+		if len(called) != 0 {
+			return fmt.Errorf("after commit ran before the outer commit: %v", called)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(called); got != "[outer inner]" {
+		t.Fatalf("unexpected after commit calls %s", got)
+	}
+
+	//不在事务中时立即执行
+	immediate := false
+	AfterCommit(context.Background(), func(ctx context.Context) { immediate = true })
+	if !immediate {
+		t.Fatal("after commit outside a transaction should run immediately")
+	}
+}
+
+func TestUnitOfWorkCommitFailureSkipsAfterCommit(t *testing.T) {
+	db, mock := mockDB(t)
+	cause := errors.New("connection lost")
+	mock.ExpectBegin()
+	mock.ExpectCommit().WillReturnError(cause)
+
+	called := 0
+	err := NewUnitOfWork(db, nil).Do(context.Background(), func(ctx context.Context) error {
+		AfterCommit(ctx, func(ctx context.Context) { called++ })
+		return nil
+	})
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected the commit error, got %v", err)
+	}
+	if called != 0 {
+		t.Fatalf("after commit ran %d times after a failed commit", called)
+	}
+}
@@ -0,0 +1,82 @@
+package outbox
+
+import (
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/jinzhu/gorm"
+
+	"encoding/json"
+	"time"
+)
+
+const (
+	StatusPending int8 = iota //等待发送
+	StatusSent                //已发送
+	StatusFailed              //超过最大重试次数
+)
+
+// Outbox 事件发件箱 和聚合在同一个事务中写入 由Relay发送到mq
+type Outbox struct {
+	mysql.BaseModel
+	EventId     string     `gorm:"column:event_id;type:varchar(32);unique_index" json:"event_id"`
+	EventType   int8       `gorm:"column:event_type" json:"event_type"`
+	Topic       string     `gorm:"column:topic;type:varchar(255)" json:"topic"`
+	Envelope    string     `gorm:"column:envelope;type:text" json:"envelope"`
+	Delay       int64      `gorm:"column:delay" json:"delay"` //毫秒
+	Status      int8       `gorm:"column:status;index:idx_outbox_status_next" json:"status"`
+	Attempts    int        `gorm:"column:attempts" json:"attempts"`
+	NextRetryAt time.Time  `gorm:"column:next_retry_at;index:idx_outbox_status_next" json:"next_retry_at"`
+	LastError   string     `gorm:"column:last_error;type:text" json:"last_error"`
+	ClaimToken  string     `gorm:"column:claim_token;type:varchar(32)" json:"claim_token"` //领取发送的Relay
+	SentAt      *time.Time `gorm:"column:sent_at" json:"sent_at"`
+}
+
+func (o *Outbox) TableName() string {
+	return "outbox"
+}
+
+// AutoMigrate 创建outbox表
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Outbox{}).Error
+}
+
+// Add 写入事件 tx需要是保存聚合时使用的事务 事务提交后事件才会被发送
+func Add(tx *gorm.DB, eventType int8, topic string, event eventbus.Event, options ...eventbus.PublishOption) error {
+	message, err := eventbus.NewMessage(eventType, topic, event, options...)
+	if err != nil {
+		return err
+	}
+	return AddMessage(tx, message)
+}
+
+// AddMessage 写入已经生成的消息
+func AddMessage(tx *gorm.DB, message *eventbus.Message) error {
+	envelope, err := json.Marshal(message.Envelope)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&Outbox{
+		EventId:     message.Envelope.Id,
+		EventType:   message.EventType,
+		Topic:       message.Topic,
+		Envelope:    string(envelope),
+		Delay:       int64(message.Delay / time.Millisecond),
+		Status:      StatusPending,
+		NextRetryAt: time.Now(),
+	}).Error
+}
+
+// message 还原成待发送的消息
+func (o *Outbox) message() (*eventbus.Message, error) {
+	envelope, err := eventbus.DecodeEnvelope([]byte(o.Envelope))
+	if err != nil {
+		return nil, err
+	}
+	return &eventbus.Message{
+		EventType: o.EventType,
+		Topic:     o.Topic,
+		Envelope:  envelope,
+		Delay:     time.Duration(o.Delay) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,194 @@
+package outbox
+
+import (
+	"DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/pkg/snowflake"
+
+	"github.com/jinzhu/gorm"
+	"go.uber.org/zap"
+
+	"sync"
+	"time"
+)
+
+const (
+	relayInterval    = time.Second
+	relayBatchSize   = 100
+	relayMaxAttempts = 10
+	relayBackoff     = time.Second      //第一次重试的间隔 之后每次翻倍
+	relayMaxBackoff  = 10 * time.Minute //重试间隔上限
+	relayLease       = 5 * time.Minute  //领取后其他实例不能领取的时间
+)
+
+// Relay 定时把outbox中等待发送的事件推送到mq
+type Relay struct {
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Lease       time.Duration //需要大于发送一批的时间 过期后事件会被其他实例重新发送
+
+	db   *gorm.DB
+	bus  eventbus.MqBus
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+func NewRelay(db *gorm.DB, bus eventbus.MqBus) *Relay {
+	return &Relay{
+		Interval:    relayInterval,
+		BatchSize:   relayBatchSize,
+		MaxAttempts: relayMaxAttempts,
+		Lease:       relayLease,
+		db:          db,
+		bus:         bus,
+		quit:        make(chan struct{}),
+	}
+}
+
+// Start 开始发送 Stop之前只能调用一次
+func (r *Relay) Start() {
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop 停止发送 等待正在发送的批次完成
+func (r *Relay) Stop() {
+	r.once.Do(func() {
+		close(r.quit)
+	})
+	r.wg.Wait()
+}
+
+func (r *Relay) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		//一批发满时马上发送下一批
+		for {
+			n, err := r.Relay()
+			if err != nil {
+				config.Logger.Error("Error", zap.Error(err))
+			}
+			if err != nil || n < r.BatchSize {
+				break
+			}
+			select {
+			case <-r.quit:
+				return
+			default:
+			}
+		}
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay 发送一批到期的事件 返回处理的条数
+// 领取和发送分开 发送时不持有行锁 结果只更新仍然由本次领取的行
+func (r *Relay) Relay() (int, error) {
+	rows, token, err := r.claim()
+	if err != nil {
+		return 0, err
+	}
+	var first error
+	for i := range rows[:] {
+		err := r.db.Model(&Outbox{}).
+			Where("id = ? AND claim_token = ?", rows[i].Id, token).
+			Updates(r.publish(&rows[i])).Error
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return len(rows), first
+}
+
+// claim 在短事务中领取一批到期的事件 领取后Lease内不会被其他实例领取
+// SKIP LOCKED跳过其他实例正在领取的行 多个实例不会互相等待 需要mysql8.0
+func (r *Relay) claim() ([]Outbox, string, error) {
+	token := snowflake.BaseNumberString()
+	var rows []Outbox
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+			Where("status = ? AND next_retry_at <= ?", StatusPending, time.Now()).
+			Order("id").
+			Limit(r.BatchSize).
+			Find(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+		ids := make([]uint64, 0, len(rows))
+		for i := range rows[:] {
+			ids = append(ids, rows[i].Id)
+		}
+		return tx.Model(&Outbox{}).Where("id IN (?)", ids).Updates(map[string]interface{}{
+			"claim_token":   token,
+			"next_retry_at": time.Now().Add(r.Lease),
+		}).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return rows, token, nil
+}
+
+// publish 发送一条事件 返回需要更新的字段
+func (r *Relay) publish(row *Outbox) map[string]interface{} {
+	message, err := row.message()
+	if err == nil {
+		err = r.bus.PublishMessage(message)
+	}
+	if err == nil {
+		return map[string]interface{}{
+			"status":     StatusSent,
+			"last_error": "",
+			"sent_at":    time.Now(),
+		}
+	}
+	attempts := row.Attempts + 1
+	config.Logger.Error("print-srv:outbox",
+		zap.String("id", row.EventId),
+		zap.String("topic", row.Topic),
+		zap.Int("attempts", attempts),
+		zap.Error(err),
+	)
+	updates := map[string]interface{}{
+		"attempts":      attempts,
+		"last_error":    err.Error(),
+		"next_retry_at": time.Now().Add(backoff(attempts)),
+	}
+	if attempts >= r.MaxAttempts {
+		updates["status"] = StatusFailed
+	}
+	return updates
+}
+
+// backoff 第attempts次失败后的重试间隔
+func backoff(attempts int) time.Duration {
+	d := relayBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= relayMaxBackoff {
+			return relayMaxBackoff
+		}
+	}
+	return d
+}
+
+// Retry 把发送失败的事件重新放回等待发送
+func Retry(db *gorm.DB, eventId ...string) error {
+	query := db.Model(&Outbox{}).Where("status = ?", StatusFailed)
+	if len(eventId) > 0 {
+		query = query.Where("event_id IN (?)", eventId)
+	}
+	return query.Updates(map[string]interface{}{
+		"status":        StatusPending,
+		"attempts":      0,
+		"next_retry_at": time.Now(),
+	}).Error
+}
@@ -0,0 +1,224 @@
+package outbox
+
+import (
+	"DDD/infrastructure/util/eventbus"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	sqlClaim   = "SELECT * FROM `outbox`  WHERE (status = ? AND next_retry_at <= ?) ORDER BY `id` LIMIT 2 FOR UPDATE SKIP LOCKED"
+	sqlLease   = "UPDATE `outbox` SET `claim_token` = ?, `next_retry_at` = ?, `updated_at` = ?  WHERE (id IN (?,?))"
+	sqlSent    = "UPDATE `outbox` SET `last_error` = ?, `sent_at` = ?, `status` = ?, `updated_at` = ?  WHERE (id = ? AND claim_token = ?)"
+	sqlFailed  = "UPDATE `outbox` SET `attempts` = ?, `last_error` = ?, `next_retry_at` = ?, `updated_at` = ?  WHERE (id = ? AND claim_token = ?)"
+	sqlGivenUp = "UPDATE `outbox` SET `attempts` = ?, `last_error` = ?, `next_retry_at` = ?, `status` = ?, `updated_at` = ?  WHERE (id = ? AND claim_token = ?)"
+)
+
+type orderPaid struct {
+	Id uint64 `json:"id"`
+}
+
+func (orderPaid) EventType() string {
+	return "outbox.order.paid"
+}
+
+func (orderPaid) EventVersion() int {
+	return 1
+}
+
+// fakeBus 记录发送的消息 fail中的事件id发送失败
+type fakeBus struct {
+	eventbus.MqBus
+	sent []string
+	fail map[string]error
+}
+
+func (b *fakeBus) PublishMessage(message *eventbus.Message) error {
+	if err := b.fail[message.Envelope.Id]; err != nil {
+		return err
+	}
+	b.sent = append(b.sent, message.Envelope.Id)
+	return nil
+}
+
+// token 第一次匹配时记录领取的token 之后只匹配同一个token
+type token struct {
+	value driver.Value
+}
+
+func (t *token) Match(v driver.Value) bool {
+	if t.value == nil {
+		t.value = v
+		return v != ""
+	}
+	return t.value == v
+}
+
+// future 检查时间在now之后
+type future struct {
+	after time.Time
+}
+
+func (f future) Match(v driver.Value) bool {
+	at, ok := v.(time.Time)
+	return ok && at.After(f.after)
+}
+
+func mockRelay(t *testing.T) (*Relay, *fakeBus, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("mysql", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	bus := &fakeBus{fail: make(map[string]error)}
+	relay := NewRelay(db, bus)
+	relay.BatchSize = 2
+	relay.MaxAttempts = 3
+	return relay, bus, mock
+}
+
+// outboxRows 等待发送的行 attempts为已经失败的次数
+func outboxRows(t *testing.T, attempts ...int) *sqlmock.Rows {
+	t.Helper()
+	rows := sqlmock.NewRows([]string{"id", "event_id", "event_type", "topic", "envelope", "status", "attempts"})
+	for i := range attempts {
+		message, err := eventbus.NewMessage(eventbus.EventStreams, "order_stream", orderPaid{Id: uint64(i + 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		message.Envelope.Id = string(rune('a' + i))
+		envelope, err := json.Marshal(message.Envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.AddRow(i+1, message.Envelope.Id, eventbus.EventStreams, "order_stream", string(envelope), StatusPending, attempts[i])
+	}
+	return rows
+}
+
+// expectClaim 在一个事务中以SKIP LOCKED读取并写入领取的token和lease
+func expectClaim(mock sqlmock.Sqlmock, rows *sqlmock.Rows, claimed *token, lease time.Time) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlClaim).WithArgs(StatusPending, sqlmock.AnyArg()).WillReturnRows(rows)
+	mock.ExpectExec(sqlLease).WithArgs(claimed, future{lease}, sqlmock.AnyArg(), 1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+}
+
+func TestRelayClaimsThenPublishesOutsideTheTransaction(t *testing.T) {
+	relay, bus, mock := mockRelay(t)
+	claimed := new(token)
+	//lease内其他实例不会领取
+	expectClaim(mock, outboxRows(t, 0, 0), claimed, time.Now().Add(relay.Lease-time.Second))
+	//发送结果只更新仍然属于本次领取的行 每行单独的事务
+	for id := 1; id <= 2; id++ {
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlSent).WithArgs("", sqlmock.AnyArg(), StatusSent, sqlmock.AnyArg(), id, claimed).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	n, err := relay.Relay()
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 relayed, got %d %v", n, err)
+	}
+	if len(bus.sent) != 2 || bus.sent[0] != "a" || bus.sent[1] != "b" {
+		t.Fatalf("sent %v", bus.sent)
+	}
+}
+
+func TestRelayRecordsFailuresAndGivesUp(t *testing.T) {
+	relay, bus, mock := mockRelay(t)
+	cause := errors.New("mq unavailable")
+	bus.fail["a"] = cause
+	bus.fail["b"] = cause
+	claimed := new(token)
+	expectClaim(mock, outboxRows(t, 0, 2), claimed, time.Now())
+	//第一次失败 按backoff等待重试
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlFailed).WithArgs(1, cause.Error(), future{time.Now().Add(backoff(1) - time.Second)}, sqlmock.AnyArg(), 1, claimed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	//达到MaxAttempts 不再发送
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlGivenUp).WithArgs(3, cause.Error(), sqlmock.AnyArg(), StatusFailed, sqlmock.AnyArg(), 2, claimed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if n, err := relay.Relay(); err != nil || n != 2 {
+		t.Fatalf("publish failures are recorded, not returned, got %d %v", n, err)
+	}
+}
+
+func TestRelayLostLeaseDoesNotFail(t *testing.T) {
+	relay, bus, mock := mockRelay(t)
+	claimed := new(token)
+	expectClaim(mock, outboxRows(t, 0, 0), claimed, time.Now())
+	//lease过期后被其他实例领取 token不同 不更新
+	for id := 1; id <= 2; id++ {
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlSent).WithArgs("", sqlmock.AnyArg(), StatusSent, sqlmock.AnyArg(), id, claimed).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+	}
+	if n, err := relay.Relay(); err != nil || n != 2 || len(bus.sent) != 2 {
+		t.Fatalf("expected 2 relayed, got %d %v %v", n, err, bus.sent)
+	}
+}
+
+func TestRelayNothingToClaim(t *testing.T) {
+	relay, bus, mock := mockRelay(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlClaim).WithArgs(StatusPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+	if n, err := relay.Relay(); err != nil || n != 0 || len(bus.sent) != 0 {
+		t.Fatalf("expected nothing relayed, got %d %v %v", n, err, bus.sent)
+	}
+}
+
+func TestRelayClaimErrorRollsBack(t *testing.T) {
+	relay, bus, mock := mockRelay(t)
+	cause := errors.New("lock wait timeout")
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlClaim).WithArgs(StatusPending, sqlmock.AnyArg()).WillReturnError(cause)
+	mock.ExpectRollback()
+	if n, err := relay.Relay(); !errors.Is(err, cause) || n != 0 || len(bus.sent) != 0 {
+		t.Fatalf("expected the claim error, got %d %v %v", n, err, bus.sent)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{10, 512 * time.Second},
+		{11, relayMaxBackoff},
+		{100, relayMaxBackoff},
+	}
+	for _, c := range cases {
+		if got := backoff(c.attempts); got != c.want {
+			t.Fatalf("backoff(%d) = %v, want %v", c.attempts, got, c.want)
+		}
+	}
+}
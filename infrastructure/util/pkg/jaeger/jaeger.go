@@ -1,6 +1,8 @@
 package jaeger
 
 import (
+	"context"
+
 	"github.com/gin-gonic/gin"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/spf13/viper"
@@ -60,3 +62,8 @@ func (t *RequestTrace) Init() error {
 func (t *RequestTrace) SetResponseStatus(c gin.Context) {
 	t.Span.SetTag("status", c.Writer.Status())
 }
+
+// Context returns ctx carrying the request span, pass it to eventbus.PublishCtx to keep the trace in handlers
+func (t *RequestTrace) Context(ctx context.Context) context.Context {
+	return opentracing.ContextWithSpan(ctx, t.Span)
+}
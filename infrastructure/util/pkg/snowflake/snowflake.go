@@ -305,33 +305,42 @@ func (f *ID) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+var (
+	baseNode   *Node
+	baseNodeMu sync.Mutex
+)
+
+// SetNode 设置BaseNumber使用的节点 每个实例的节点需要不同 否则多个实例生成的id会重复
+// 在生成第一个id之前调用
+func SetNode(node int64) error {
+	n, err := NewNode(node)
+	if err != nil {
+		return err
+	}
+	baseNodeMu.Lock()
+	defer baseNodeMu.Unlock()
+	if baseNode != nil {
+		return errors.New("snowflake node is already in use")
+	}
+	baseNode = n
+	return nil
+}
+
+// defaultNode 所有BaseNumber共用一个节点 同一毫秒内生成的id不会重复 没有SetNode时使用节点1
+func defaultNode() *Node {
+	baseNodeMu.Lock()
+	defer baseNodeMu.Unlock()
+	if baseNode == nil {
+		baseNode, _ = NewNode(1)
+	}
+	return baseNode
+}
+
 func BaseNumber() int64 {
-	var (
-		node *Node
-		once sync.Once
-		err  error
-	)
-	once.Do(func() {
-		node, err = NewNode(1)
-		if err != nil {
-			panic(err)
-		}
-	})
-	baseNumber := node.Generate().Int64()
+	baseNumber := defaultNode().Generate().Int64()
 	return baseNumber
 }
 func BaseNumberString() string {
-	var (
-		node *Node
-		once sync.Once
-		err  error
-	)
-	once.Do(func() {
-		node, err = NewNode(1)
-		if err != nil {
-			panic(err)
-		}
-	})
-	baseNumber := node.Generate().String()
+	baseNumber := defaultNode().Generate().String()
 	return baseNumber
 }
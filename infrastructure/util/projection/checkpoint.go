@@ -0,0 +1,96 @@
+package projection
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"sync"
+	"time"
+)
+
+// Checkpoint 投影处理到的位置
+// 事件存储来源为最后处理的事件位置 mq来源为已处理的事件数
+type Checkpoint struct {
+	Id        uint64    `gorm:"primary_key;AUTO_INCREMENT;column:id" json:"-"`
+	Name      string    `gorm:"column:name;type:varchar(64);unique_index" json:"name"`
+	Position  int64     `gorm:"column:position" json:"position"`
+	EventId   string    `gorm:"column:event_id;type:varchar(32)" json:"event_id"` //最后处理的事件
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (c *Checkpoint) TableName() string {
+	return "projection_checkpoint"
+}
+
+// CheckpointStore 保存每个投影的位置
+type CheckpointStore interface {
+	// Load 读取位置 没有保存过时返回位置为0的Checkpoint
+	Load(name string) (*Checkpoint, error)
+	Save(checkpoint *Checkpoint) error
+}
+
+// MysqlCheckpointStore 位置保存在projection_checkpoint表
+type MysqlCheckpointStore struct {
+	db *gorm.DB
+}
+
+func NewMysqlCheckpointStore(db *gorm.DB) *MysqlCheckpointStore {
+	return &MysqlCheckpointStore{
+		db: db,
+	}
+}
+
+// AutoMigrate 创建projection_checkpoint表
+func (s *MysqlCheckpointStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&Checkpoint{}).Error
+}
+
+func (s *MysqlCheckpointStore) Load(name string) (*Checkpoint, error) {
+	checkpoint := new(Checkpoint)
+	err := s.db.Where("name = ?", name).First(checkpoint).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Checkpoint{Name: name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (s *MysqlCheckpointStore) Save(checkpoint *Checkpoint) error {
+	return s.db.Exec("INSERT INTO projection_checkpoint (name, position, event_id, updated_at) VALUES (?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE position = VALUES(position), event_id = VALUES(event_id), updated_at = VALUES(updated_at)",
+		checkpoint.Name,
+		checkpoint.Position,
+		checkpoint.EventId,
+		checkpoint.UpdatedAt,
+	).Error
+}
+
+// MemoryCheckpointStore 位置保存在内存中 用于测试
+type MemoryCheckpointStore struct {
+	checkpoints map[string]Checkpoint
+	sync.RWMutex
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		checkpoints: make(map[string]Checkpoint),
+	}
+}
+
+func (s *MemoryCheckpointStore) Load(name string) (*Checkpoint, error) {
+	s.RLock()
+	defer s.RUnlock()
+	checkpoint, ok := s.checkpoints[name]
+	if !ok {
+		return &Checkpoint{Name: name}, nil
+	}
+	return &checkpoint, nil
+}
+
+func (s *MemoryCheckpointStore) Save(checkpoint *Checkpoint) error {
+	s.Lock()
+	defer s.Unlock()
+	s.checkpoints[checkpoint.Name] = *checkpoint
+	return nil
+}
@@ -0,0 +1,448 @@
+package projection
+
+import (
+	"DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/eventstore"
+
+	"go.uber.org/zap"
+
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	runnerInterval   = time.Second
+	runnerBatchSize  = 100
+	runnerGapTimeout = 5 * time.Second //从发现位置不连续开始等待的时间 超过后认为缺少的事件所在事务已经回滚
+)
+
+const (
+	SourceStore = "store" //轮询事件存储
+	SourceMq    = "mq"    //由MqBus的消费者推送
+)
+
+// ErrNoFeed 没有事件存储时不能从头重建
+var ErrNoFeed = errors.New("投影没有事件存储 不能重建")
+
+// ErrStopped 重建期间Runner已经停止 重建没有完成
+var ErrStopped = errors.New("投影已停止 重建没有完成")
+
+// Default 在main中创建 没有启用投影时为nil
+var Default *Runner
+
+// Projector 把事件写入读模型 同一个事件可能被处理多次 需要是幂等的
+type Projector interface {
+	// Name 投影的名称 用于保存位置
+	Name() string
+	// Handle 处理事件 event为注册类型的指针 类型没有注册时为nil
+	Handle(envelope *eventbus.Envelope, event interface{}) error
+	// Reset 清空读模型 重建时调用
+	Reset() error
+}
+
+// Status 投影的状态
+type Status struct {
+	Name       string    `json:"name"`
+	Source     string    `json:"source"`
+	Position   int64     `json:"position"`
+	EventId    string    `json:"event_id"`
+	Head       int64     `json:"head"` //事件存储最新的位置
+	Lag        int64     `json:"lag"`  //落后的事件数 mq来源为-1
+	Rebuilding bool      `json:"rebuilding"`
+	LastError  string    `json:"last_error,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type projection struct {
+	projector  Projector
+	source     string
+	checkpoint Checkpoint
+	lastError  error
+	rebuilding bool
+	gap        int64      //等待中的缺少的位置 持有run时访问
+	gapSince   time.Time  //第一次发现gap的时间
+	run        sync.Mutex //处理事件和重建互斥
+	sync.RWMutex
+}
+
+func (p *projection) position() Checkpoint {
+	p.RLock()
+	defer p.RUnlock()
+	return p.checkpoint
+}
+
+func (p *projection) setCheckpoint(checkpoint Checkpoint, err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.checkpoint = checkpoint
+	p.lastError = err
+}
+
+func (p *projection) setRebuilding(rebuilding bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.rebuilding = rebuilding
+}
+
+// Runner 把事件分发给投影 每个投影单独保存位置
+// 同一个投影只能在一个实例中运行
+type Runner struct {
+	Interval   time.Duration
+	BatchSize  int
+	GapTimeout time.Duration
+
+	feed        eventstore.Feed
+	checkpoints CheckpointStore
+	projections []*projection
+	names       map[string]*projection
+	mu          sync.RWMutex
+	quit        chan struct{}
+	wg          sync.WaitGroup
+	once        sync.Once
+}
+
+// NewRunner feed为nil时只能注册mq来源的投影 并且不能重建
+func NewRunner(feed eventstore.Feed, checkpoints CheckpointStore) *Runner {
+	return &Runner{
+		Interval:    runnerInterval,
+		BatchSize:   runnerBatchSize,
+		GapTimeout:  runnerGapTimeout,
+		feed:        feed,
+		checkpoints: checkpoints,
+		names:       make(map[string]*projection),
+		quit:        make(chan struct{}),
+	}
+}
+
+// Register 注册轮询事件存储的投影 从保存的位置继续处理
+func (r *Runner) Register(projector Projector) error {
+	if r.feed == nil {
+		return ErrNoFeed
+	}
+	_, err := r.add(projector, SourceStore)
+	return err
+}
+
+// Subscribe 注册由mq推送的投影 返回的处理函数传给StreamsConsumer.HandleEnvelope或HandleDelivery
+// 处理失败时返回错误 由mq重新投递
+func (r *Runner) Subscribe(projector Projector) (eventbus.EnvelopeHandler, error) {
+	p, err := r.add(projector, SourceMq)
+	if err != nil {
+		return nil, err
+	}
+	return func(envelope *eventbus.Envelope, event interface{}) error {
+		p.run.Lock()
+		defer p.run.Unlock()
+		checkpoint := p.position()
+		if err := p.projector.Handle(envelope, event); err != nil {
+			p.setCheckpoint(checkpoint, err)
+			return err
+		}
+		checkpoint.Position++
+		checkpoint.EventId = envelope.Id
+		checkpoint.UpdatedAt = time.Now()
+		if err := r.checkpoints.Save(&checkpoint); err != nil {
+			p.setCheckpoint(p.position(), err)
+			return err
+		}
+		p.setCheckpoint(checkpoint, nil)
+		return nil
+	}, nil
+}
+
+func (r *Runner) add(projector Projector, source string) (*projection, error) {
+	name := projector.Name()
+	checkpoint, err := r.checkpoints.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.names[name]; ok {
+		return nil, fmt.Errorf("投影%s已经注册", name)
+	}
+	p := &projection{
+		projector:  projector,
+		source:     source,
+		checkpoint: *checkpoint,
+	}
+	r.projections = append(r.projections, p)
+	r.names[name] = p
+	return p, nil
+}
+
+func (r *Runner) list() []*projection {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*projection(nil), r.projections...)
+}
+
+func (r *Runner) find(name string) (*projection, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.names[name]
+	if !ok {
+		return nil, fmt.Errorf("投影%s没有注册", name)
+	}
+	return p, nil
+}
+
+// Start 开始轮询事件存储 Stop之前只能调用一次
+func (r *Runner) Start() {
+	if r.feed == nil {
+		return
+	}
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop 停止轮询 等待正在处理的批次完成
+func (r *Runner) Stop() {
+	r.once.Do(func() {
+		close(r.quit)
+	})
+	r.wg.Wait()
+}
+
+func (r *Runner) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.RunOnce(); err != nil {
+			config.Logger.Error("print-srv:projection", zap.Error(err))
+		}
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce 每个事件存储来源的投影处理到最新的事件 返回处理的条数和第一个错误
+func (r *Runner) RunOnce() (int, error) {
+	var total int
+	var first error
+	projections := r.list()
+	for i := range projections[:] {
+		if projections[i].source != SourceStore {
+			continue
+		}
+		n, err := r.catchUp(projections[i])
+		total += n
+		if err != nil && first == nil {
+			first = fmt.Errorf("投影%s: %w", projections[i].projector.Name(), err)
+		}
+	}
+	return total, first
+}
+
+// catchUp 一批处理满时马上处理下一批
+func (r *Runner) catchUp(p *projection) (int, error) {
+	p.run.Lock()
+	defer p.run.Unlock()
+	var total int
+	for {
+		records, err := r.feed.ReadAll(p.position().Position, r.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := r.apply(p, records)
+		total += n
+		if err != nil || n < len(records) || len(records) < r.BatchSize {
+			return total, err
+		}
+		select {
+		case <-r.quit:
+			return total, nil
+		default:
+		}
+	}
+}
+
+// apply 按顺序处理事件 遇到错误时保存已处理的位置
+// 位置不连续时 缺少的事件可能在未提交的事务中 从发现时开始等待GapTimeout后再跳过
+// 事件时间在提交前生成 长事务的事件时间可能早于GapTimeout 所以不能用事件时间判断
+func (r *Runner) apply(p *projection, records []eventstore.Record) (int, error) {
+	checkpoint := p.position()
+	var n int
+	var err error
+	for i := range records[:] {
+		if records[i].Position != checkpoint.Position+1 {
+			if !r.gapExpired(p, checkpoint.Position+1) {
+				break
+			}
+			config.Logger.Warn("print-srv:projection",
+				zap.String("name", p.projector.Name()),
+				zap.Int64("from", checkpoint.Position+1),
+				zap.Int64("to", records[i].Position-1),
+				zap.String("skip", "gap"),
+			)
+		}
+		if err = handle(p.projector, &records[i]); err != nil {
+			break
+		}
+		p.gap = 0
+		checkpoint.Position = records[i].Position
+		checkpoint.EventId = records[i].EventId
+		n++
+	}
+	if n > 0 {
+		checkpoint.UpdatedAt = time.Now()
+		if saveErr := r.checkpoints.Save(&checkpoint); saveErr != nil {
+			//位置没有保存 下次从旧的位置重新处理
+			p.setCheckpoint(p.position(), saveErr)
+			return n, saveErr
+		}
+	}
+	p.setCheckpoint(checkpoint, err)
+	return n, err
+}
+
+// gapExpired 缺少的位置从第一次发现开始是否已经等待了GapTimeout
+func (r *Runner) gapExpired(p *projection, missing int64) bool {
+	if p.gap != missing {
+		p.gap = missing
+		p.gapSince = time.Now()
+	}
+	return time.Since(p.gapSince) >= r.GapTimeout
+}
+
+// handle 解析事件后交给投影 类型没有注册时event为nil
+func handle(projector Projector, record *eventstore.Record) error {
+	envelope := record.Envelope()
+	event, err := envelope.Decode()
+	if err == eventbus.ErrEventNotRegistered {
+		return projector.Handle(envelope, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return projector.Handle(envelope, event)
+}
+
+// Rebuild 清空读模型后从位置0重新处理事件存储中的全部事件 完成后返回
+// mq来源的投影重建期间推送的事件会等待重建完成
+func (r *Runner) Rebuild(name string) error {
+	p, err := r.find(name)
+	if err != nil {
+		return err
+	}
+	if r.feed == nil {
+		return ErrNoFeed
+	}
+	p.setRebuilding(true)
+	defer p.setRebuilding(false)
+	p.run.Lock()
+	defer p.run.Unlock()
+	if err := p.projector.Reset(); err != nil {
+		return err
+	}
+	p.gap = 0
+	checkpoint := Checkpoint{
+		Name:      name,
+		UpdatedAt: time.Now(),
+	}
+	if err := r.checkpoints.Save(&checkpoint); err != nil {
+		return err
+	}
+	p.setCheckpoint(checkpoint, nil)
+	//mq来源的位置为处理的事件数 重放时单独计数
+	var replayed int64
+	var after int64
+	for {
+		records, err := r.feed.ReadAll(after, r.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+		if p.source == SourceStore {
+			n, err := r.apply(p, records)
+			if err != nil {
+				return err
+			}
+			if n < len(records) {
+				//等待不连续的位置 等待期间不持有锁 RunOnce和Stop不需要等待重建
+				wait := r.GapTimeout - time.Since(p.gapSince)
+				p.run.Unlock()
+				stopped := !r.sleep(wait)
+				p.run.Lock()
+				if stopped {
+					return ErrStopped
+				}
+			}
+			after = p.position().Position
+			continue
+		}
+		for i := range records[:] {
+			if err := handle(p.projector, &records[i]); err != nil {
+				p.setCheckpoint(p.position(), err)
+				return err
+			}
+			replayed++
+			after = records[i].Position
+		}
+		checkpoint.Position = replayed
+		checkpoint.EventId = records[len(records)-1].EventId
+		checkpoint.UpdatedAt = time.Now()
+		if err := r.checkpoints.Save(&checkpoint); err != nil {
+			return err
+		}
+		p.setCheckpoint(checkpoint, nil)
+	}
+	return nil
+}
+
+// sleep 等待d 期间收到停止信号返回false
+func (r *Runner) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-r.quit:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Status 所有投影的状态
+func (r *Runner) Status() ([]Status, error) {
+	var head int64
+	if r.feed != nil {
+		var err error
+		if head, err = r.feed.Head(); err != nil {
+			return nil, err
+		}
+	}
+	projections := r.list()
+	status := make([]Status, 0, len(projections))
+	for i := range projections[:] {
+		p := projections[i]
+		p.RLock()
+		s := Status{
+			Name:       p.projector.Name(),
+			Source:     p.source,
+			Position:   p.checkpoint.Position,
+			EventId:    p.checkpoint.EventId,
+			Head:       head,
+			Lag:        -1,
+			Rebuilding: p.rebuilding,
+			UpdatedAt:  p.checkpoint.UpdatedAt,
+		}
+		if p.lastError != nil {
+			s.LastError = p.lastError.Error()
+		}
+		p.RUnlock()
+		if p.source == SourceStore {
+			s.Lag = head - s.Position
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
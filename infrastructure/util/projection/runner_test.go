@@ -0,0 +1,353 @@
+package projection
+
+import (
+	"DDD/infrastructure/config/config"
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/eventstore"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type itemAdded struct {
+	Name string `json:"name"`
+}
+
+func (itemAdded) EventType() string {
+	return "projection.item.added"
+}
+
+func (itemAdded) EventVersion() int {
+	return 1
+}
+
+func init() {
+	eventbus.RegisterEvent(itemAdded{})
+}
+
+// fakeFeed 位置可以不连续的事件存储 用于模拟未提交的事务
+type fakeFeed struct {
+	records []eventstore.Record
+	sync.Mutex
+}
+
+func (f *fakeFeed) add(position int64, occurredAt time.Time) {
+	f.Lock()
+	defer f.Unlock()
+	record := eventstore.Record{
+		Position:     position,
+		EventId:      fmt.Sprintf("e%d", position),
+		AggregateId:  "item:1",
+		Type:         itemAdded{}.EventType(),
+		EventVersion: 1,
+		Data:         []byte(fmt.Sprintf(`{"name":"p%d"}`, position)),
+		OccurredAt:   occurredAt,
+	}
+	i := len(f.records)
+	for i > 0 && f.records[i-1].Position > position {
+		i--
+	}
+	f.records = append(f.records, eventstore.Record{})
+	copy(f.records[i+1:], f.records[i:])
+	f.records[i] = record
+}
+
+func (f *fakeFeed) ReadAll(after int64, limit int) ([]eventstore.Record, error) {
+	f.Lock()
+	defer f.Unlock()
+	var records []eventstore.Record
+	for i := range f.records {
+		if f.records[i].Position > after && (limit <= 0 || len(records) < limit) {
+			records = append(records, f.records[i])
+		}
+	}
+	return records, nil
+}
+
+func (f *fakeFeed) Head() (int64, error) {
+	f.Lock()
+	defer f.Unlock()
+	if len(f.records) == 0 {
+		return 0, nil
+	}
+	return f.records[len(f.records)-1].Position, nil
+}
+
+type recordingProjector struct {
+	names  []string
+	resets int
+	fail   map[string]error
+	sync.Mutex
+}
+
+func (p *recordingProjector) Name() string {
+	return "items"
+}
+
+func (p *recordingProjector) Handle(envelope *eventbus.Envelope, event interface{}) error {
+	p.Lock()
+	defer p.Unlock()
+	added, ok := event.(*itemAdded)
+	if !ok {
+		return fmt.Errorf("unexpected event %T", event)
+	}
+	if err := p.fail[added.Name]; err != nil {
+		return err
+	}
+	p.names = append(p.names, added.Name)
+	return nil
+}
+
+func (p *recordingProjector) Reset() error {
+	p.Lock()
+	defer p.Unlock()
+	p.names = nil
+	p.resets++
+	return nil
+}
+
+func (p *recordingProjector) handled() string {
+	p.Lock()
+	defer p.Unlock()
+	return fmt.Sprint(p.names)
+}
+
+func newTestRunner(t *testing.T, feed eventstore.Feed) (*Runner, *recordingProjector, CheckpointStore) {
+	t.Helper()
+	checkpoints := NewMemoryCheckpointStore()
+	runner := NewRunner(feed, checkpoints)
+	runner.GapTimeout = 50 * time.Millisecond
+	projector := &recordingProjector{fail: make(map[string]error)}
+	if err := runner.Register(projector); err != nil {
+		t.Fatal(err)
+	}
+	return runner, projector, checkpoints
+}
+
+func savedPosition(t *testing.T, checkpoints CheckpointStore) int64 {
+	t.Helper()
+	checkpoint, err := checkpoints.Load("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checkpoint.Position
+}
+
+func TestRunOnceWaitsForGapThenSkipsIt(t *testing.T) {
+	feed := new(fakeFeed)
+	//事件时间早于GapTimeout 不能因此马上跳过
+	old := time.Now().Add(-time.Hour)
+	feed.add(1, old)
+	feed.add(2, old)
+	feed.add(4, old)
+	runner, projector, checkpoints := newTestRunner(t, feed)
+
+	if n, err := runner.RunOnce(); err != nil || n != 2 {
+		t.Fatalf("expected 2 events before the gap, got %d %v", n, err)
+	}
+	if n, _ := runner.RunOnce(); n != 0 {
+		t.Fatalf("gap should still be waited for, processed %d", n)
+	}
+	if position := savedPosition(t, checkpoints); position != 2 {
+		t.Fatalf("expected checkpoint 2, got %d", position)
+	}
+
+	time.Sleep(runner.GapTimeout + 10*time.Millisecond)
+	if n, err := runner.RunOnce(); err != nil || n != 1 {
+		t.Fatalf("expected the gap to be skipped, got %d %v", n, err)
+	}
+	if got := projector.handled(); got != "[p1 p2 p4]" {
+		t.Fatalf("handled %s", got)
+	}
+	if position := savedPosition(t, checkpoints); position != 4 {
+		t.Fatalf("expected checkpoint 4, got %d", position)
+	}
+}
+
+func TestRunOnceHandlesLateFilledGapInOrder(t *testing.T) {
+	feed := new(fakeFeed)
+	feed.add(1, time.Now())
+	feed.add(3, time.Now())
+	runner, projector, checkpoints := newTestRunner(t, feed)
+
+	if n, _ := runner.RunOnce(); n != 1 {
+		t.Fatalf("expected 1 event before the gap, got %d", n)
+	}
+	//长事务提交 事件时间早于发现gap的时间
+	feed.add(2, time.Now().Add(-time.Minute))
+	if n, err := runner.RunOnce(); err != nil || n != 2 {
+		t.Fatalf("expected the filled gap and the rest, got %d %v", n, err)
+	}
+	if got := projector.handled(); got != "[p1 p2 p3]" {
+		t.Fatalf("handled %s", got)
+	}
+	if position := savedPosition(t, checkpoints); position != 3 {
+		t.Fatalf("expected checkpoint 3, got %d", position)
+	}
+
+	//新的gap重新开始计时
+	feed.add(5, time.Now())
+	time.Sleep(runner.GapTimeout + 10*time.Millisecond)
+	if n, _ := runner.RunOnce(); n != 0 {
+		t.Fatalf("a new gap should be waited for, processed %d", n)
+	}
+}
+
+func TestRunOnceKeepsCheckpointOnHandlerError(t *testing.T) {
+	feed := new(fakeFeed)
+	for position := int64(1); position <= 3; position++ {
+		feed.add(position, time.Now())
+	}
+	runner, projector, checkpoints := newTestRunner(t, feed)
+	cause := errors.New("read model unavailable")
+	projector.fail["p2"] = cause
+
+	n, err := runner.RunOnce()
+	if n != 1 || !errors.Is(err, cause) {
+		t.Fatalf("expected 1 event and the handler error, got %d %v", n, err)
+	}
+	if position := savedPosition(t, checkpoints); position != 1 {
+		t.Fatalf("expected checkpoint 1, got %d", position)
+	}
+	status, _ := runner.Status()
+	if status[0].Position != 1 || status[0].Lag != 2 || status[0].LastError == "" {
+		t.Fatalf("unexpected status %+v", status[0])
+	}
+
+	delete(projector.fail, "p2")
+	if n, err := runner.RunOnce(); err != nil || n != 2 {
+		t.Fatalf("expected a retry from the checkpoint, got %d %v", n, err)
+	}
+	if got := projector.handled(); got != "[p1 p2 p3]" {
+		t.Fatalf("handled %s", got)
+	}
+	status, _ = runner.Status()
+	if status[0].Position != 3 || status[0].Lag != 0 || status[0].LastError != "" {
+		t.Fatalf("unexpected status %+v", status[0])
+	}
+}
+
+func TestRunnerResumesFromSavedCheckpoint(t *testing.T) {
+	feed := new(fakeFeed)
+	for position := int64(1); position <= 3; position++ {
+		feed.add(position, time.Now())
+	}
+	checkpoints := NewMemoryCheckpointStore()
+	checkpoints.Save(&Checkpoint{Name: "items", Position: 2})
+	runner := NewRunner(feed, checkpoints)
+	projector := &recordingProjector{}
+	runner.Register(projector)
+	if n, _ := runner.RunOnce(); n != 1 {
+		t.Fatalf("expected 1 event after the checkpoint, got %d", n)
+	}
+	if got := projector.handled(); got != "[p3]" {
+		t.Fatalf("handled %s", got)
+	}
+}
+
+func TestRebuildReplaysMemoryStore(t *testing.T) {
+	store := eventstore.NewMemoryStore()
+	for i := 1; i <= 5; i++ {
+		if _, err := store.Append("item:1", int64(i-1), itemAdded{Name: fmt.Sprintf("p%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner, projector, checkpoints := newTestRunner(t, store)
+	runner.BatchSize = 2
+	if n, _ := runner.RunOnce(); n != 5 {
+		t.Fatalf("expected 5 events, got %d", n)
+	}
+
+	if err := runner.Rebuild("items"); err != nil {
+		t.Fatal(err)
+	}
+	if projector.resets != 1 {
+		t.Fatalf("expected 1 reset, got %d", projector.resets)
+	}
+	if got := projector.handled(); got != "[p1 p2 p3 p4 p5]" {
+		t.Fatalf("rebuild handled %s", got)
+	}
+	if position := savedPosition(t, checkpoints); position != 5 {
+		t.Fatalf("expected checkpoint 5, got %d", position)
+	}
+	if n, _ := runner.RunOnce(); n != 0 {
+		t.Fatalf("nothing should be left after the rebuild, got %d", n)
+	}
+	if err := runner.Rebuild("unknown"); err == nil {
+		t.Fatal("expected an error for an unknown projection")
+	}
+}
+
+func TestGapIsLoggedOnceAsRange(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	logger := config.Logger
+	config.Logger = zap.New(core)
+	t.Cleanup(func() { config.Logger = logger })
+
+	feed := new(fakeFeed)
+	feed.add(1, time.Now())
+	feed.add(1000, time.Now())
+	runner, _, _ := newTestRunner(t, feed)
+	runner.GapTimeout = 0
+	if n, err := runner.RunOnce(); err != nil || n != 2 {
+		t.Fatalf("expected the gap to be skipped, got %d %v", n, err)
+	}
+	entries := logs.FilterField(zap.String("skip", "gap")).All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 gap log, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["from"] != int64(2) || fields["to"] != int64(999) {
+		t.Fatalf("unexpected gap range %v", fields)
+	}
+}
+
+func TestRebuildGapWaitDoesNotBlockRunOnceOrStop(t *testing.T) {
+	feed := new(fakeFeed)
+	feed.add(1, time.Now())
+	feed.add(3, time.Now())
+	runner, projector, _ := newTestRunner(t, feed)
+	runner.GapTimeout = time.Minute
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Rebuild("items")
+	}()
+	//重建处理完位置1后等待位置2
+	deadline := time.Now().Add(time.Second)
+	for projector.handled() != "[p1]" {
+		if time.Now().After(deadline) {
+			t.Fatalf("rebuild did not start, handled %s", projector.handled())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	ran := make(chan struct{})
+	go func() {
+		runner.RunOnce()
+		close(ran)
+	}()
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("RunOnce is blocked by the rebuild gap wait")
+	}
+
+	runner.Stop()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrStopped) {
+			t.Fatalf("expected ErrStopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not interrupt the rebuild gap wait")
+	}
+	if got := projector.handled(); got != "[p1]" {
+		t.Fatalf("the gap should not be skipped before the timeout, handled %s", got)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"bytes"
 	"strconv"
+	"time"
 )
 
 type InitPool struct {
@@ -17,17 +18,37 @@ type InitPool struct {
 
 var Pool *InitPool
 
+// Init 创建连接池并检查redis是否可用 在main中config.Init之后调用
+func Init() error {
+	pool := GetPool()
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		pool.Close()
+		return err
+	}
+	Pool = &InitPool{
+		Pool: pool,
+	}
+	return nil
+}
+
+// GetPool 使用redis.addr 没有配置时使用redis.host和redis.port
 func GetPool() *redis.Pool {
 	var redisConnect bytes.Buffer
-	redisConnect.WriteString(viper.GetString("redis.host"))
-	redisConnect.WriteString(":")
-	redisConnect.WriteString(strconv.Itoa(viper.GetInt("redis.port")))
+	if addr := viper.GetString("redis.addr"); addr != "" {
+		redisConnect.WriteString(addr)
+	} else {
+		redisConnect.WriteString(viper.GetString("redis.host"))
+		redisConnect.WriteString(":")
+		redisConnect.WriteString(strconv.Itoa(viper.GetInt("redis.port")))
+	}
 	db := viper.GetInt("redis.db")
 	pwd := viper.GetString("redis.pwd")
 	pool := &redis.Pool{
 		MaxIdle:     viper.GetInt("redis.maxidle"),
 		MaxActive:   viper.GetInt("redis.maxactive"), //连接池最大连接数量,不确定可以用0（0表示自动定义），按需分配
-		IdleTimeout: 300,                             //连接关闭时间 300秒 （300秒不使用自动关闭）
+		IdleTimeout: 300 * time.Second,               //连接关闭时间 300秒 （300秒不使用自动关闭）
 		Dial: func() (redis.Conn, error) { //要连接的redis数据库
 			c, err := redis.Dial("tcp", redisConnect.String())
 			if err != nil {
@@ -36,12 +57,15 @@ func GetPool() *redis.Pool {
 				)
 				return nil, err
 			}
-			if _, err := c.Do("AUTH", pwd); err != nil {
-				log.Logger.Error("redis-init",
-					zap.Error(err),
-				)
-				c.Close()
-				return nil, err
+			//没有密码时不需要AUTH
+			if pwd != "" {
+				if _, err := c.Do("AUTH", pwd); err != nil {
+					log.Logger.Error("redis-init",
+						zap.Error(err),
+					)
+					c.Close()
+					return nil, err
+				}
 			}
 			if _, err := c.Do("SELECT", db); err != nil {
 				log.Logger.Error("redis-init",
@@ -55,12 +79,6 @@ func GetPool() *redis.Pool {
 	}
 	return pool
 }
-func (pool *InitPool) init() {
-	Pool = &InitPool{
-		Pool: GetPool(),
-	}
-}
-
 func (pool *InitPool) Close() {
 	Pool.Pool.Close()
 }
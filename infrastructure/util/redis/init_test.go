@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/spf13/viper"
+
+	"testing"
+	"time"
+)
+
+func useConfig(t *testing.T, values map[string]interface{}) {
+	t.Helper()
+	for key, value := range values {
+		viper.Set(key, value)
+	}
+	t.Cleanup(func() {
+		for key := range values {
+			viper.Set(key, nil)
+		}
+		if Pool != nil {
+			Pool.Close()
+			Pool = nil
+		}
+	})
+}
+
+func TestInitConnectsWithAddr(t *testing.T) {
+	server := miniredis.RunT(t)
+	useConfig(t, map[string]interface{}{"redis.addr": server.Addr(), "redis.db": 2})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	if Pool == nil || Pool.Pool == nil {
+		t.Fatal("Init should set Pool")
+	}
+	client := NewClient(Pool.Pool.Get())
+	defer client.Close()
+	if err := client.Set("k", "v", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	server.Select(2)
+	if got, _ := server.Get("k"); got != "v" {
+		t.Fatalf("expected the value in db 2, got %q", got)
+	}
+}
+
+func TestInitAuthenticates(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+	useConfig(t, map[string]interface{}{"redis.addr": server.Addr(), "redis.pwd": "wrong"})
+	if err := Init(); err == nil {
+		t.Fatal("expected a wrong password to fail")
+	}
+	if Pool != nil {
+		t.Fatal("a failed Init should not set Pool")
+	}
+	viper.Set("redis.pwd", "secret")
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitFailsWhenUnreachable(t *testing.T) {
+	server := miniredis.RunT(t)
+	addr := server.Addr()
+	server.Close()
+	useConfig(t, map[string]interface{}{"redis.addr": addr})
+	if err := Init(); err == nil {
+		t.Fatal("expected an unreachable redis to fail")
+	}
+}
+
+func TestCompareAndSetAndDel(t *testing.T) {
+	server := miniredis.RunT(t)
+	useConfig(t, map[string]interface{}{"redis.addr": server.Addr()})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(Pool.Pool.Get())
+	defer client.Close()
+	client.Set("lease", "a", time.Minute)
+	if ok, err := client.CompareAndSet("lease", "b", "c", time.Minute); err != nil || ok {
+		t.Fatalf("compare with the wrong value should fail, got %v %v", ok, err)
+	}
+	if ok, err := client.CompareAndSet("lease", "a", "c", time.Minute); err != nil || !ok {
+		t.Fatalf("compare with the right value should succeed, got %v %v", ok, err)
+	}
+	if ok, _ := client.CompareAndDel("lease", "a"); ok {
+		t.Fatal("delete with a stale value should fail")
+	}
+	if ok, _ := client.CompareAndDel("lease", "c"); !ok {
+		t.Fatal("delete with the current value should succeed")
+	}
+	if server.Exists("lease") {
+		t.Fatal("lease should be deleted")
+	}
+}
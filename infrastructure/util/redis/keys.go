@@ -0,0 +1,79 @@
+package redis
+
+import (
+	redisgo "github.com/gomodule/redigo/redis"
+
+	"time"
+)
+
+// ErrNil key不存在
+var ErrNil = redisgo.ErrNil
+
+// SetNX key不存在时写入 ttl为过期时间 返回是否写入成功
+func (s *Client) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	reply, err := redisgo.String(s.pool.Do("SET", key, value, "PX", int64(ttl/time.Millisecond), "NX"))
+	if err == redisgo.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return reply == "OK", nil
+}
+
+// Set 写入 ttl为0时不过期
+func (s *Client) Set(key, value string, ttl time.Duration) error {
+	command := redisgo.Args{}.Add(key).Add(value)
+	if ttl > 0 {
+		command = command.Add("PX").Add(int64(ttl / time.Millisecond))
+	}
+	_, err := s.pool.Do("SET", command...)
+	return err
+}
+
+// Get 读取 key不存在时返回ErrNil
+func (s *Client) Get(key string) (string, error) {
+	return redisgo.String(s.pool.Do("GET", key))
+}
+
+// compareAndSetScript 值等于ARGV[1]时写入ARGV[2]
+var compareAndSetScript = redisgo.NewScript(1, `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// compareAndDelScript 值等于ARGV[1]时删除
+var compareAndDelScript = redisgo.NewScript(1, `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+// CompareAndSet 当前值等于expect时写入value ttl为0时不过期 返回是否写入
+func (s *Client) CompareAndSet(key, expect, value string, ttl time.Duration) (bool, error) {
+	n, err := redisgo.Int(compareAndSetScript.Do(s.pool, key, expect, value, int64(ttl/time.Millisecond)))
+	return n == 1, err
+}
+
+// CompareAndDel 当前值等于expect时删除 返回是否删除
+func (s *Client) CompareAndDel(key, expect string) (bool, error) {
+	n, err := redisgo.Int(compareAndDelScript.Do(s.pool, key, expect))
+	return n == 1, err
+}
+
+// Del 删除
+func (s *Client) Del(key ...string) error {
+	if len(key) == 0 {
+		return nil
+	}
+	_, err := s.pool.Do("DEL", redisgo.Args{}.AddFlat(key)...)
+	return err
+}
@@ -2,6 +2,9 @@ package redis
 
 import (
 	redisgo "github.com/gomodule/redigo/redis"
+
+	"encoding/json"
+	"strings"
 )
 
 type StreamValue struct {
@@ -14,6 +17,22 @@ type StreamData struct {
 	StreamValue
 }
 
+// StreamMessage streams中的一条消息 Id为streams生成的消息id
+type StreamMessage struct {
+	Stream  string
+	Id      string
+	Values  map[string]string
+	Deleted bool //消息已从streams删除 只剩pending记录 Values为nil 需要XAck清除
+}
+
+// PendingMessage 已投递未确认的消息 Idle为空闲时间(毫秒) Count为投递次数
+type PendingMessage struct {
+	Id       string
+	Consumer string
+	Idle     int64
+	Count    int64
+}
+
 /**
     写入命令
 	key=>redis key
@@ -53,9 +72,14 @@ id=> id
 如果我们指定0,消费者组将消费所有Stream历史中的消息记录。
 当然，您可以指定任何其他有效ID。您所知道的是，消费者组将开始消费ID大于您指定的ID的消息。
 因为$表示Stream中当前最大的ID，所以指定$将仅消费新消息。
+option=> 附加参数 例如CREATE时传MKSTREAM 在streams不存在时自动创建
 */
-func (s *Client) XGroup(action, streams, name, id string) error {
-	if _, err := s.pool.Do("XGROUP", action, streams, name, id); err != nil {
+func (s *Client) XGroup(action, streams, name, id string, option ...string) error {
+	command := redisgo.Args{}.Add(action).AddFlat(streams).AddFlat(name).AddFlat(id)
+	for i := range option[:] {
+		command = command.AddFlat(option[i])
+	}
+	if _, err := s.pool.Do("XGROUP", command...); err != nil {
 		return err
 	}
 	return nil
@@ -68,6 +92,7 @@ id=> id 传`>`表示这个消费者只接收从来没有被投递给其他消费
 key=>可以同时接受多个streams
 count=>条数
 block=>超时时间
+读取后立即确认 处理失败的消息不会再被投递 需要处理成功后再确认请使用XReadGroup和XAck
 */
 func (s *Client) XReadGroupAck(group, consumer, id string, key []string, count, block int64) (map[string][]map[string]string, error) {
 	data := make(map[string][]map[string]string)
@@ -81,6 +106,10 @@ func (s *Client) XReadGroupAck(group, consumer, id string, key []string, count,
 	}
 	command = command.AddFlat(id)
 	d, err := redisgo.Values(s.pool.Do("XREADGROUP", command...))
+	if err == redisgo.ErrNil {
+		//block超时没有新消息
+		return data, nil
+	}
 	if err != nil {
 		return data, err
 	}
@@ -147,6 +176,299 @@ func (s *Client) XReadGroupAck(group, consumer, id string, key []string, count,
 	}
 	return data, nil
 }
+/**
+XReadGroup 读取消息但不确认 处理成功后需要调用XAck 否则消息会留在pending列表中
+参数同XReadGroupAck id会对每一个streams生效
+*/
+func (s *Client) XReadGroup(group, consumer, id string, key []string, count, block int64) ([]StreamMessage, error) {
+	messages := make([]StreamMessage, 0)
+	command := redisgo.Args{}.Add("GROUP").AddFlat(group)
+	command = command.AddFlat(consumer)
+	command = command.AddFlat("COUNT").AddFlat(count)
+	command = command.AddFlat("BLOCK").AddFlat(block)
+	command = command.AddFlat("STREAMS")
+	for i := range key[:] {
+		command = command.AddFlat(key[i])
+	}
+	for range key[:] {
+		command = command.AddFlat(id)
+	}
+	d, err := redisgo.Values(s.pool.Do("XREADGROUP", command...))
+	if err == redisgo.ErrNil {
+		//block超时没有新消息
+		return messages, nil
+	}
+	if err != nil {
+		return messages, err
+	}
+	for i := range d[:] {
+		keyGroup, err := redisgo.Values(d[i], nil)
+		if err != nil {
+			return messages, err
+		}
+		k, err := redisgo.String(keyGroup[0], nil)
+		if err != nil {
+			return messages, err
+		}
+		entries, err := parseStreamEntries(k, keyGroup[1])
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, entries...)
+	}
+	return messages, nil
+}
+
+/**
+XAck 确认消息已处理 从消费者组的pending列表中删除
+key=>streams的名称
+group=>组名称
+id=>消息id
+*/
+func (s *Client) XAck(key, group string, id ...string) error {
+	if len(id) == 0 {
+		return nil
+	}
+	command := redisgo.Args{}.Add(key).AddFlat(group)
+	for i := range id[:] {
+		command = command.AddFlat(id[i])
+	}
+	if _, err := s.pool.Do("XACK", command...); err != nil {
+		return err
+	}
+	return nil
+}
+
+/**
+XPending 查看消费者组中已投递但未确认的消息
+key=>streams的名称
+group=>组名称
+start end=> id范围 -和+表示全部
+count=>条数
+consumer=>只查看该消费者的消息 不传为全部
+*/
+func (s *Client) XPending(key, group, start, end string, count int64, consumer ...string) ([]PendingMessage, error) {
+	pending := make([]PendingMessage, 0)
+	command := redisgo.Args{}.Add(key).AddFlat(group)
+	command = command.AddFlat(start).AddFlat(end).AddFlat(count)
+	if len(consumer) > 0 {
+		command = command.AddFlat(consumer[0])
+	}
+	d, err := redisgo.Values(s.pool.Do("XPENDING", command...))
+	if err != nil {
+		return pending, err
+	}
+	for i := range d[:] {
+		values, err := redisgo.Values(d[i], nil)
+		if err != nil {
+			return pending, err
+		}
+		var p PendingMessage
+		if _, err := redisgo.Scan(values, &p.Id, &p.Consumer, &p.Idle, &p.Count); err != nil {
+			return pending, err
+		}
+		pending = append(pending, p)
+	}
+	return pending, nil
+}
+
+/**
+XPendingIdle 查看空闲时间超过minIdle的pending消息 需要redis6.2
+key=>streams的名称
+group=>组名称
+minIdle=>最小空闲时间 毫秒
+start end=> id范围 -和+表示全部 (id表示不包含该id 用于翻页
+count=>条数
+*/
+func (s *Client) XPendingIdle(key, group string, minIdle int64, start, end string, count int64) ([]PendingMessage, error) {
+	pending := make([]PendingMessage, 0)
+	command := redisgo.Args{}.Add(key).AddFlat(group)
+	command = command.AddFlat("IDLE").AddFlat(minIdle)
+	command = command.AddFlat(start).AddFlat(end).AddFlat(count)
+	d, err := redisgo.Values(s.pool.Do("XPENDING", command...))
+	if err != nil {
+		return pending, err
+	}
+	for i := range d[:] {
+		values, err := redisgo.Values(d[i], nil)
+		if err != nil {
+			return pending, err
+		}
+		var p PendingMessage
+		if _, err := redisgo.Scan(values, &p.Id, &p.Consumer, &p.Idle, &p.Count); err != nil {
+			return pending, err
+		}
+		pending = append(pending, p)
+	}
+	return pending, nil
+}
+
+/**
+XClaim 把空闲时间超过minIdle的pending消息转移给consumer 并返回消息内容
+key=>streams的名称
+group=>组名称
+consumer=>接收消息的消费者
+minIdle=>最小空闲时间 毫秒
+id=>消息id
+*/
+func (s *Client) XClaim(key, group, consumer string, minIdle int64, id ...string) ([]StreamMessage, error) {
+	if len(id) == 0 {
+		return make([]StreamMessage, 0), nil
+	}
+	command := redisgo.Args{}.Add(key).AddFlat(group)
+	command = command.AddFlat(consumer).AddFlat(minIdle)
+	for i := range id[:] {
+		command = command.AddFlat(id[i])
+	}
+	d, err := s.pool.Do("XCLAIM", command...)
+	if err != nil {
+		return make([]StreamMessage, 0), err
+	}
+	return parseStreamEntries(key, d)
+}
+
+/**
+XRange 按id范围读取消息
+key=>streams的名称
+start end=> id范围 -和+表示全部
+count=>条数 0为不限制
+*/
+func (s *Client) XRange(key, start, end string, count int64) ([]StreamMessage, error) {
+	command := redisgo.Args{}.Add(key).AddFlat(start).AddFlat(end)
+	if count > 0 {
+		command = command.AddFlat("COUNT").AddFlat(count)
+	}
+	d, err := s.pool.Do("XRANGE", command...)
+	if err != nil {
+		return make([]StreamMessage, 0), err
+	}
+	return parseStreamEntries(key, d)
+}
+
+/**
+XDel 删除消息
+key=>streams的名称
+id=>消息id
+*/
+func (s *Client) XDel(key string, id ...string) error {
+	if len(id) == 0 {
+		return nil
+	}
+	command := redisgo.Args{}.Add(key)
+	for i := range id[:] {
+		command = command.AddFlat(id[i])
+	}
+	if _, err := s.pool.Do("XDEL", command...); err != nil {
+		return err
+	}
+	return nil
+}
+
+/**
+XTrim 裁剪streams到指定长度
+key=>streams的名称
+maxLen=>保留的长度 0为清空
+*/
+func (s *Client) XTrim(key string, maxLen uint64) error {
+	if _, err := s.pool.Do("XTRIM", key, "MAXLEN", maxLen); err != nil {
+		return err
+	}
+	return nil
+}
+
+/**
+XAddDelayed 写入延迟消息 到期后由XPromote写入streams
+delayedKey=>存放延迟消息的有序集合
+deliverAt=>到期时间 毫秒时间戳
+value=>消息内容 需要包含唯一的字段(例如id) 否则相同内容的消息会被合并
+*/
+func (s *Client) XAddDelayed(delayedKey string, deliverAt int64, value ...StreamValue) error {
+	fields := make([]string, 0, len(value)*2)
+	for i := range value[:] {
+		fields = append(fields, value[i].Field, value[i].Value)
+	}
+	member, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if _, err := s.pool.Do("ZADD", delayedKey, deliverAt, member); err != nil {
+		return err
+	}
+	return nil
+}
+
+// promoteScript 把到期的延迟消息原子地从有序集合移动到streams
+var promoteScript = redisgo.NewScript(2, `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for i, member in ipairs(due) do
+	local fields = cjson.decode(member)
+	if tonumber(ARGV[2]) > 0 then
+		redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], '*', unpack(fields))
+	else
+		redis.call('XADD', KEYS[2], '*', unpack(fields))
+	end
+	redis.call('ZREM', KEYS[1], member)
+end
+return #due
+`)
+
+/**
+XPromote 把到期的延迟消息写入streams 返回写入的条数
+delayedKey=>存放延迟消息的有序集合
+key=>streams的名称
+now=>当前时间 毫秒时间戳
+maxLen=>同XAdd
+count=>最多移动的条数
+*/
+func (s *Client) XPromote(delayedKey, key string, now int64, maxLen uint64, count int64) (int, error) {
+	return redisgo.Int(promoteScript.Do(s.pool, delayedKey, key, now, maxLen, count))
+}
+
+// parseStreamEntries 解析[[id, [field, value...]]...]格式的返回值 已被删除的消息只返回id并标记Deleted
+func parseStreamEntries(key string, reply interface{}) ([]StreamMessage, error) {
+	messages := make([]StreamMessage, 0)
+	entries, err := redisgo.Values(reply, nil)
+	if err != nil {
+		return messages, err
+	}
+	for i := range entries[:] {
+		if entries[i] == nil {
+			continue
+		}
+		entry, err := redisgo.Values(entries[i], nil)
+		if err != nil {
+			return messages, err
+		}
+		id, err := redisgo.String(entry[0], nil)
+		if err != nil {
+			return messages, err
+		}
+		if entry[1] == nil {
+			messages = append(messages, StreamMessage{
+				Stream:  key,
+				Id:      id,
+				Deleted: true,
+			})
+			continue
+		}
+		values, err := redisgo.StringMap(entry[1], nil)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, StreamMessage{
+			Stream: key,
+			Id:     id,
+			Values: values,
+		})
+	}
+	return messages, nil
+}
+
 func (s *Client) XReadConversionOne(values map[string][]map[string]string, key string) map[string]string {
 	return values[key][0]
 }
+
+// IsBusyGroup 消费者组已存在
+func IsBusyGroup(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP")
+}
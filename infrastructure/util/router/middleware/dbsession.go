@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DBSession pins reads to the primary database for a short window after the request writes
+func DBSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(mysql.WithSession(c.Request.Context()))
+		c.Next()
+	}
+}
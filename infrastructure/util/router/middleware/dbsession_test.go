@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/gin-gonic/gin"
+
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDBSessionPinsReadsAfterWriteWithinRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(DBSession())
+	engine.POST("/orders", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if mysql.Pinned(ctx, time.Minute) {
+			c.String(http.StatusInternalServerError, "a new request should not be pinned")
+			return
+		}
+		mysql.MarkWrite(ctx)
+		if !mysql.Pinned(ctx, time.Minute) {
+			c.String(http.StatusInternalServerError, "reads after a write should be pinned")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	//每个请求有自己的会话 上一个请求的写入不影响下一个请求
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/orders", nil))
+		if recorder.Code != http.StatusNoContent {
+			t.Fatalf("request %d: %d %s", i, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestWithoutDBSessionWritesAreNotTracked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/orders", func(c *gin.Context) {
+		mysql.MarkWrite(c.Request.Context())
+		if mysql.Pinned(c.Request.Context(), time.Minute) {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/orders", nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("a request without a session should not be pinned, got %d", recorder.Code)
+	}
+}
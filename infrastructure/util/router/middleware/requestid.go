@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"context"
+
 	"github.com/gin-gonic/gin"
 	uuid "github.com/satori/go.uuid"
 )
 
+type requestIdKey struct{}
+
 func RequestId() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check for incoming header, use it if exists
@@ -19,8 +23,17 @@ func RequestId() gin.HandlerFunc {
 		// Expose it for use in the application
 		c.Set("X-Request-Id", requestId)
 
+		// Carry it in the request context so it survives eventbus.PublishCtx
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIdKey{}, requestId))
+
 		// Set X-Request-Id header
 		c.Writer.Header().Set("X-Request-Id", requestId)
 		c.Next()
 	}
 }
+
+// RequestIdFromContext returns the request id stored by RequestId, or "" if there is none
+func RequestIdFromContext(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
@@ -17,6 +17,7 @@ func Load(g *gin.Engine, mw ...gin.HandlerFunc) *gin.Engine {
 	g.Use(gin.Recovery())
 	g.Use(mw...)
 	g.Use(middleware.RequestId())
+	g.Use(middleware.DBSession())
 
 	//pprof.Register(g)
 	// 404 Handler.
@@ -31,6 +32,8 @@ func Load(g *gin.Engine, mw ...gin.HandlerFunc) *gin.Engine {
 		svcd.GET("/disk", sd.DiskCheck)
 		svcd.GET("/cpu", sd.CPUCheck)
 		svcd.GET("/ram", sd.RAMCheck)
+		svcd.GET("/eventbus", sd.EventBusCheck)
+		svcd.GET("/projections", sd.ProjectionCheck)
 	}
 	return g
 }
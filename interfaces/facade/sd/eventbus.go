@@ -0,0 +1,20 @@
+package sd
+
+import (
+	"DDD/infrastructure/util/eventbus"
+
+	"github.com/gin-gonic/gin"
+
+	"net/http"
+)
+
+// @Summary Shows the EventBus worker pool metrics
+// @Description Queue depth and handler latency of the async EventBus worker pools
+// @Tags sd
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} map[string]eventbus.PoolStats
+// @Router /sd/eventbus [get]
+func EventBusCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, eventbus.Default.PoolStats())
+}
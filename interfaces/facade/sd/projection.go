@@ -0,0 +1,29 @@
+package sd
+
+import (
+	"DDD/infrastructure/util/projection"
+
+	"github.com/gin-gonic/gin"
+
+	"net/http"
+)
+
+// @Summary Shows the projection status
+// @Description Last position and lag of each read model projection
+// @Tags sd
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} projection.Status
+// @Router /sd/projections [get]
+func ProjectionCheck(c *gin.Context) {
+	if projection.Default == nil {
+		c.JSON(http.StatusOK, []projection.Status{})
+		return
+	}
+	status, err := projection.Default.Status()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -3,7 +3,12 @@ package main
 import (
 	"DDD/infrastructure/config/config"
 
+	"DDD/infrastructure/util/eventbus"
+	"DDD/infrastructure/util/eventstore"
 	"DDD/infrastructure/util/mysql"
+	"DDD/infrastructure/util/outbox"
+	"DDD/infrastructure/util/pkg/snowflake"
+	"DDD/infrastructure/util/projection"
 	"DDD/infrastructure/util/redis"
 
 	"DDD/infrastructure/util/router"
@@ -24,6 +29,23 @@ import (
 )
 
 func main() {
+	//加载配置文件
+	if err := config.Init(); err != nil {
+		config.Logger.Fatal("config init", zap.Error(err))
+	}
+
+	//id的节点 每个实例需要不同 没有配置时使用默认节点1
+	if !viper.IsSet("node_id") {
+		config.Logger.Warn("node_id is not configured, multiple instances will generate duplicate ids", zap.Int64("node_id", 1))
+	} else if err := snowflake.SetNode(viper.GetInt64("node_id")); err != nil {
+		config.Logger.Fatal("snowflake node", zap.Error(err))
+	}
+
+	//DDD migrate <command> 执行数据库迁移后退出
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
+
 	gin.DebugPrintRouteFunc = func(httpMethod, absolutePath, handlerName string, nuHandlers int) {
 		config.Logger.Info("endpoint",
 			zap.String("httpMethod", httpMethod),
@@ -36,6 +58,16 @@ func main() {
 	// Set gin mode.
 	gin.SetMode(viper.GetString("runmode"))
 
+	//连接数据库
+	if err := mysql.Init(); err != nil {
+		config.Logger.Fatal("mysql init", zap.Error(err))
+	}
+
+	//连接redis streams消费者、inbox和快照使用
+	if err := redis.Init(); err != nil {
+		config.Logger.Fatal("redis init", zap.Error(err))
+	}
+
 	// Create the Gin engine.
 	g := gin.New()
 
@@ -46,6 +78,53 @@ func main() {
 		middleware.Secure,
 	)
 
+	//进程内异步事件的协程池
+	if workers := viper.GetInt("eventbus.workers"); workers > 0 {
+		err := eventbus.Default.SetDefaultPool(eventbus.PoolConfig{
+			Workers:   workers,
+			QueueSize: viper.GetInt("eventbus.queue_size"),
+			Overflow:  eventbus.OverflowPolicy(viper.GetInt("eventbus.overflow")),
+		})
+		if err != nil {
+			config.Logger.Fatal("eventbus pool", zap.Error(err))
+		}
+	}
+
+	//outbox发送
+	var relay *outbox.Relay
+	if viper.GetBool("outbox.relay") {
+		if err := outbox.AutoMigrate(mysql.DB.DDD); err != nil {
+			config.Logger.Fatal("outbox migrate", zap.Error(err))
+		}
+		relay = outbox.NewRelay(mysql.DB.DDD, eventbus.NewMqBus())
+		if d := viper.GetDuration("outbox.interval"); d > 0 {
+			relay.Interval = d
+		}
+		if n := viper.GetInt("outbox.max_attempts"); n > 0 {
+			relay.MaxAttempts = n
+		}
+		if d := viper.GetDuration("outbox.lease"); d > 0 {
+			relay.Lease = d
+		}
+		relay.Start()
+	}
+
+	//投影 读模型由注册到projection.Default的投影维护
+	if viper.GetBool("projection.enabled") {
+		checkpoints := projection.NewMysqlCheckpointStore(mysql.DB.DDD)
+		if err := checkpoints.AutoMigrate(); err != nil {
+			config.Logger.Fatal("projection migrate", zap.Error(err))
+		}
+		projection.Default = projection.NewRunner(eventstore.NewMysqlStore(mysql.DB.DDD), checkpoints)
+		if d := viper.GetDuration("projection.interval"); d > 0 {
+			projection.Default.Interval = d
+		}
+		if n := viper.GetInt("projection.batch_size"); n > 0 {
+			projection.Default.BatchSize = n
+		}
+		projection.Default.Start()
+	}
+
 	// Ping the server to make sure the router is working.
 	go func() {
 		if err := pingServer(); err != nil {
@@ -89,15 +168,33 @@ func main() {
 	defer cancel()
 
 	//关闭mysql
-	defer mysql.DB.Close()
+	defer mysql.Databases.Close()
 	//关闭redis
 	defer redis.Pool.Close()
+	//停止outbox发送
+	if relay != nil {
+		relay.Stop()
+	}
+	//停止投影
+	if projection.Default != nil {
+		projection.Default.Stop()
+	}
+	//停止streams消费者
+	eventbus.StopStreamsConsumers()
+	//关闭rabbitmq
+	defer eventbus.ShutdownRabbitMQ()
 
 	if err := srv.Shutdown(ctx); err != nil {
 		config.Logger.Fatal("Server Shutdown: ",
 			zap.Error(err),
 		)
 	}
+	//等待进程内异步事件处理完成
+	if err := eventbus.Default.WaitAsyncCtx(ctx); err != nil {
+		config.Logger.Error("EventBus WaitAsync: ",
+			zap.Error(err),
+		)
+	}
 	config.Logger.Info("Server exiting")
 }
 
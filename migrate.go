@@ -0,0 +1,120 @@
+package main
+
+import (
+	_ "DDD/infrastructure/migrations"
+	"DDD/infrastructure/util/migrate"
+	"DDD/infrastructure/util/mysql"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const migrateUsage = `usage: DDD migrate <command>
+
+commands:
+  up [-n steps]      执行未执行的迁移 默认全部
+  down [-n steps]    回滚最新的迁移 默认一个
+  status             查看迁移状态
+  create <name>      在migrate.dir中创建sql迁移文件`
+
+// migrateCommand 执行migrate子命令 返回退出码
+func migrateCommand(args []string) int {
+	return runMigrate(args, os.Stdout, os.Stderr, func() (*gorm.DB, func(), error) {
+		if err := mysql.Init(); err != nil {
+			return nil, nil, err
+		}
+		return mysql.DB.DDD, func() { mysql.Databases.Close() }, nil
+	})
+}
+
+// runMigrate open在需要数据库的命令中调用 返回连接和关闭函数
+func runMigrate(args []string, stdout, stderr io.Writer, open func() (*gorm.DB, func(), error)) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, migrateUsage)
+		return 2
+	}
+	dir := viper.GetString("migrate.dir")
+	if dir == "" {
+		dir = "infrastructure/migrations"
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	steps := flags.Int("n", 0, "迁移的数量")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if args[0] == "create" {
+		if flags.NArg() != 1 {
+			fmt.Fprintln(stderr, migrateUsage)
+			return 2
+		}
+		files, err := migrate.Create(dir, flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		for _, file := range files {
+			fmt.Fprintln(stdout, "created", file)
+		}
+		return 0
+	}
+
+	var run func(m *migrate.Migrator) error
+	switch args[0] {
+	case "up":
+		run = func(m *migrate.Migrator) error {
+			done, err := m.Up(*steps)
+			for _, migration := range done {
+				fmt.Fprintf(stdout, "up   %d_%s\n", migration.Version, migration.Name)
+			}
+			return err
+		}
+	case "down":
+		run = func(m *migrate.Migrator) error {
+			done, err := m.Down(*steps)
+			for _, migration := range done {
+				fmt.Fprintf(stdout, "down %d_%s\n", migration.Version, migration.Name)
+			}
+			return err
+		}
+	case "status":
+		run = func(m *migrate.Migrator) error {
+			status, err := m.Status()
+			if err != nil {
+				return err
+			}
+			for _, s := range status {
+				state := "pending"
+				if s.Applied {
+					state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+				}
+				if s.Missing {
+					state += " (missing)"
+				}
+				fmt.Fprintf(stdout, "%d_%s\t%s\t%s\n", s.Version, s.Name, s.Source, state)
+			}
+			return nil
+		}
+	default:
+		fmt.Fprintln(stderr, migrateUsage)
+		return 2
+	}
+
+	db, closeDB, err := open()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer closeDB()
+	if err := run(migrate.New(db, dir)); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMigrateDir migrate.dir指向临时目录 写入sql迁移文件
+func useMigrateDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	viper.Set("migrate.dir", dir)
+	t.Cleanup(func() {
+		viper.Set("migrate.dir", nil)
+	})
+	return dir
+}
+
+// mockOpen 返回sqlmock连接 记录是否打开过数据库
+func mockOpen(t *testing.T, opened *bool) (func() (*gorm.DB, func(), error), sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("mysql", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return func() (*gorm.DB, func(), error) {
+		*opened = true
+		return db, func() {}, nil
+	}, mock
+}
+
+func TestMigrateCommandUsage(t *testing.T) {
+	useMigrateDir(t, nil)
+	cases := [][]string{
+		nil,
+		{"sideways"},
+		{"up", "-x"},
+		{"up", "-n", "many"},
+		{"create"},
+		{"create", "a", "b"},
+	}
+	for _, args := range cases {
+		var opened bool
+		open, _ := mockOpen(t, &opened)
+		var stdout, stderr bytes.Buffer
+		if code := runMigrate(args, &stdout, &stderr, open); code != 2 {
+			t.Fatalf("%q: expected exit code 2, got %d", args, code)
+		}
+		if opened {
+			t.Fatalf("%q: usage errors should not connect to the database", args)
+		}
+	}
+}
+
+func TestMigrateCommandCreate(t *testing.T) {
+	dir := useMigrateDir(t, nil)
+	var opened bool
+	open, _ := mockOpen(t, &opened)
+	var stdout, stderr bytes.Buffer
+	if code := runMigrate([]string{"create", "add_orders"}, &stdout, &stderr, open); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*_add_orders.*.sql"))
+	if len(files) != 2 || opened {
+		t.Fatalf("expected an up and a down file without connecting, got %v", files)
+	}
+	if strings.Count(stdout.String(), "created ") != 2 {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+}
+
+func TestMigrateCommandUpDownStatus(t *testing.T) {
+	useMigrateDir(t, map[string]string{
+		"1_init.up.sql":   "CREATE TABLE a (id INT)",
+		"1_init.down.sql": "DROP TABLE a",
+		"2_seed.up.sql":   "INSERT INTO a VALUES (1)",
+	})
+	//获取迁移锁 schema_migrations不存在时创建 读取已经执行的版本
+	locked := func(mock sqlmock.Sqlmock, applied ...int64) {
+		mock.ExpectQuery("SELECT GET_LOCK(CONCAT('schema_migrations:', DATABASE()), ?)").WillReturnRows(sqlmock.NewRows([]string{"got"}).AddRow(1))
+		mock.ExpectQuery("SELECT DATABASE()").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("ddd"))
+		mock.ExpectQuery("SHOW TABLES FROM `ddd` WHERE `Tables_in_ddd` = ?").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectExec("CREATE TABLE `schema_migrations` (`version` bigint,`name` varchar(255),`applied_at` DATETIME NULL , PRIMARY KEY (`version`))").WillReturnResult(sqlmock.NewResult(0, 0))
+		rows := sqlmock.NewRows([]string{"version", "name", "applied_at"})
+		for _, version := range applied {
+			rows.AddRow(version, "", time.Now())
+		}
+		mock.ExpectQuery("SELECT * FROM `schema_migrations`   ORDER BY `version`").WillReturnRows(rows)
+	}
+	unlocked := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("SELECT RELEASE_LOCK(CONCAT('schema_migrations:', DATABASE()))").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	insert := "INSERT INTO `schema_migrations` (`version`,`name`,`applied_at`) VALUES (?,?,?)"
+
+	t.Run("up", func(t *testing.T) {
+		var opened bool
+		open, mock := mockOpen(t, &opened)
+		locked(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE a (id INT)").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(insert).WithArgs(1, "init", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO a VALUES (1)").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(insert).WithArgs(2, "seed", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		unlocked(mock)
+		var stdout, stderr bytes.Buffer
+		if code := runMigrate([]string{"up"}, &stdout, &stderr, open); code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr.String())
+		}
+		if want := "up   1_init\nup   2_seed\n"; stdout.String() != want {
+			t.Fatalf("got %q, want %q", stdout.String(), want)
+		}
+	})
+
+	t.Run("up failure", func(t *testing.T) {
+		var opened bool
+		open, mock := mockOpen(t, &opened)
+		locked(mock, 1)
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO a VALUES (1)").WillReturnError(errors.New("table a is missing"))
+		mock.ExpectRollback()
+		unlocked(mock)
+		var stdout, stderr bytes.Buffer
+		if code := runMigrate([]string{"up", "-n", "1"}, &stdout, &stderr, open); code != 1 {
+			t.Fatalf("expected exit code 1, got %d", code)
+		}
+		if stdout.Len() != 0 || !strings.Contains(stderr.String(), "2_seed") || !strings.Contains(stderr.String(), "table a is missing") {
+			t.Fatalf("unexpected output %q %q", stdout.String(), stderr.String())
+		}
+	})
+
+	t.Run("down", func(t *testing.T) {
+		var opened bool
+		open, mock := mockOpen(t, &opened)
+		locked(mock, 1)
+		mock.ExpectBegin()
+		mock.ExpectExec("DROP TABLE a").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM `schema_migrations`  WHERE (version = ?)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		unlocked(mock)
+		var stdout, stderr bytes.Buffer
+		if code := runMigrate([]string{"down"}, &stdout, &stderr, open); code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr.String())
+		}
+		if want := "down 1_init\n"; stdout.String() != want {
+			t.Fatalf("got %q, want %q", stdout.String(), want)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		var opened bool
+		open, mock := mockOpen(t, &opened)
+		//只读取 不获取锁也不创建表
+		mock.ExpectQuery("SELECT * FROM `schema_migrations`   ORDER BY `version`").WillReturnRows(
+			sqlmock.NewRows([]string{"version", "name", "applied_at"}).AddRow(1, "init", time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)))
+		var stdout, stderr bytes.Buffer
+		if code := runMigrate([]string{"status"}, &stdout, &stderr, open); code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr.String())
+		}
+		if want := "1_init\tsql\tapplied 2026-10-17 12:00:00\n2_seed\tsql\tpending\n"; stdout.String() != want {
+			t.Fatalf("got %q, want %q", stdout.String(), want)
+		}
+	})
+
+	t.Run("status without table", func(t *testing.T) {
+		var opened bool
+		open, mock := mockOpen(t, &opened)
+		mock.ExpectQuery("SELECT * FROM `schema_migrations`   ORDER BY `version`").WillReturnError(&mysqldriver.MySQLError{Number: 1146, Message: "Table 'ddd.schema_migrations' doesn't exist"})
+		var stdout, stderr bytes.Buffer
+		if code := runMigrate([]string{"status"}, &stdout, &stderr, open); code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr.String())
+		}
+		if want := "1_init\tsql\tpending\n2_seed\tsql\tpending\n"; stdout.String() != want {
+			t.Fatalf("got %q, want %q", stdout.String(), want)
+		}
+	})
+
+	t.Run("open error", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runMigrate([]string{"status"}, &stdout, &stderr, func() (*gorm.DB, func(), error) {
+			return nil, nil, errors.New("connection refused")
+		})
+		if code != 1 || !strings.Contains(stderr.String(), "connection refused") {
+			t.Fatalf("expected exit code 1 with the error, got %d %q", code, stderr.String())
+		}
+	})
+}